@@ -7,9 +7,27 @@ const (
 	GitHubCallbackRel = "/login-github-callback"
 	GoogleLoginRel    = "/login-google"
 	GoogleCallbackRel = "/login-google-callback"
+	LineLoginRel      = "/login-line"
+	LineCallbackRel   = "/login-line-callback"
 
 	GitHubLoginPath    = AuthGroup + GitHubLoginRel
 	GitHubCallbackPath = AuthGroup + GitHubCallbackRel
 	GoogleLoginPath    = AuthGroup + GoogleLoginRel
 	GoogleCallbackPath = AuthGroup + GoogleCallbackRel
+	LineLoginPath      = AuthGroup + LineLoginRel
+	LineCallbackPath   = AuthGroup + LineCallbackRel
 )
+
+// OIDCLoginRel returns the login route of a configured OpenID Connect provider
+func OIDCLoginRel(name string) string {
+	return "/login-" + name
+}
+
+// OIDCCallbackRel returns the callback route of a configured OpenID Connect provider
+func OIDCCallbackRel(name string) string {
+	return "/login-" + name + "-callback"
+}
+
+func OIDCCallbackPath(name string) string {
+	return AuthGroup + OIDCCallbackRel(name)
+}
@@ -32,6 +32,13 @@ func GetVariableAsString(varName string) (string, error) {
 	return value, nil
 }
 
+// LookupVariable reads varName without the cache of GetVariableAsString, for values that may change while the
+// process runs. ok is false when the variable is not set or empty.
+func LookupVariable(varName string) (value string, ok bool) {
+	value = os.Getenv(varName)
+	return value, value != ""
+}
+
 func GetVariableAsByteArr(varName string) ([]byte, error) {
 	value, err := GetVariableAsString(varName)
 	if err != nil {
@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"strconv"
+	"time"
+
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type authEventResponse struct {
+	ID        uint                 `json:"id"`
+	UserID    uint                 `json:"user_id"`
+	Type      models.AuthEventType `json:"type"`
+	Success   bool                 `json:"success"`
+	Email     string               `json:"email"`
+	IP        string               `json:"ip"`
+	UserAgent string               `json:"user_agent"`
+	Details   string               `json:"details"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// ListAuthEvents lists the authentication audit log, newest first.
+// Query: user_id, type, success (true/false), ip, page (from 1), page_size
+func ListAuthEvents(c *gin.Context, db *gorm.DB) {
+	page, pageSize := pagination(c)
+
+	query := db.Model(&models.AuthEvent{})
+	if raw := c.Query("user_id"); raw != "" {
+		userID, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Invalid user id"})
+			return
+		}
+		query = query.Where("user_id = ?", userID)
+	}
+	if eventType := c.Query("type"); eventType != "" {
+		query = query.Where("type = ?", eventType)
+	}
+	if success := c.Query("success"); success != "" {
+		query = query.Where("success = ?", success == "true")
+	}
+	if ip := c.Query("ip"); ip != "" {
+		query = query.Where("ip = ?", ip)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list auth events", "details": err.Error()})
+		return
+	}
+
+	var events []models.AuthEvent
+	if err := query.Order("id DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&events).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list auth events", "details": err.Error()})
+		return
+	}
+
+	result := make([]authEventResponse, 0, len(events))
+	for _, e := range events {
+		result = append(result, authEventResponse{
+			ID:        e.ID,
+			UserID:    e.UserID,
+			Type:      e.Type,
+			Success:   e.Success,
+			Email:     e.Email,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	c.JSON(200, gin.H{"data": result, "page": page, "page_size": pageSize, "total": total})
+}
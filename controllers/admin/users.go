@@ -0,0 +1,288 @@
+package admin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	authController "personal_site/controllers/auth"
+	storageController "personal_site/controllers/storage"
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type userResponse struct {
+	ID            uint                `json:"id"`
+	Nickname      string              `json:"nickname"`
+	Email         string              `json:"email"`
+	Role          models.Role         `json:"role"`
+	Provider      models.AuthProvider `json:"provider"`
+	EmailVerified bool                `json:"email_verified"`
+	TOTPEnabled   bool                `json:"totp_enabled"`
+	Disabled      bool                `json:"disabled"`
+	ExpiresAt     *time.Time          `json:"expires_at"`
+	StorageQuota  *int64              `json:"storage_quota"` // bytes, -1 unlimited, null for the role's quota
+	LockedUntil   *time.Time          `json:"locked_until"`
+	CreatedAt     time.Time           `json:"created_at"`
+	DeletedAt     *time.Time          `json:"deleted_at"`
+}
+
+type updateUserRequest struct {
+	Role      *models.Role `json:"role"`
+	Nickname  *string      `json:"nickname"`
+	Disabled  *bool        `json:"disabled"`
+	ExpiresAt *time.Time   `json:"expires_at"` // extends or shortens a guest account
+	// StorageQuota is a size like "5GB", "unlimited", or "default" to use the quota of the user's role again
+	StorageQuota *string `json:"storage_quota"`
+}
+
+// ListUsers lists users with optional search and paging.
+// Query: q (nickname or email contains), role, include_deleted, page (from 1), page_size
+func ListUsers(c *gin.Context, db *gorm.DB) {
+	page, pageSize := pagination(c)
+
+	query := db.Model(&models.User{})
+	if c.Query("include_deleted") == "true" {
+		query = query.Unscoped()
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + q + "%"
+		query = query.Where("nickname LIKE ? OR email LIKE ?", like, like)
+	}
+	if role := c.Query("role"); role != "" {
+		query = query.Where("role = ?", role)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list users", "details": err.Error()})
+		return
+	}
+
+	var users []models.User
+	if err := query.Order("id").Offset((page - 1) * pageSize).Limit(pageSize).Find(&users).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list users", "details": err.Error()})
+		return
+	}
+
+	result := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		result = append(result, newUserResponse(user))
+	}
+
+	c.JSON(200, gin.H{"data": result, "page": page, "page_size": pageSize, "total": total})
+}
+
+// GetUser returns one user by id, including deleted users
+func GetUser(c *gin.Context, db *gorm.DB) {
+	user, ok := findUser(c, db.Unscoped())
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{"data": newUserResponse(user)})
+}
+
+// UpdateUser changes role, nickname, disabled state, account expiry or storage quota of a user. Disabling also logs the user
+// out everywhere. Guests always expire: turning a user into a guest sets the default expiry unless one is given,
+// turning a guest into another role removes it.
+func UpdateUser(c *gin.Context, db *gorm.DB) {
+	var req updateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := findUser(c, db)
+	if !ok {
+		return
+	}
+
+	updates := map[string]any{}
+	if req.Role != nil {
+		if !req.Role.IsValid() {
+			c.JSON(400, gin.H{"error": "Invalid role"})
+			return
+		}
+		updates["role"] = *req.Role
+	}
+	if req.Nickname != nil {
+		nickname := strings.TrimSpace(*req.Nickname)
+		if nickname == "" || len(nickname) > 64 {
+			c.JSON(400, gin.H{"error": "Nickname must be 1 to 64 characters"})
+			return
+		}
+		updates["nickname"] = nickname
+	}
+	if req.Disabled != nil {
+		updates["disabled"] = *req.Disabled
+	}
+	if req.ExpiresAt != nil {
+		expiresAt, ok := authController.CheckGuestExpiry(c, req.ExpiresAt)
+		if !ok {
+			return
+		}
+		updates["expires_at"] = expiresAt
+	} else if req.Role != nil && *req.Role == models.RoleGuest && user.ExpiresAt == nil {
+		expiresAt, _ := authController.CheckGuestExpiry(c, nil) // the default never fails
+		updates["expires_at"] = expiresAt
+	} else if req.Role != nil && *req.Role != models.RoleGuest && user.Role == models.RoleGuest {
+		updates["expires_at"] = nil
+	}
+	if req.StorageQuota != nil {
+		if *req.StorageQuota == "default" {
+			updates["storage_quota"] = nil
+		} else {
+			quota, err := storageController.ParseStorageQuota(*req.StorageQuota)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "Invalid storage quota", "details": err.Error()})
+				return
+			}
+			updates["storage_quota"] = quota
+		}
+	}
+	if len(updates) == 0 {
+		c.JSON(400, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	// Tokens carry role and nickname, make the middleware re-issue them
+	if req.Role != nil || req.Nickname != nil {
+		updates["token_version"] = gorm.Expr("token_version + 1")
+	}
+
+	// Keep at least the calling admin able to administrate
+	if isSelf(c, user) && ((req.Role != nil && *req.Role != models.RoleAdmin) || (req.Disabled != nil && *req.Disabled)) {
+		c.JSON(400, gin.H{"error": "Admins can not demote or disable themselves"})
+		return
+	}
+
+	if err := db.Model(&user).Updates(updates).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to update user", "details": err.Error()})
+		return
+	}
+
+	if req.Disabled != nil && *req.Disabled {
+		if _, err := authController.RevokeUserSessions(db, user.ID, ""); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to revoke sessions", "details": err.Error()})
+			return
+		}
+	}
+
+	// Respond with the stored row: expressions such as token_version are not copied back into the struct
+	if err := db.First(&user, user.ID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get user", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "User updated", "data": newUserResponse(user)})
+}
+
+// DeleteUser soft-deletes a user (gorm DeletedAt) and revokes all of their sessions
+func DeleteUser(c *gin.Context, db *gorm.DB) {
+	user, ok := findUser(c, db)
+	if !ok {
+		return
+	}
+	if isSelf(c, user) {
+		c.JSON(400, gin.H{"error": "Admins can not delete themselves"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		_, err := authController.RevokeUserSessions(tx, user.ID, "")
+		return err
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete user", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "User deleted"})
+}
+
+// ForceLogout revokes every session of a user
+func ForceLogout(c *gin.Context, db *gorm.DB) {
+	user, ok := findUser(c, db)
+	if !ok {
+		return
+	}
+
+	revoked, err := authController.RevokeUserSessions(db, user.ID, "")
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Sessions revoked", "revoked": revoked})
+}
+
+// findUser loads the user from the :id path parameter, writing an error response when it fails
+func findUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user id"})
+		return models.User{}, false
+	}
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return models.User{}, false
+		}
+		c.JSON(500, gin.H{"error": "Failed to find user", "details": err.Error()})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// pagination reads the page (from 1) and page_size query parameters
+func pagination(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultPageSize)))
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
+	}
+	return page, pageSize
+}
+
+func isSelf(c *gin.Context, user models.User) bool {
+	return utils.GetUserID(c) == user.ID
+}
+
+func newUserResponse(user models.User) userResponse {
+	response := userResponse{
+		ID:            user.ID,
+		Nickname:      user.Nickname,
+		Email:         user.Email,
+		Role:          user.Role,
+		Provider:      user.Provider,
+		EmailVerified: user.EmailVerified,
+		TOTPEnabled:   user.TOTPEnabled,
+		Disabled:      user.Disabled,
+		ExpiresAt:     user.ExpiresAt,
+		StorageQuota:  user.StorageQuota,
+		LockedUntil:   user.LockedUntil,
+		CreatedAt:     user.CreatedAt,
+	}
+	if user.DeletedAt.Valid {
+		response.DeletedAt = &user.DeletedAt.Time
+	}
+	return response
+}
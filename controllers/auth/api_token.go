@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	// APITokenPrefix starts every personal access token so it can be told apart from access tokens (JWT)
+	APITokenPrefix      = "pst_"
+	maxAPITokensPerUser = 50
+)
+
+type createAPITokenRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type apiTokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreateAPIToken creates a personal access token for the logged-in user. The token is only returned this once.
+func CreateAPIToken(c *gin.Context, db *gorm.DB) {
+	var req createAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 64 {
+		c.JSON(400, gin.H{"error": "Name must be 1 to 64 characters"})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(400, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	scopes, ok := parseScopes(c, user.Role, req.Scopes)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := db.Model(&models.APIToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&count).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create token", "details": err.Error()})
+		return
+	}
+	if count >= maxAPITokensPerUser {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("At most %d tokens per user", maxAPITokensPerUser)})
+		return
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+	plain := APITokenPrefix + secret
+
+	token := models.APIToken{
+		UserID:    user.ID,
+		Name:      name,
+		TokenHash: hashToken(plain),
+		Prefix:    plain[:len(APITokenPrefix)+6],
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := db.Create(&token).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create token", "details": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"message": "Token created, copy it now, it will not be shown again",
+		"token":   plain,
+		"data":    newAPITokenResponse(token),
+	})
+}
+
+// ListAPITokens lists the active personal access tokens of the logged-in user
+func ListAPITokens(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var tokens []models.APIToken
+	if err := db.Where("user_id = ? AND revoked_at IS NULL", tokenUser.ID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list tokens", "details": err.Error()})
+		return
+	}
+
+	result := make([]apiTokenResponse, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, newAPITokenResponse(t))
+	}
+
+	c.JSON(200, gin.H{"data": result})
+}
+
+// RevokeAPIToken revokes one personal access token of the logged-in user by id
+func RevokeAPIToken(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid token id"})
+		return
+	}
+
+	result := db.Model(&models.APIToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, tokenUser.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke token", "details": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Token revoked"})
+}
+
+// ValidateAPIToken looks up an active personal access token and returns it with the current state of its user
+func ValidateAPIToken(db *gorm.DB, plain string) (models.APIToken, models.User, error) {
+	var token models.APIToken
+	if err := db.Where("token_hash = ?", hashToken(plain)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return models.APIToken{}, models.User{}, fmt.Errorf("API token not found")
+		}
+		return models.APIToken{}, models.User{}, err
+	}
+	now := time.Now()
+	if !token.IsActive(now) {
+		return models.APIToken{}, models.User{}, fmt.Errorf("API token has been revoked or expired")
+	}
+
+	// Deleted users are not found (soft delete), disabled and expired users are rejected
+	var user models.User
+	if err := db.Select("id", "role", "nickname", "disabled", "expires_at", "storage_dir").First(&user, token.UserID).Error; err != nil {
+		return models.APIToken{}, models.User{}, fmt.Errorf("user not found")
+	}
+	if err := accountError(user); err != nil {
+		return models.APIToken{}, models.User{}, err
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > sessionLastSeenInterval {
+		db.Model(&token).UpdateColumn("last_used_at", now)
+	}
+	return token, user, nil
+}
+
+// parseScopes checks that every requested scope is a permission the role grants, writing an error response when not
+func parseScopes(c *gin.Context, role models.Role, requested []string) ([]string, bool) {
+	if len(requested) == 0 {
+		c.JSON(400, gin.H{"error": "At least one scope is required"})
+		return nil, false
+	}
+
+	scopes := make([]string, 0, len(requested))
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		perm := models.Permission(scope)
+		if !perm.IsValid() {
+			c.JSON(400, gin.H{"error": "Unknown scope", "details": scope})
+			return nil, false
+		}
+		if !role.HasPermission(perm) {
+			c.JSON(403, gin.H{"error": "Scope not allowed for your role", "details": scope})
+			return nil, false
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, true
+}
+
+func newAPITokenResponse(t models.APIToken) apiTokenResponse {
+	return apiTokenResponse{
+		ID:         t.ID,
+		Name:       t.Name,
+		Prefix:     t.Prefix,
+		Scopes:     t.ScopeList(),
+		CreatedAt:  t.CreatedAt,
+		ExpiresAt:  t.ExpiresAt,
+		LastUsedAt: t.LastUsedAt,
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultActivityLimit = 20
+	maxActivityLimit     = 100
+)
+
+type activityResponse struct {
+	ID        uint                 `json:"id"`
+	Type      models.AuthEventType `json:"type"`
+	Success   bool                 `json:"success"`
+	IP        string               `json:"ip"`
+	UserAgent string               `json:"user_agent"`
+	Details   string               `json:"details"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// RecordAuthEvent adds event to the authentication audit log, filling in the client's IP and user agent.
+// Failures are only logged, the audit log never fails the request it describes.
+func RecordAuthEvent(c *gin.Context, db *gorm.DB, event models.AuthEvent) {
+	event.IP = c.ClientIP()
+	event.UserAgent = truncate(c.Request.UserAgent(), 512)
+	event.Email = truncate(event.Email, 128)
+	event.Details = truncate(event.Details, 256)
+	if err := db.Create(&event).Error; err != nil {
+		log.Println("[RecordAuthEvent] error:", err)
+	}
+}
+
+// recordFailedLogin logs a failed login; reason is a short code such as "invalid_password"
+func recordFailedLogin(c *gin.Context, db *gorm.DB, userID uint, email, reason string) {
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: userID, Type: models.AuthEventLoginFailed, Email: email, Details: reason})
+}
+
+// ListSecurityActivity lists the most recent audit log entries of the logged-in user, newest first.
+// Query: limit (default 20, at most 100)
+func ListSecurityActivity(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultActivityLimit)))
+	if limit < 1 || limit > maxActivityLimit {
+		limit = defaultActivityLimit
+	}
+
+	var events []models.AuthEvent
+	if err := db.Where("user_id = ?", tokenUser.ID).Order("id DESC").Limit(limit).Find(&events).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list activity", "details": err.Error()})
+		return
+	}
+
+	result := make([]activityResponse, 0, len(events))
+	for _, e := range events {
+		result = append(result, activityResponse{
+			ID:        e.ID,
+			Type:      e.Type,
+			Success:   e.Success,
+			IP:        e.IP,
+			UserAgent: e.UserAgent,
+			Details:   e.Details,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+
+	c.JSON(200, gin.H{"data": result})
+}
@@ -3,26 +3,31 @@ package auth
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"log"
 	"strings"
+	"time"
 
-	"personal_site/config"
+	"personal_site/controllers/utils"
 	"personal_site/models"
+	"personal_site/passwordhash"
 	"personal_site/schemas"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 type registerRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Nickname string `json:"nickname" binding:"required"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"` // checked by the password policy
+	// InviteCode is required when registration is invite-only, and gives the account the invite's role
+	InviteCode string `json:"invite_code"`
 }
 
 type loginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
 }
 
 type loginResponse struct {
@@ -35,8 +40,8 @@ type loginResponse struct {
 }
 
 type changePasswordRequest struct {
-	OldPassword string `json:"old_password" binding:"required,min=8"`
-	NewPassword string `json:"new_password" binding:"required,min=8"`
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"` // checked by the password policy
 }
 
 func Register(c *gin.Context, db *gorm.DB) {
@@ -46,6 +51,14 @@ func Register(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
+	invite, ok := checkRegistration(c, db, req.Email, req.InviteCode)
+	if !ok {
+		return
+	}
+	if !checkPasswordPolicy(c, req.Password, req.Email, req.Nickname) {
+		return
+	}
+
 	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "unable to hash password"})
@@ -58,13 +71,34 @@ func Register(c *gin.Context, db *gorm.DB) {
 		Provider:   models.AuthProviderPassword,
 		Email:      req.Email,
 		Identifier: string(hashedPassword), // In a real application, you should hash the password
+
+		EmailVerificationRequired: loadRegistrationPolicy().requiresVerifiedEmail(invite != nil),
 	}
 
-	if err := db.Create(&user).Error; err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return createUser(tx, &user, invite)
+	})
+	if errors.Is(err, errInvalidInvite) {
+		writeRegistrationError(c, err)
+		return
+	}
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to create user"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "User registered successfully", "user_id": user.ID})
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: user.ID, Type: models.AuthEventAccountCreated, Success: true, Details: string(models.AuthProviderPassword)})
+
+	// Registration succeeds even if the email can not be sent; the user can ask for it again
+	if err := sendVerificationEmail(db, user); err != nil {
+		log.Println("[Register] send verification email error:", err)
+	}
+
+	c.JSON(200, gin.H{
+		"message": "User registered successfully",
+		"user_id": user.ID,
+		// Accounts let in by their email domain log in once the email is verified
+		"email_verification_required": user.EmailVerificationRequired,
+	})
 }
 
 func Login(c *gin.Context, db *gorm.DB) {
@@ -74,30 +108,73 @@ func Login(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
+	// Throttle by client IP and by the targeted account
+	if !allowRequest(c, loginIPCheck(c), loginEmailCheck(req.Email)) {
+		return
+	}
+
 	// Attempt to login
 	var user models.User
-	err1 := db.Select("ID", "Role", "Nickname", "Identifier").Where("email = ?", req.Email).First(&user).Error // Cannot find user
-	err2 := bcrypt.CompareHashAndPassword([]byte(user.Identifier), []byte(req.Password))                       // Password mismatch
+	err1 := db.Select("ID", "Role", "Nickname", "Email", "EmailVerified", "EmailVerificationRequired", "Identifier", "TOTPEnabled", "Disabled", "ExpiresAt", "FailedLoginCount", "LockedUntil").
+		Where("provider = ? AND email = ?", models.AuthProviderPassword, req.Email).First(&user).Error // Cannot find user
+	if err1 == nil && !checkAccountLock(c, user) {
+		recordFailedLogin(c, db, user.ID, req.Email, "locked")
+		return
+	}
+	match, needsRehash := passwordhash.Default().Verify(req.Password, user.Identifier) // Password mismatch
 
 	// Login failed
-	if err1 != nil || err2 != nil {
+	if err1 != nil || !match {
+		if err1 == nil {
+			if err := recordLoginFailure(db, user); err != nil {
+				log.Println("[Login] record failure error:", err)
+			}
+			recordFailedLogin(c, db, user.ID, req.Email, "invalid_password")
+		} else {
+			recordFailedLogin(c, db, 0, req.Email, "unknown_email")
+		}
 		c.JSON(401, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
+	if !checkAccountEnabled(c, user) {
+		reason := "disabled"
+		if accountError(user) == ErrAccountExpired {
+			reason = "expired"
+		}
+		recordFailedLogin(c, db, user.ID, req.Email, reason)
+		return
+	}
+	if !checkEmailVerified(c, db, user) {
+		recordFailedLogin(c, db, user.ID, req.Email, "email_unverified")
+		return
+	}
+
+	// Hashes made with an older algorithm or weaker parameters are upgraded while the password is at hand
+	if needsRehash {
+		rehashPassword(db, user, req.Password)
+	}
+
+	// Password is correct but a second factor is required
+	if user.TOTPEnabled {
+		mfaToken, err := generatePurposeToken(mfaTokenPurpose, user.ID, mfaTokenExpiration)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+			return
+		}
+		c.JSON(202, gin.H{"message": "MFA code required", "mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
 	// login successful
-	token, err := GenerateToken(schemas.TokenPayload{
-		UserID:   user.ID,
-		Role:     string(user.Role),
-		Nickname: user.Nickname,
-	}, user.ID)
-	if err != nil {
+	if err := resetLoginFailures(db, user); err != nil {
+		log.Println("[Login] reset failures error:", err)
+	}
+	if err := startLoginSession(c, db, user, "password"); err != nil {
 		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
 		return
 	}
 
-	setAuthCookie(c, token)
-
 	c.JSON(200, loginResponse{
 		UserID:   user.ID,
 		Message:  "Login successful",
@@ -106,24 +183,43 @@ func Login(c *gin.Context, db *gorm.DB) {
 	})
 }
 
-func Logout(c *gin.Context) {
-	// 清除 auth_token cookie
+func Logout(c *gin.Context, db *gorm.DB) {
+	// 撤銷 session 讓已發出的 token 立即失效
+	userID, err := revokeCurrentSession(c, db)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to logout"})
+		return
+	}
+	if userID != 0 {
+		RecordAuthEvent(c, db, models.AuthEvent{UserID: userID, Type: models.AuthEventLogout, Success: true})
+	}
+
+	// 清除 auth_token 與 refresh_token cookie
 	removeAuthCookie(c)
+	removeRefreshCookie(c)
 
 	c.JSON(200, gin.H{"message": "Logged out successfully"})
 }
 
 func hashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", err
-	}
-	return string(hashedPassword), nil
+	return passwordhash.Default().Hash(password)
 }
 
 func checkPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+	match, _ := passwordhash.Default().Verify(password, hash)
+	return match
+}
+
+// rehashPassword replaces the stored hash of a password that was just verified with one made by the
+// configured algorithm and parameters. Failures are only logged, the old hash keeps working.
+func rehashPassword(db *gorm.DB, user models.User, password string) {
+	hash, err := hashPassword(password)
+	if err == nil {
+		err = db.Model(&models.User{}).Where("id = ? AND identifier = ?", user.ID, user.Identifier).UpdateColumn("identifier", hash).Error
+	}
+	if err != nil {
+		log.Println("[Login] rehash password error:", err)
+	}
 }
 
 func ChangePassword(c *gin.Context, db *gorm.DB) {
@@ -157,11 +253,24 @@ func ChangePassword(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
+	// Guessing the old password counts against the same limits as guessing it at login
+	if !allowRequest(c, loginIPCheck(c), loginEmailCheck(dbUser.Email)) || !checkAccountLock(c, dbUser) {
+		return
+	}
+
 	if !checkPasswordHash(req.OldPassword, dbUser.Identifier) {
+		if err := recordLoginFailure(db, dbUser); err != nil {
+			log.Println("[ChangePassword] record failure error:", err)
+		}
+		RecordAuthEvent(c, db, models.AuthEvent{UserID: dbUser.ID, Type: models.AuthEventPasswordChanged, Details: "invalid_old_password"})
 		c.JSON(403, gin.H{"error": "Old password is incorrect"})
 		return
 	}
 
+	if !checkPasswordPolicy(c, req.NewPassword, dbUser.Email, dbUser.Nickname) {
+		return
+	}
+
 	newHashedPassword, err := hashPassword(req.NewPassword)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to hash new password"})
@@ -169,14 +278,70 @@ func ChangePassword(c *gin.Context, db *gorm.DB) {
 	}
 
 	dbUser.Identifier = newHashedPassword
-	if err := db.Save(&dbUser).Error; err != nil {
+	dbUser.FailedLoginCount, dbUser.LockedUntil = 0, nil
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&dbUser).Error; err != nil {
+			return err
+		}
+		// Like a reset, log out every other device: a stolen refresh token must not outlive the old password
+		_, err := RevokeUserSessions(tx, dbUser.ID, tokenUser.SessionID)
+		return err
+	})
+	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to update password"})
 		return
 	}
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: dbUser.ID, Type: models.AuthEventPasswordChanged, Success: true})
 
 	c.JSON(200, gin.H{"message": "Password changed successfully"})
 }
 
+// Returned by ValidateSession and ValidateAPIToken for accounts that can no longer be used
+var (
+	ErrAccountDisabled = errors.New("account is disabled") // disabled by an admin
+	ErrAccountExpired  = errors.New("account has expired") // past User.ExpiresAt, e.g. a guest whose access ended
+)
+
+// accountError returns ErrAccountDisabled or ErrAccountExpired when user may not log in or use a session
+func accountError(user models.User) error {
+	if user.Disabled {
+		return ErrAccountDisabled
+	}
+	if user.IsExpired(time.Now()) {
+		return ErrAccountExpired
+	}
+	return nil
+}
+
+// checkAccountEnabled writes 403 and returns false for users disabled by an admin or past their expiry
+func checkAccountEnabled(c *gin.Context, user models.User) bool {
+	switch accountError(user) {
+	case nil:
+		return true
+	case ErrAccountExpired:
+		c.JSON(403, gin.H{"error": "Account expired"})
+	default:
+		c.JSON(403, gin.H{"error": "Account disabled"})
+	}
+	return false
+}
+
+// loadCurrentUser loads the logged-in user from the database, writing an error response when it fails
+func loadCurrentUser(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return models.User{}, false
+	}
+
+	var user models.User
+	if err := db.First(&user, tokenUser.ID).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to find user"})
+		return models.User{}, false
+	}
+	return user, true
+}
+
 // ================= Helpers used by multiple providers =================
 
 func fallbackNickname(parts ...string) string {
@@ -198,11 +363,7 @@ func randomState() string {
 }
 
 func setAuthCookie(c *gin.Context, token string) {
-	exp, err := config.GetVariableAsTimeDuration("DEFAULT_TOKEN_EXPIRATION")
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to get token expiration duration", "details": err.Error()})
-		return
-	}
+	exp := schemas.AccessTokenExpiration()
 
 	c.SetCookie(
 		"auth_token",       // cookie name
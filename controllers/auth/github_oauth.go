@@ -10,7 +10,6 @@ import (
 	"personal_site/apipaths"
 	"personal_site/config"
 	"personal_site/models"
-	"personal_site/schemas"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
@@ -18,6 +17,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// Default GitHub API URL, GITHUB_AUTH_URL, GITHUB_TOKEN_URL and GITHUB_API_URL override the endpoints
+const defaultGitHubAPIURL = "https://api.github.com"
+
 var githubOAuthConfig *oauth2.Config
 
 func getGitHubOAuthConfig() (*oauth2.Config, error) {
@@ -39,8 +41,11 @@ func getGitHubOAuthConfig() (*oauth2.Config, error) {
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		Scopes:       []string{"read:user", "user:email"},
-		Endpoint:     oauthgithub.Endpoint,
-		RedirectURL:  redirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  oauthEndpoint("GITHUB_AUTH_URL", oauthgithub.Endpoint.AuthURL),
+			TokenURL: oauthEndpoint("GITHUB_TOKEN_URL", oauthgithub.Endpoint.TokenURL),
+		},
+		RedirectURL: redirectURL,
 	}
 
 	return githubOAuthConfig, nil
@@ -53,9 +58,11 @@ func GitHubLoginStart(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "GitHub OAuth not configured", "details": err.Error()})
 		return
 	}
-	redirect := c.Query("redirect")
-	state := encodeOAuthState(redirect)
-	authURL := conf.AuthCodeURL(state, oauth2.AccessTypeOnline)
+	state, pkce, ok := beginOAuth(c)
+	if !ok {
+		return
+	}
+	authURL := conf.AuthCodeURL(state, append(pkce, oauth2.AccessTypeOnline)...)
 	c.Redirect(302, authURL)
 }
 
@@ -72,13 +79,12 @@ func GitHubLoginCallback(c *gin.Context, db *gorm.DB) {
 		c.JSON(400, gin.H{"error": "Missing code"})
 		return
 	}
-
-	redirectBack := decodeOAuthStateRedirect(c.Query("state"))
-	if redirectBack == "" {
-		redirectBack = c.Query("redirect")
+	verifier, redirectBack, ok := verifyOAuthCallback(c)
+	if !ok {
+		return
 	}
 
-	token, err := conf.Exchange(context.Background(), code)
+	token, err := conf.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		c.JSON(401, gin.H{"error": "Code exchange failed", "details": err.Error()})
 		return
@@ -90,28 +96,14 @@ func GitHubLoginCallback(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	// Ensure we have an email (GitHub can hide email)
+	// Ensure we have an email (GitHub can hide email); ghEmail is always verified
 	email := ghEmail
 	if email == "" {
 		// Create a synthetic email to satisfy NOT NULL + UNIQUE constraint
 		email = fmt.Sprintf("github_%d@users.noreply.github.local", ghUser.ID)
 	}
 
-	user, err := ensureUserFromOAuth(db, models.AuthProviderGitHub, fmt.Sprintf("%d", ghUser.ID), email, ghUser.Login, ghUser.Name)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Database error", "details": err.Error()})
-		return
-	}
-
-	// Generate our JWT token and set cookie
-	jwtToken, err := GenerateToken(schemas.TokenPayload{UserID: user.ID, Role: string(user.Role), Nickname: user.Nickname}, user.ID)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
-		return
-	}
-
-	setAuthCookie(c, jwtToken)
-	finalizeLoginResponse(c, redirectBack, user, "GitHub login successful")
+	completeOAuthLogin(c, db, redirectBack, models.AuthProviderGitHub, fmt.Sprintf("%d", ghUser.ID), email, ghEmail != "", "GitHub login successful", ghUser.Login, ghUser.Name)
 }
 
 type gitHubUser struct {
@@ -126,9 +118,12 @@ type gitHubEmail struct {
 	Verified bool   `json:"verified"`
 }
 
+// fetchGitHubUser returns the user and their primary email, or another verified one. Unverified emails are
+// never returned: anyone can add any address to a GitHub account.
 func fetchGitHubUser(accessToken string) (*gitHubUser, string, error) {
+	apiURL := oauthEndpoint("GITHUB_API_URL", defaultGitHubAPIURL)
 	client := &http.Client{Timeout: 10 * time.Second}
-	req, _ := http.NewRequest("GET", "https://api.github.com/user", nil)
+	req, _ := http.NewRequest("GET", apiURL+"/user", nil)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	req.Header.Set("Accept", "application/vnd.github+json")
 	resp, err := client.Do(req)
@@ -145,7 +140,7 @@ func fetchGitHubUser(accessToken string) (*gitHubUser, string, error) {
 	}
 
 	// Fetch emails
-	req2, _ := http.NewRequest("GET", "https://api.github.com/user/emails", nil)
+	req2, _ := http.NewRequest("GET", apiURL+"/user/emails", nil)
 	req2.Header.Set("Authorization", "Bearer "+accessToken)
 	req2.Header.Set("Accept", "application/vnd.github+json")
 	resp2, err := client.Do(req2)
@@ -158,15 +153,19 @@ func fetchGitHubUser(accessToken string) (*gitHubUser, string, error) {
 	}
 	var emails []gitHubEmail
 	if err := json.NewDecoder(resp2.Body).Decode(&emails); err == nil {
+		verified := ""
 		for _, e := range emails {
-			if e.Primary && e.Verified && e.Email != "" {
+			if !e.Verified || e.Email == "" {
+				continue
+			}
+			if e.Primary {
 				return &u, e.Email, nil
 			}
+			if verified == "" {
+				verified = e.Email
+			}
 		}
-		// fallback first
-		if len(emails) > 0 && emails[0].Email != "" {
-			return &u, emails[0].Email, nil
-		}
+		return &u, verified, nil
 	}
 	return &u, "", nil
 }
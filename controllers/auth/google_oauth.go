@@ -10,7 +10,6 @@ import (
 	"personal_site/apipaths"
 	"personal_site/config"
 	"personal_site/models"
-	"personal_site/schemas"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
@@ -18,6 +17,9 @@ import (
 	"gorm.io/gorm"
 )
 
+// Default Google userinfo endpoint, GOOGLE_AUTH_URL, GOOGLE_TOKEN_URL and GOOGLE_USERINFO_URL override the endpoints
+const defaultGoogleUserinfoURL = "https://www.googleapis.com/oauth2/v3/userinfo"
+
 var googleOAuthConfig *oauth2.Config
 
 func getGoogleOAuthConfig() (*oauth2.Config, error) {
@@ -38,8 +40,12 @@ func getGoogleOAuthConfig() (*oauth2.Config, error) {
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
 		Scopes:       []string{"openid", "email", "profile"},
-		Endpoint:     oauthgoogle.Endpoint,
-		RedirectURL:  redirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   oauthEndpoint("GOOGLE_AUTH_URL", oauthgoogle.Endpoint.AuthURL),
+			TokenURL:  oauthEndpoint("GOOGLE_TOKEN_URL", oauthgoogle.Endpoint.TokenURL),
+			AuthStyle: oauthgoogle.Endpoint.AuthStyle,
+		},
+		RedirectURL: redirectURL,
 	}
 
 	return googleOAuthConfig, nil
@@ -52,9 +58,11 @@ func GoogleLoginStart(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Google OAuth not configured", "details": err.Error()})
 		return
 	}
-	redirect := c.Query("redirect")
-	state := encodeOAuthState(redirect)
-	authURL := conf.AuthCodeURL(state, oauth2.AccessTypeOnline)
+	state, pkce, ok := beginOAuth(c)
+	if !ok {
+		return
+	}
+	authURL := conf.AuthCodeURL(state, append(pkce, oauth2.AccessTypeOnline)...)
 	fmt.Println("Redirecting to Google OAuth URL:", authURL)
 	c.Redirect(302, authURL)
 }
@@ -71,12 +79,12 @@ func GoogleLoginCallback(c *gin.Context, db *gorm.DB) {
 		c.JSON(400, gin.H{"error": "Missing code"})
 		return
 	}
-	redirectBack := decodeOAuthStateRedirect(c.Query("state"))
-	if redirectBack == "" {
-		redirectBack = c.Query("redirect")
+	verifier, redirectBack, ok := verifyOAuthCallback(c)
+	if !ok {
+		return
 	}
 
-	token, err := conf.Exchange(context.Background(), code)
+	token, err := conf.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		c.JSON(401, gin.H{"error": "Code exchange failed", "details": err.Error()})
 		return
@@ -88,26 +96,12 @@ func GoogleLoginCallback(c *gin.Context, db *gorm.DB) {
 		return
 	}
 
-	email := gu.Email
+	email, emailVerified := gu.Email, gu.EmailVerified
 	if email == "" {
-		email = fmt.Sprintf("google_%s@users.noreply.google.local", gu.Sub)
-	}
-
-	user, err := ensureUserFromOAuth(db, models.AuthProviderGoogle, gu.Sub, email, gu.Name)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Database error", "details": err.Error(), "user": gu})
-		return
-	}
-
-	// Generate JWT and set cookie
-	jwtToken, err := GenerateToken(schemas.TokenPayload{UserID: user.ID, Role: string(user.Role), Nickname: user.Nickname}, user.ID)
-	if err != nil {
-		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
-		return
+		email, emailVerified = fmt.Sprintf("google_%s@users.noreply.google.local", gu.Sub), false
 	}
 
-	setAuthCookie(c, jwtToken)
-	finalizeLoginResponse(c, redirectBack, user, "Google login successful")
+	completeOAuthLogin(c, db, redirectBack, models.AuthProviderGoogle, gu.Sub, email, emailVerified, "Google login successful", gu.Name)
 }
 
 type googleUser struct {
@@ -119,7 +113,7 @@ type googleUser struct {
 
 func fetchGoogleUser(accessToken string) (*googleUser, error) {
 	client := &http.Client{Timeout: 10 * time.Second}
-	req, _ := http.NewRequest("GET", "https://www.googleapis.com/oauth2/v3/userinfo", nil)
+	req, _ := http.NewRequest("GET", oauthEndpoint("GOOGLE_USERINFO_URL", defaultGoogleUserinfoURL), nil)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	resp, err := client.Do(req)
 	if err != nil {
@@ -0,0 +1,285 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"personal_site/mailer"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Guest accounts are time-limited and only read what was shared with them through a models.GuestGrant.
+// They are created by an admin (CreateGuest) or by signing up with a guest invite.
+const (
+	defaultGuestLifetime = 30 * 24 * time.Hour
+	maxGuestLifetime     = 365 * 24 * time.Hour
+	// guestWelcomeExpiration is how long the set-password link of a guest created by an admin works
+	guestWelcomeExpiration = 7 * 24 * time.Hour
+)
+
+type grantRequest struct {
+	Resource string `json:"resource" binding:"required,oneof=storage reurl"`
+	OwnerID  uint   `json:"owner_id"` // storage: user whose folder is shared
+	Path     string `json:"path"`     // storage: folder in the owner's storage, empty for all of it
+	ReurlID  uint   `json:"reurl_id"` // reurl: shared mapping
+}
+
+type grantResponse struct {
+	ID        uint      `json:"id"`
+	UserID    uint      `json:"user_id"`
+	InviteID  uint      `json:"invite_id"`
+	Resource  string    `json:"resource"`
+	OwnerID   uint      `json:"owner_id"`
+	Path      string    `json:"path,omitempty"`
+	ReurlID   uint      `json:"reurl_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type createGuestRequest struct {
+	Email     string         `json:"email" binding:"required,email"`
+	Nickname  string         `json:"nickname" binding:"required"`
+	ExpiresAt *time.Time     `json:"expires_at"`
+	Grants    []grantRequest `json:"grants" binding:"dive"`
+}
+
+// CheckGuestExpiry returns when a guest account stops working: requested, or 30 days from now without it.
+// It writes 400 and returns false when requested is not in the future or more than a year away.
+func CheckGuestExpiry(c *gin.Context, requested *time.Time) (time.Time, bool) {
+	now := time.Now()
+	if requested == nil {
+		return now.Add(defaultGuestLifetime), true
+	}
+	if !requested.After(now) || requested.Sub(now) > maxGuestLifetime {
+		c.JSON(400, gin.H{"error": "Account expiry must be in the future and at most 365 days away"})
+		return time.Time{}, false
+	}
+	return *requested, true
+}
+
+// newGrants checks the requested grants and returns them without UserID or InviteID, writing an error response
+// when one of them is invalid. Storage paths are cleaned so they can not leave the owner's storage.
+func newGrants(c *gin.Context, db *gorm.DB, reqs []grantRequest) ([]models.GuestGrant, bool) {
+	grants := make([]models.GuestGrant, 0, len(reqs))
+	for _, req := range reqs {
+		grant := models.GuestGrant{Resource: req.Resource}
+		switch req.Resource {
+		case models.GrantStorage:
+			var owner models.User
+			if err := db.Select("id").First(&owner, req.OwnerID).Error; err != nil {
+				writeGrantLookupError(c, err, "Owner of the shared folder not found")
+				return nil, false
+			}
+			grant.OwnerID = owner.ID
+			grant.Path = path.Clean("/" + req.Path)
+		case models.GrantReurl:
+			var reurl models.Reurl
+			if err := db.Select("id", "owner_id").First(&reurl, req.ReurlID).Error; err != nil {
+				writeGrantLookupError(c, err, "Shared reurl not found")
+				return nil, false
+			}
+			grant.OwnerID = reurl.OwnerID
+			grant.ReurlID = reurl.ID
+		}
+		grants = append(grants, grant)
+	}
+	return grants, true
+}
+
+func writeGrantLookupError(c *gin.Context, err error, notFound string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(404, gin.H{"error": notFound})
+		return
+	}
+	c.JSON(500, gin.H{"error": "Failed to check grant", "details": err.Error()})
+}
+
+// CreateGuest creates a guest password account with read-only access to the given folders and reurls.
+// The guest gets an email with a link to choose a password; without expires_at the account works for 30 days.
+func CreateGuest(c *gin.Context, db *gorm.DB) {
+	var req createGuestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	expiresAt, ok := CheckGuestExpiry(c, req.ExpiresAt)
+	if !ok {
+		return
+	}
+	grants, ok := newGrants(c, db, req.Grants)
+	if !ok {
+		return
+	}
+
+	var count int64
+	if err := db.Model(&models.User{}).Where("provider = ? AND email = ?", models.AuthProviderPassword, req.Email).Count(&count).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create guest", "details": err.Error()})
+		return
+	}
+	if count > 0 {
+		c.JSON(409, gin.H{"error": "An account with this email already exists"})
+		return
+	}
+
+	// Without a password hash nobody can log in until the guest sets one with the emailed link
+	guest := models.User{
+		Nickname:  req.Nickname,
+		Role:      models.RoleGuest,
+		Provider:  models.AuthProviderPassword,
+		Email:     req.Email,
+		ExpiresAt: &expiresAt,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&guest).Error; err != nil {
+			return err
+		}
+		for i := range grants {
+			grants[i].UserID = guest.ID
+		}
+		if len(grants) == 0 {
+			return nil
+		}
+		return tx.Create(&grants).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create guest", "details": err.Error()})
+		return
+	}
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: guest.ID, Type: models.AuthEventAccountCreated, Success: true, Details: "guest"})
+
+	// The admin can send a new link with the forgot-password flow if this fails
+	if err := sendGuestWelcomeEmail(db, guest); err != nil {
+		log.Println("[CreateGuest] send welcome email error:", err)
+	}
+
+	c.JSON(201, gin.H{"message": "Guest created", "user_id": guest.ID, "expires_at": expiresAt, "grants": newGrantResponses(grants)})
+}
+
+func sendGuestWelcomeEmail(db *gorm.DB, user models.User) error {
+	token, err := issueOneTimeToken(db, resetPasswordPurpose, user.ID, guestWelcomeExpiration)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nA guest account was created for you, it works until %s. "+
+		"Choose a password by opening the link below within %s:\n\n%s\n",
+		user.Nickname, user.ExpiresAt.Format(time.DateOnly), guestWelcomeExpiration, tokenLink("RESET_PASSWORD_URL", token))
+	return mailer.Default().Send(user.Email, "Your guest account", body)
+}
+
+// ================= Admin: grants =================
+
+// ListGrants lists what a user has been given read access to
+func ListGrants(c *gin.Context, db *gorm.DB) {
+	user, ok := findGrantee(c, db)
+	if !ok {
+		return
+	}
+
+	var grants []models.GuestGrant
+	if err := db.Where("user_id = ?", user.ID).Order("id").Find(&grants).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list grants", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": newGrantResponses(grants)})
+}
+
+// AddGrant gives a user read access to one more folder or reurl
+func AddGrant(c *gin.Context, db *gorm.DB) {
+	var req grantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	user, ok := findGrantee(c, db)
+	if !ok {
+		return
+	}
+	grants, ok := newGrants(c, db, []grantRequest{req})
+	if !ok {
+		return
+	}
+
+	grant := grants[0]
+	grant.UserID = user.ID
+	if err := db.Create(&grant).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create grant", "details": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"data": newGrantResponse(grant)})
+}
+
+// DeleteGrant takes back a grant of the user
+func DeleteGrant(c *gin.Context, db *gorm.DB) {
+	user, ok := findGrantee(c, db)
+	if !ok {
+		return
+	}
+	var grantID uint
+	if _, err := fmt.Sscanf(c.Param("grant_id"), "%d", &grantID); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid grant id"})
+		return
+	}
+
+	result := db.Where("id = ? AND user_id = ?", grantID, user.ID).Delete(&models.GuestGrant{})
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete grant", "details": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(404, gin.H{"error": "Grant not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Grant deleted"})
+}
+
+// findGrantee loads the user from the :id path parameter, writing an error response when it fails
+func findGrantee(c *gin.Context, db *gorm.DB) (models.User, bool) {
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user id"})
+		return models.User{}, false
+	}
+
+	var user models.User
+	if err := db.Select("id").First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return models.User{}, false
+		}
+		c.JSON(500, gin.H{"error": "Failed to find user", "details": err.Error()})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+func newGrantResponses(grants []models.GuestGrant) []grantResponse {
+	result := make([]grantResponse, 0, len(grants))
+	for _, grant := range grants {
+		result = append(result, newGrantResponse(grant))
+	}
+	return result
+}
+
+func newGrantResponse(grant models.GuestGrant) grantResponse {
+	return grantResponse{
+		ID:        grant.ID,
+		UserID:    grant.UserID,
+		InviteID:  grant.InviteID,
+		Resource:  grant.Resource,
+		OwnerID:   grant.OwnerID,
+		Path:      grant.Path,
+		ReurlID:   grant.ReurlID,
+		CreatedAt: grant.CreatedAt,
+	}
+}
@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	linkIdentityPurpose    = "link_identity"
+	linkIdentityExpiration = 10 * time.Minute
+)
+
+var errIdentityInUse = errors.New("identity is already linked to another account")
+
+type identityResponse struct {
+	ID        uint                `json:"id"`
+	Provider  models.AuthProvider `json:"provider"`
+	Email     string              `json:"email"`
+	Primary   bool                `json:"primary"` // the identity the account was created with, can not be unlinked
+	CreatedAt time.Time           `json:"created_at"`
+}
+
+// ListIdentities lists the login providers linked to the logged-in user
+func ListIdentities(c *gin.Context, db *gorm.DB) {
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	var identities []models.UserIdentity
+	if err := db.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list identities", "details": err.Error()})
+		return
+	}
+
+	result := make([]identityResponse, 0, len(identities))
+	for _, identity := range identities {
+		result = append(result, identityResponse{
+			ID:        identity.ID,
+			Provider:  identity.Provider,
+			Email:     identity.Email,
+			Primary:   isPrimaryIdentity(user, identity),
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+
+	c.JSON(200, gin.H{"primary_provider": user.Provider, "data": result})
+}
+
+// LinkIdentityStart starts the OAuth flow of :provider for linking it to the logged-in user
+func LinkIdentityStart(c *gin.Context, db *gorm.DB) {
+	userID, err := utils.GetUserIDStrict(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var start func(*gin.Context)
+	switch provider := c.Param("provider"); provider {
+	case string(models.AuthProviderGitHub):
+		start = GitHubLoginStart
+	case string(models.AuthProviderGoogle):
+		start = GoogleLoginStart
+	case string(models.AuthProviderLine):
+		start = LineLoginStart
+	default:
+		if !slices.Contains(OIDCProviderNames(), provider) {
+			c.JSON(404, gin.H{"error": "Unknown provider"})
+			return
+		}
+		start = func(c *gin.Context) { OIDCLoginStart(c, provider) }
+	}
+
+	// The link token travels in the OAuth state and tells the callback which user to link to
+	linkToken, err := generatePurposeToken(linkIdentityPurpose, userID, linkIdentityExpiration)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+	c.Set(oauthLinkTokenKey, linkToken)
+	start(c)
+}
+
+// UnlinkIdentity removes a linked login provider from the logged-in user
+func UnlinkIdentity(c *gin.Context, db *gorm.DB) {
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid identity id"})
+		return
+	}
+
+	var identity models.UserIdentity
+	if err := db.Where("id = ? AND user_id = ?", id, user.ID).First(&identity).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "Identity not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to unlink identity", "details": err.Error()})
+		return
+	}
+
+	// The account must keep the login it was created with
+	if isPrimaryIdentity(user, identity) {
+		c.JSON(409, gin.H{"error": "The primary login of an account can not be unlinked"})
+		return
+	}
+
+	// Hard delete so the same identity can be linked again later (unique provider + subject)
+	if err := db.Unscoped().Delete(&identity).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unlink identity", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Identity unlinked"})
+}
+
+// completeIdentityLink finishes a LinkIdentityStart flow in the provider's callback
+func completeIdentityLink(c *gin.Context, db *gorm.DB, linkToken, redirectBack string, provider models.AuthProvider, providerID, email string) {
+	userID, _, err := validatePurposeToken(linkToken, linkIdentityPurpose)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired link request", "details": err.Error()})
+		return
+	}
+
+	if err := linkIdentity(db, userID, provider, providerID, email); err != nil {
+		if errors.Is(err, errIdentityInUse) {
+			c.JSON(409, gin.H{"error": "Identity already linked to another account"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to link identity", "details": err.Error()})
+		return
+	}
+
+	if redirectBack != "" {
+		u, _ := url.Parse(redirectBack)
+		q := u.Query()
+		q.Set("link", "success")
+		q.Set("provider", string(provider))
+		u.RawQuery = q.Encode()
+		c.Redirect(302, u.String())
+		return
+	}
+	c.JSON(200, gin.H{"message": "Identity linked", "provider": provider})
+}
+
+// linkIdentity attaches provider + providerID to userID; linking an identity the user already has is a no-op
+func linkIdentity(db *gorm.DB, userID uint, provider models.AuthProvider, providerID, email string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		var existing models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, providerID).First(&existing).Error
+		if err == nil {
+			if existing.UserID != userID {
+				return errIdentityInUse
+			}
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// Users created before identities existed have no identity row yet
+		var owner models.User
+		err = tx.Where("provider = ? AND identifier = ?", provider, providerID).First(&owner).Error
+		if err == nil && owner.ID != userID {
+			return errIdentityInUse
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{UserID: userID, Provider: provider, Subject: providerID, Email: email}).Error
+	})
+}
+
+func isPrimaryIdentity(user models.User, identity models.UserIdentity) bool {
+	return identity.Provider == user.Provider && identity.Subject == user.Identifier
+}
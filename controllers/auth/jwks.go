@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys that verify access tokens, so other services can check them.
+// It is empty while access tokens are signed with HS256.
+func JWKS(c *gin.Context) {
+	keys, err := getKeySet()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to load keys", "details": err.Error()})
+		return
+	}
+
+	result := make([]jsonWebKey, 0, len(keys.verify))
+	for kid, key := range keys.verify {
+		jwk, err := newJSONWebKey(key.public, kid, key.method.Alg())
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to encode keys", "details": err.Error()})
+			return
+		}
+		result = append(result, jwk)
+	}
+	// Signing key first, then a stable order
+	sort.Slice(result, func(i, j int) bool {
+		if keys.signing != nil && (result[i].Kid == keys.signing.kid) != (result[j].Kid == keys.signing.kid) {
+			return result[i].Kid == keys.signing.kid
+		}
+		return result[i].Kid < result[j].Kid
+	})
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(200, gin.H{"keys": result})
+}
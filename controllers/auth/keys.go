@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"personal_site/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// asymmetricKey is an RS256 or EdDSA key for access tokens. kid is the RFC 7638 thumbprint of the
+// public key, so a key keeps its kid when it moves from signing to verification-only during rotation.
+type asymmetricKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for verification-only keys
+	public  crypto.PublicKey
+}
+
+// keySet holds the configured access token keys. Without JWT_SIGNING_KEY_FILE access tokens
+// are signed with HS256 and JWT_SECRET_KEY, as before asymmetric keys were supported.
+type keySet struct {
+	signing *asymmetricKey
+	verify  map[string]*asymmetricKey // by kid, includes the signing key
+	// hs256Until keeps HS256 tokens valid next to a signing key until this time, so switching to a key file
+	// does not log everyone out. Zero: HS256 tokens are rejected as soon as a signing key is set.
+	hs256Until time.Time
+}
+
+// acceptsHS256 reports whether access tokens signed with JWT_SECRET_KEY are valid at now. Once a signing key
+// is set, whoever knows the shared secret must not be able to mint access tokens.
+func (s *keySet) acceptsHS256(now time.Time) bool {
+	return s.signing == nil || now.Before(s.hs256Until)
+}
+
+var (
+	keySetMu     sync.Mutex
+	cachedKeySet *keySet
+)
+
+// getKeySet loads the key files once. Config:
+// JWT_SIGNING_KEY_FILE - PEM private key (RSA or Ed25519) that signs new access tokens
+// JWT_VERIFICATION_KEY_FILES - comma separated PEM keys (public or private) of previous signing keys,
+// still accepted until the tokens they signed have expired
+// JWT_HS256_ACCEPTED_UNTIL - RFC 3339 time until which HS256 tokens stay valid after JWT_SIGNING_KEY_FILE is set
+var getKeySet = func() (*keySet, error) {
+	keySetMu.Lock()
+	defer keySetMu.Unlock()
+	if cachedKeySet != nil {
+		return cachedKeySet, nil
+	}
+
+	set := &keySet{verify: map[string]*asymmetricKey{}}
+	if path, err := config.GetVariableAsString("JWT_SIGNING_KEY_FILE"); err == nil && path != "" {
+		key, err := loadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if key.private == nil {
+			return nil, fmt.Errorf("JWT_SIGNING_KEY_FILE %s does not contain a private key", path)
+		}
+		set.signing = key
+		set.verify[key.kid] = key
+	}
+	if paths, err := config.GetVariableAsString("JWT_VERIFICATION_KEY_FILES"); err == nil {
+		for _, path := range strings.Split(paths, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+			key, err := loadKeyFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := set.verify[key.kid]; !exists {
+				set.verify[key.kid] = &asymmetricKey{kid: key.kid, method: key.method, public: key.public}
+			}
+		}
+	}
+
+	if raw, err := config.GetVariableAsString("JWT_HS256_ACCEPTED_UNTIL"); err == nil && raw != "" {
+		until, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_HS256_ACCEPTED_UNTIL must be an RFC 3339 time: %v", err)
+		}
+		set.hs256Until = until
+	}
+
+	cachedKeySet = set
+	return set, nil
+}
+
+// loadKeyFile reads an RSA or Ed25519 key, private or public, from a PEM file
+func loadKeyFile(path string) (*asymmetricKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file %s: %v", path, err)
+	}
+
+	var key *asymmetricKey
+	if private, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		key = &asymmetricKey{method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey}
+	} else if private, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		signer, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key in %s", path)
+		}
+		key = &asymmetricKey{method: jwt.SigningMethodEdDSA, private: signer, public: signer.Public()}
+	} else if public, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		key = &asymmetricKey{method: jwt.SigningMethodRS256, public: public}
+	} else if public, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		key = &asymmetricKey{method: jwt.SigningMethodEdDSA, public: public}
+	} else {
+		return nil, fmt.Errorf("key file %s is not an RSA or Ed25519 PEM key", path)
+	}
+
+	jwk, err := newJSONWebKey(key.public, "", key.method.Alg())
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %v", path, err)
+	}
+	key.kid = jwk.thumbprint()
+	return key, nil
+}
+
+// newJSONWebKey describes an RSA or Ed25519 public key as a JWK
+func newJSONWebKey(public crypto.PublicKey, kid, alg string) (jsonWebKey, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return jsonWebKey{
+			Kty: "OKP",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	default:
+		return jsonWebKey{}, fmt.Errorf("unsupported public key type %T", public)
+	}
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of the key, base64url encoded
+func (k jsonWebKey) thumbprint() string {
+	// Required members only, in lexicographic order
+	var members any
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	}
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"personal_site/schemas"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeyFile writes key as a PEM file and returns its path
+func writeKeyFile(t *testing.T, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func newRSAKeyFile(t *testing.T) (string, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return writeKeyFile(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)), key
+}
+
+func newEd25519KeyFile(t *testing.T) (string, ed25519.PrivateKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return writeKeyFile(t, "PRIVATE KEY", der), key
+}
+
+// useKeySet replaces the configured keys for one test
+func useKeySet(t *testing.T, signing *asymmetricKey, verifyOnly ...*asymmetricKey) *keySet {
+	set := &keySet{signing: signing, verify: map[string]*asymmetricKey{}}
+	if signing != nil {
+		set.verify[signing.kid] = signing
+	}
+	for _, key := range verifyOnly {
+		set.verify[key.kid] = &asymmetricKey{kid: key.kid, method: key.method, public: key.public}
+	}
+
+	original := getKeySet
+	getKeySet = func() (*keySet, error) { return set, nil }
+	t.Cleanup(func() { getKeySet = original })
+	return set
+}
+
+func TestAsymmetricTokens(t *testing.T) {
+	getSecretKey = func() ([]byte, error) {
+		return []byte("fake_secret_value"), nil
+	}
+	payload := schemas.TokenPayload{UserID: 123, Role: "user", Nickname: "testuser"}
+
+	t.Run("RS256 with kid", func(t *testing.T) {
+		path, _ := newRSAKeyFile(t)
+		key, err := loadKeyFile(path)
+		require.NoError(t, err)
+		useKeySet(t, key)
+
+		tokenStr, err := GenerateToken(payload, 123)
+		require.NoError(t, err)
+
+		token, err := ValidateToken(tokenStr)
+		require.NoError(t, err)
+		assert.Equal(t, "RS256", token.Method.Alg())
+		assert.Equal(t, key.kid, token.Header["kid"])
+		assert.Equal(t, payload, token.Claims.(*schemas.TokenClaims).Payload)
+	})
+
+	t.Run("EdDSA", func(t *testing.T) {
+		path, _ := newEd25519KeyFile(t)
+		key, err := loadKeyFile(path)
+		require.NoError(t, err)
+		useKeySet(t, key)
+
+		tokenStr, err := GenerateToken(payload, 123)
+		require.NoError(t, err)
+
+		token, err := ValidateToken(tokenStr)
+		require.NoError(t, err)
+		assert.Equal(t, "EdDSA", token.Method.Alg())
+	})
+
+	t.Run("Rotation keeps old tokens valid", func(t *testing.T) {
+		oldPath, _ := newRSAKeyFile(t)
+		oldKey, err := loadKeyFile(oldPath)
+		require.NoError(t, err)
+		newPath, _ := newEd25519KeyFile(t)
+		newKey, err := loadKeyFile(newPath)
+		require.NoError(t, err)
+
+		useKeySet(t, oldKey)
+		oldToken, err := GenerateToken(payload, 123)
+		require.NoError(t, err)
+
+		useKeySet(t, newKey, oldKey)
+		_, err = ValidateToken(oldToken)
+		assert.NoError(t, err, "Tokens of the previous key should be accepted during rotation")
+
+		useKeySet(t, newKey)
+		_, err = ValidateToken(oldToken)
+		assert.Error(t, err, "Tokens of a removed key should be rejected")
+	})
+
+	t.Run("Public key files verify", func(t *testing.T) {
+		_, private := newRSAKeyFile(t)
+		der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+		require.NoError(t, err)
+		key, err := loadKeyFile(writeKeyFile(t, "PUBLIC KEY", der))
+		require.NoError(t, err)
+		assert.Nil(t, key.private)
+
+		privateKey, err := loadKeyFile(writeKeyFile(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(private)))
+		require.NoError(t, err)
+		assert.Equal(t, privateKey.kid, key.kid, "Private and public key files should get the same kid")
+	})
+
+	t.Run("HS256 tokens are rejected once a signing key is set", func(t *testing.T) {
+		useKeySet(t, nil)
+		tokenStr, err := GenerateToken(payload, 123)
+		require.NoError(t, err)
+		_, err = ValidateToken(tokenStr)
+		require.NoError(t, err)
+
+		path, _ := newRSAKeyFile(t)
+		key, err := loadKeyFile(path)
+		require.NoError(t, err)
+		set := useKeySet(t, key)
+
+		_, err = ValidateToken(tokenStr)
+		assert.Error(t, err, "The shared secret must not mint access tokens next to a signing key")
+
+		set.hs256Until = time.Now().Add(time.Hour)
+		_, err = ValidateToken(tokenStr)
+		assert.NoError(t, err, "HS256 tokens are accepted during the migration window")
+
+		set.hs256Until = time.Now().Add(-time.Second)
+		_, err = ValidateToken(tokenStr)
+		assert.Error(t, err, "HS256 tokens are rejected after the migration window")
+	})
+
+	t.Run("Invalid key file", func(t *testing.T) {
+		_, err := loadKeyFile(writeKeyFile(t, "CERTIFICATE", []byte("junk")))
+		assert.Error(t, err)
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	t.Run("RFC 7638 thumbprint", func(t *testing.T) {
+		jwk := jsonWebKey{
+			Kty: "RSA",
+			N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+			E:   "AQAB",
+		}
+		assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", jwk.thumbprint())
+	})
+
+	t.Run("Lists signing and verification keys", func(t *testing.T) {
+		signingPath, _ := newEd25519KeyFile(t)
+		signing, err := loadKeyFile(signingPath)
+		require.NoError(t, err)
+		oldPath, _ := newRSAKeyFile(t)
+		old, err := loadKeyFile(oldPath)
+		require.NoError(t, err)
+		useKeySet(t, signing, old)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		JWKS(c)
+		require.Equal(t, 200, w.Code)
+
+		var body struct {
+			Keys []jsonWebKey `json:"keys"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		require.Len(t, body.Keys, 2)
+		assert.Equal(t, signing.kid, body.Keys[0].Kid, "Signing key should come first")
+		assert.Equal(t, "OKP", body.Keys[0].Kty)
+		assert.Equal(t, "EdDSA", body.Keys[0].Alg)
+		assert.Equal(t, old.kid, body.Keys[1].Kid)
+		assert.Equal(t, "RS256", body.Keys[1].Alg)
+
+		// The published key verifies tokens
+		pub, err := body.Keys[1].publicKey()
+		require.NoError(t, err)
+		assert.True(t, old.public.(*rsa.PublicKey).Equal(pub))
+	})
+
+	t.Run("Empty with HS256", func(t *testing.T) {
+		useKeySet(t, nil)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		JWKS(c)
+		require.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"keys": []}`, w.Body.String())
+	})
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"personal_site/apipaths"
+	"personal_site/config"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// Default LINE Login v2.1 endpoints. Each one can be overridden in .env (e.g. to point tests at a fake server).
+const (
+	defaultLineAuthURL    = "https://access.line.me/oauth2/v2.1/authorize"
+	defaultLineTokenURL   = "https://api.line.me/oauth2/v2.1/token"
+	defaultLineProfileURL = "https://api.line.me/v2/profile"
+	defaultLineVerifyURL  = "https://api.line.me/oauth2/v2.1/verify"
+)
+
+var lineOAuthConfig *oauth2.Config
+
+func getLineOAuthConfig() (*oauth2.Config, error) {
+	if lineOAuthConfig != nil {
+		return lineOAuthConfig, nil
+	}
+	clientID, err := config.GetVariableAsString("LINE_CHANNEL_ID")
+	if err != nil {
+		return nil, err
+	}
+	clientSecret, err := config.GetVariableAsString("LINE_CHANNEL_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	// Build redirect URL from shared path constant
+	redirectURL := computeRedirectURL(apipaths.LineCallbackPath)
+	lineOAuthConfig = &oauth2.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       []string{"profile", "openid", "email"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   oauthEndpoint("LINE_AUTH_URL", defaultLineAuthURL),
+			TokenURL:  oauthEndpoint("LINE_TOKEN_URL", defaultLineTokenURL),
+			AuthStyle: oauth2.AuthStyleInParams,
+		},
+		RedirectURL: redirectURL,
+	}
+
+	return lineOAuthConfig, nil
+}
+
+// LineLoginStart begins LINE Login by redirecting to auth URL with encoded state
+func LineLoginStart(c *gin.Context) {
+	conf, err := getLineOAuthConfig()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "LINE OAuth not configured", "details": err.Error()})
+		return
+	}
+	state, pkce, ok := beginOAuth(c)
+	if !ok {
+		return
+	}
+	authURL := conf.AuthCodeURL(state, pkce...)
+	c.Redirect(302, authURL)
+}
+
+// LineLoginCallback handles LINE redirect
+func LineLoginCallback(c *gin.Context, db *gorm.DB) {
+	conf, err := getLineOAuthConfig()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "LINE OAuth not configured", "details": err.Error()})
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(400, gin.H{"error": "Missing code"})
+		return
+	}
+	verifier, redirectBack, ok := verifyOAuthCallback(c)
+	if !ok {
+		return
+	}
+
+	token, err := conf.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Code exchange failed", "details": err.Error()})
+		return
+	}
+
+	lu, err := fetchLineUser(token.AccessToken)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to fetch LINE user", "details": err.Error()})
+		return
+	}
+
+	// LINE only returns the email inside the ID token, and only if the channel has email permission
+	email := ""
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		if claims, err := verifyLineIDToken(idToken, conf.ClientID); err == nil && claims.Sub == lu.UserID {
+			email = claims.Email
+		}
+	}
+	if email == "" {
+		email = fmt.Sprintf("line_%s@users.noreply.line.local", lu.UserID)
+	}
+
+	// LINE does not say whether it verified the email
+	completeOAuthLogin(c, db, redirectBack, models.AuthProviderLine, lu.UserID, email, false, "LINE login successful", lu.DisplayName)
+}
+
+type lineUser struct {
+	UserID      string `json:"userId"`
+	DisplayName string `json:"displayName"`
+}
+
+type lineIDTokenClaims struct {
+	Sub   string `json:"sub"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func fetchLineUser(accessToken string) (*lineUser, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, _ := http.NewRequest("GET", oauthEndpoint("LINE_PROFILE_URL", defaultLineProfileURL), nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	var u lineUser
+	if err := json.NewDecoder(resp.Body).Decode(&u); err != nil {
+		return nil, err
+	}
+	if u.UserID == "" {
+		return nil, fmt.Errorf("missing userId in profile")
+	}
+	return &u, nil
+}
+
+// verifyLineIDToken asks LINE to verify the ID token (signature, audience, expiry) and returns its claims
+func verifyLineIDToken(idToken, clientID string) (*lineIDTokenClaims, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	form := url.Values{}
+	form.Set("id_token", idToken)
+	form.Set("client_id", clientID)
+	req, _ := http.NewRequest("POST", oauthEndpoint("LINE_VERIFY_URL", defaultLineVerifyURL), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	var claims lineIDTokenClaims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"personal_site/config"
+	"personal_site/models"
+	"personal_site/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	defaultLoginIPLimit    = ratelimit.Limit{Burst: 20, Per: time.Minute}
+	defaultLoginEmailLimit = ratelimit.Limit{Burst: 10, Per: time.Minute}
+)
+
+const (
+	defaultLockoutThreshold = 5
+	defaultLockoutDuration  = 15 * time.Minute
+)
+
+// rateCheck is one token bucket a request has to pass
+type rateCheck struct {
+	key   string
+	limit ratelimit.Limit
+}
+
+func loginIPCheck(c *gin.Context) rateCheck {
+	return rateCheck{"login:ip:" + c.ClientIP(), configuredLimit("LOGIN_RATE_LIMIT_PER_IP", defaultLoginIPLimit)}
+}
+
+func loginEmailCheck(email string) rateCheck {
+	return rateCheck{loginEmailKey(email), configuredLimit("LOGIN_RATE_LIMIT_PER_EMAIL", defaultLoginEmailLimit)}
+}
+
+func loginEmailKey(email string) string {
+	return "login:email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// configuredLimit reads a "<burst>/<duration>" limit from config, using fallback when unset or invalid
+func configuredLimit(varName string, fallback ratelimit.Limit) ratelimit.Limit {
+	raw, err := config.GetVariableAsString(varName)
+	if err != nil {
+		return fallback
+	}
+	limit, err := ratelimit.ParseLimit(raw)
+	if err != nil {
+		log.Println("[RateLimit]", varName, err)
+		return fallback
+	}
+	return limit
+}
+
+// allowRequest takes a token for every check. When one bucket is empty it writes 429 with Retry-After.
+func allowRequest(c *gin.Context, checks ...rateCheck) bool {
+	now := time.Now()
+	for _, check := range checks {
+		ok, wait, err := ratelimit.Default().Take(check.key, check.limit, now)
+		if err != nil {
+			// A broken limiter store should not lock everyone out
+			log.Println("[RateLimit] store error:", err)
+			continue
+		}
+		if !ok {
+			tooManyRequests(c, "Too many attempts, please try again later", wait)
+			return false
+		}
+	}
+	return true
+}
+
+func tooManyRequests(c *gin.Context, message string, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(429, gin.H{"error": message, "retry_after": retryAfter})
+}
+
+func lockoutThreshold() int {
+	raw, err := config.GetVariableAsString("LOGIN_LOCKOUT_THRESHOLD")
+	if err != nil {
+		return defaultLockoutThreshold
+	}
+	threshold, err := strconv.Atoi(raw)
+	if err != nil || threshold <= 0 {
+		return defaultLockoutThreshold
+	}
+	return threshold
+}
+
+func lockoutDuration() time.Duration {
+	duration, err := config.GetVariableAsTimeDuration("LOGIN_LOCKOUT_DURATION")
+	if err != nil || duration <= 0 {
+		return defaultLockoutDuration
+	}
+	return duration
+}
+
+// checkAccountLock writes 429 with Retry-After and returns false while user is locked out
+func checkAccountLock(c *gin.Context, user models.User) bool {
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		tooManyRequests(c, "Account temporarily locked after too many failed attempts", time.Until(*user.LockedUntil))
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed password or second-factor attempt and locks the account once the threshold is reached
+func recordLoginFailure(db *gorm.DB, user models.User) error {
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).
+		UpdateColumn("failed_login_count", gorm.Expr("failed_login_count + 1")).Error; err != nil {
+		return err
+	}
+
+	var count int
+	if err := db.Model(&models.User{}).Where("id = ?", user.ID).Select("failed_login_count").Scan(&count).Error; err != nil {
+		return err
+	}
+	if count < lockoutThreshold() {
+		return nil
+	}
+
+	// Start counting again after the lock expires
+	return db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumns(map[string]any{
+		"failed_login_count": 0,
+		"locked_until":       time.Now().Add(lockoutDuration()),
+	}).Error
+}
+
+// resetLoginFailures clears the failure count after a successful login
+func resetLoginFailures(db *gorm.DB, user models.User) error {
+	if user.FailedLoginCount == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return unlockUser(db, user.ID)
+}
+
+func unlockUser(db *gorm.DB, userID uint) error {
+	return db.Model(&models.User{}).Where("id = ?", userID).UpdateColumns(map[string]any{
+		"failed_login_count": 0,
+		"locked_until":       nil,
+	}).Error
+}
+
+// UnlockUser lets an admin lift the lockout of user :id and clear the login rate limit of their email
+func UnlockUser(c *gin.Context, db *gorm.DB) {
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid user id"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to find user", "details": err.Error()})
+		return
+	}
+
+	if err := unlockUser(db, user.ID); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to unlock user", "details": err.Error()})
+		return
+	}
+	if err := ratelimit.Default().Reset(loginEmailKey(user.Email)); err != nil {
+		log.Println("[RateLimit] reset error:", err)
+	}
+
+	c.JSON(200, gin.H{"message": "User unlocked"})
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	storageController "personal_site/controllers/storage"
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// reauthWindow is how recent the login of an account without password must be to count as re-authentication
+const reauthWindow = 10 * time.Minute
+
+// reauthRequest proves the caller is the account owner before sensitive changes.
+// Password accounts send their password (and a TOTP or recovery code when two-factor is on),
+// accounts without password must have logged in within reauthWindow.
+type reauthRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type updateMeRequest struct {
+	reauthRequest
+	Nickname *string `json:"nickname"`
+	Email    *string `json:"email" binding:"omitempty,email"`
+}
+
+// GetMe returns the logged-in user
+func GetMe(c *gin.Context, db *gorm.DB) {
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	c.JSON(200, gin.H{"data": user})
+}
+
+// UpdateMe changes the nickname and/or email of the logged-in user.
+// Changing the email requires re-authentication and has to be verified again.
+func UpdateMe(c *gin.Context, db *gorm.DB) {
+	var req updateMeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	if req.Nickname == nil && req.Email == nil {
+		c.JSON(400, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	updates := map[string]any{}
+	if req.Nickname != nil {
+		nickname := strings.TrimSpace(*req.Nickname)
+		if nickname == "" || len(nickname) > 64 {
+			c.JSON(400, gin.H{"error": "Nickname must be 1 to 64 characters"})
+			return
+		}
+		updates["nickname"] = nickname
+		updates["token_version"] = gorm.Expr("token_version + 1") // tokens carry the nickname
+		user.Nickname = nickname
+	}
+
+	emailChanged := req.Email != nil && *req.Email != user.Email
+	if emailChanged {
+		if !reauthenticate(c, db, user, req.reauthRequest) {
+			return
+		}
+
+		var count int64
+		if err := db.Model(&models.User{}).Unscoped().
+			Where("provider = ? AND email = ? AND id <> ?", user.Provider, *req.Email, user.ID).
+			Count(&count).Error; err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update user", "details": err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(409, gin.H{"error": "Email already in use"})
+			return
+		}
+		updates["email"] = *req.Email
+		updates["email_verified"] = false
+		user.Email, user.EmailVerified = *req.Email, false
+	}
+
+	if len(updates) > 0 {
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumns(updates).Error; err != nil {
+			c.JSON(500, gin.H{"error": "Failed to update user", "details": err.Error()})
+			return
+		}
+	}
+
+	// The new address has to be confirmed; the change itself succeeds even if the email can not be sent
+	if emailChanged {
+		if err := sendVerificationEmail(db, user); err != nil {
+			log.Println("[UpdateMe] send verification email error:", err)
+		}
+	}
+
+	c.JSON(200, gin.H{"message": "Profile updated", "data": user})
+}
+
+// DeleteMe permanently deletes the logged-in user after re-authentication,
+// together with their reurls, linked identities, sessions, API tokens and storage tree.
+// The audit log is kept; its entries lose the user ID and carry the account's email instead
+func DeleteMe(c *gin.Context, db *gorm.DB) {
+	var req reauthRequest
+	// The body is optional for accounts without password
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+			return
+		}
+	}
+
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+	if !reauthenticate(c, db, user, req) {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []any{&models.Session{}, &models.RecoveryCode{}, &models.OneTimeToken{}, &models.UserIdentity{}, &models.APIToken{}, &models.WebAuthnCredential{}, &models.MergeJob{}, &models.StorageUsage{}} {
+			if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Unscoped().Where("owner_id = ?", user.ID).Delete(&models.Reurl{}).Error; err != nil {
+			return err
+		}
+		// Grants to the user, and grants sharing the user's folders and reurls with others
+		if err := tx.Unscoped().Where("user_id = ? OR owner_id = ?", user.ID, user.ID).Delete(&models.GuestGrant{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.YTDataAPITokenHistory{}).Where("user_id = ?", user.ID).UpdateColumn("user_id", nil).Error; err != nil {
+			return err
+		}
+		// A new account may get the same ID, so the entries are told apart by the email
+		if err := tx.Unscoped().Model(&models.AuthEvent{}).Where("user_id = ?", user.ID).UpdateColumns(map[string]any{
+			"user_id": 0,
+			"email":   gorm.Expr("CASE WHEN email = '' THEN ? ELSE email END", user.Email),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&user).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete account", "details": err.Error()})
+		return
+	}
+
+	// The account is gone either way; leftover files are only logged
+	if err := storageController.RemoveUserData(user.ID); err != nil {
+		log.Println("[DeleteMe] remove storage error:", err)
+	}
+
+	removeAuthCookie(c)
+	removeRefreshCookie(c)
+
+	c.JSON(200, gin.H{"message": "Account deleted"})
+}
+
+// reauthenticate checks that the caller just proved to own user, writing an error response when not
+func reauthenticate(c *gin.Context, db *gorm.DB, user models.User, req reauthRequest) bool {
+	if user.Provider != models.AuthProviderPassword {
+		return checkRecentLogin(c, db)
+	}
+
+	if req.Password == "" {
+		c.JSON(403, gin.H{"error": "Password is required", "reauth_required": true})
+		return false
+	}
+
+	// Guessing the password here counts against the same limits as guessing it at login
+	if !allowRequest(c, loginIPCheck(c), loginEmailCheck(user.Email)) || !checkAccountLock(c, user) {
+		return false
+	}
+	if !checkPasswordHash(req.Password, user.Identifier) {
+		if err := recordLoginFailure(db, user); err != nil {
+			log.Println("[reauthenticate] record failure error:", err)
+		}
+		c.JSON(403, gin.H{"error": "Password is incorrect", "reauth_required": true})
+		return false
+	}
+
+	if user.TOTPEnabled {
+		if req.Code == "" {
+			c.JSON(403, gin.H{"error": "Two-factor code is required", "reauth_required": true})
+			return false
+		}
+		valid, err := verifySecondFactor(db, &user, req.Code)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to verify code"})
+			return false
+		}
+		if !valid {
+			if err := recordLoginFailure(db, user); err != nil {
+				log.Println("[reauthenticate] record failure error:", err)
+			}
+			c.JSON(403, gin.H{"error": "Invalid code", "reauth_required": true})
+			return false
+		}
+	}
+	return true
+}
+
+// checkRecentLogin accepts the request when the current session was started within reauthWindow
+func checkRecentLogin(c *gin.Context, db *gorm.DB) bool {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return false
+	}
+
+	var session models.Session
+	err = db.Where("token_id = ?", tokenUser.SessionID).First(&session).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(500, gin.H{"error": "Failed to find session", "details": err.Error()})
+		return false
+	}
+	if err != nil || time.Since(session.CreatedAt) > reauthWindow {
+		c.JSON(403, gin.H{"error": "Please login again to confirm this change", "reauth_required": true})
+		return false
+	}
+	return true
+}
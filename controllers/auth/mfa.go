@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"crypto/rand"
+	"log"
+	"strings"
+	"time"
+
+	"personal_site/config"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaTokenPurpose    = "mfa"
+	mfaTokenExpiration = 5 * time.Minute
+	recoveryCodeCount  = 10
+)
+
+type totpCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type loginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+// SetupTOTP starts TOTP enrollment: it stores a new secret (not yet enabled) and returns the provisioning URI
+func SetupTOTP(c *gin.Context, db *gorm.DB) {
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	if user.Provider != models.AuthProviderPassword {
+		c.JSON(403, gin.H{"error": "Two-factor authentication only allowed for password-based accounts"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	if err := db.Model(&user).Updates(map[string]any{"totp_secret": secret, "totp_last_used_step": 0}).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save secret"})
+		return
+	}
+
+	issuer, err := config.GetVariableAsString("TOTP_ISSUER")
+	if err != nil {
+		issuer = "夢.台灣"
+	}
+
+	c.JSON(200, gin.H{
+		"secret":           secret,
+		"provisioning_uri": totpProvisioningURI(issuer, user.Email, secret),
+	})
+}
+
+// EnableTOTP verifies the first code from the authenticator, enables TOTP and returns recovery codes (shown only once)
+func EnableTOTP(c *gin.Context, db *gorm.DB) {
+	var req totpCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabled {
+		c.JSON(409, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(400, gin.H{"error": "Two-factor authentication setup has not been started"})
+		return
+	}
+
+	step, valid := verifyTOTP(user.TOTPSecret, req.Code, time.Now(), user.TOTPLastUsedStep)
+	if !valid {
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{"totp_enabled": true, "totp_last_used_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Two-factor authentication enabled", "recovery_codes": codes})
+}
+
+// DisableTOTP turns TOTP off after checking both the password and a current code (or recovery code)
+func DisableTOTP(c *gin.Context, db *gorm.DB) {
+	var req disableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	if !user.TOTPEnabled {
+		c.JSON(400, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+	if !checkPasswordHash(req.Password, user.Identifier) {
+		c.JSON(403, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	valid, err := verifySecondFactor(db, &user, req.Code)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]any{"totp_enabled": false, "totp_secret": "", "totp_last_used_step": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// LoginMFA is the second login step: it exchanges the mfa_token returned by Login plus a code for the auth cookies
+func LoginMFA(c *gin.Context, db *gorm.DB) {
+	var req loginMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !allowRequest(c, loginIPCheck(c)) {
+		return
+	}
+
+	userID, _, err := validatePurposeToken(req.MFAToken, mfaTokenPurpose)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(401, gin.H{"error": "Invalid or expired MFA token"})
+		return
+	}
+	if !allowRequest(c, loginEmailCheck(user.Email)) || !checkAccountLock(c, user) {
+		return
+	}
+
+	valid, err := verifySecondFactor(db, &user, req.Code)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to verify code"})
+		return
+	}
+	if !valid {
+		if err := recordLoginFailure(db, user); err != nil {
+			log.Println("[LoginMFA] record failure error:", err)
+		}
+		recordFailedLogin(c, db, user.ID, "", "invalid_code")
+		c.JSON(401, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := resetLoginFailures(db, user); err != nil {
+		log.Println("[LoginMFA] reset failures error:", err)
+	}
+	if !checkAccountEnabled(c, user) {
+		return
+	}
+
+	if err := startLoginSession(c, db, user, "password+totp"); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, loginResponse{
+		UserID:   user.ID,
+		Message:  "Login successful",
+		Role:     string(user.Role),
+		Nickname: user.Nickname,
+	})
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code and records that it was used
+func verifySecondFactor(db *gorm.DB, user *models.User, code string) (bool, error) {
+	if step, ok := verifyTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastUsedStep); ok {
+		// Conditional update so two requests can not both use the same code
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_used_step < ?", user.ID, step).
+			UpdateColumn("totp_last_used_step", step)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		user.TOTPLastUsedStep = step
+		return result.RowsAffected == 1, nil
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// replaceRecoveryCodes deletes existing recovery codes of the user and stores new ones, returning them in plain text
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(totpEncoding.EncodeToString(b)) // 16 characters
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+		codes = append(codes, code)
+		records = append(records, models.RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(code))})
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
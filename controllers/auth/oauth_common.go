@@ -1,25 +1,56 @@
 package auth
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"personal_site/config"
 	"personal_site/models"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
 	"gorm.io/gorm"
 )
 
-// encodeOAuthState packs redirect and a nonce into a base64url JSON string
-func encodeOAuthState(redirect string) string {
+const (
+	oauthStateCookie     = "oauth_state"
+	oauthStateExpiration = 10 * time.Minute
+)
+
+// oauthStateClaims is the content of the oauth_state cookie that binds a callback to the browser that started the login
+type oauthStateClaims struct {
+	jwt.RegisteredClaims
+	Nonce    string `json:"nonce"`            // must equal the nonce packed into the OAuth state
+	Verifier string `json:"verifier"`         // PKCE code verifier for the code exchange
+	Invite   string `json:"invite,omitempty"` // invite code for the account the login may create
+}
+
+// oauthLinkTokenKey is the context key LinkIdentityStart uses to hand its link token to the provider's start handler
+const oauthLinkTokenKey = "oauth_link_token"
+
+// newOAuthState builds the state for a login start request from its redirect query
+// and, for account-linking requests, the link token set by LinkIdentityStart
+func newOAuthState(c *gin.Context) string {
+	return encodeOAuthState(c.Query("redirect"), c.GetString(oauthLinkTokenKey))
+}
+
+// encodeOAuthState packs redirect, an optional link token and a nonce into a base64url JSON string
+func encodeOAuthState(redirect, linkToken string) string {
 	payload := map[string]string{
 		"n": randomState(),
 		"r": redirect,
 	}
+	if linkToken != "" {
+		payload["l"] = linkToken
+	}
 	b, err := json.Marshal(payload)
 	if err != nil {
 		delete(payload, "r") // If we can't marshal, just remove nonce
@@ -31,6 +62,112 @@ func encodeOAuthState(redirect string) string {
 	return base64.RawURLEncoding.EncodeToString(b)
 }
 
+// beginOAuth builds the state for a login start request and remembers its nonce and a fresh PKCE verifier
+// in the short-lived signed oauth_state cookie, together with the optional invite query parameter. It returns the state and the PKCE options for AuthCodeURL.
+// A redirect target outside the allow-list is rejected. On failure the error response is already written.
+func beginOAuth(c *gin.Context) (string, []oauth2.AuthCodeOption, bool) {
+	if !isAllowedRedirect(c.Query("redirect"), loginRedirectOrigins()) {
+		c.JSON(400, gin.H{"error": "Redirect target not allowed"})
+		return "", nil, false
+	}
+
+	state := newOAuthState(c)
+	verifier := oauth2.GenerateVerifier()
+
+	now := time.Now()
+	claims := &oauthStateClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(oauthStateExpiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Nonce:    decodeOAuthStateNonce(state),
+		Verifier: verifier,
+		Invite:   c.Query("invite"),
+	}
+	key, err := getSecretKey()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start OAuth login", "details": fmt.Sprintf("failed to get secret key: %v", err)})
+		return "", nil, false
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to start OAuth login", "details": err.Error()})
+		return "", nil, false
+	}
+
+	c.SetCookie(
+		oauthStateCookie,                    // cookie name
+		signed,                              // cookie value
+		int(oauthStateExpiration.Seconds()), // max age in seconds
+		"/",                                 // path
+		"",                                  // domain (empty means current domain)
+		true,                                // secure (set to true in production with HTTPS)
+		true,                                // httpOnly
+	)
+	return state, []oauth2.AuthCodeOption{oauth2.S256ChallengeOption(verifier)}, true
+}
+
+// verifyOAuthCallback checks the callback's state against the oauth_state cookie and returns the PKCE verifier
+// and the allow-listed redirect target, and puts the invite code of the login into the context under oauthInviteKey.
+// The cookie is single use. On failure the error response is already written.
+func verifyOAuthCallback(c *gin.Context) (string, string, bool) {
+	state := c.Query("state")
+	if state == "" {
+		c.JSON(400, gin.H{"error": "Missing OAuth state"})
+		return "", "", false
+	}
+
+	cookie, err := c.Cookie(oauthStateCookie)
+	if err != nil || cookie == "" {
+		c.JSON(400, gin.H{"error": "OAuth state cookie missing or expired, please start the login again"})
+		return "", "", false
+	}
+	removeOAuthStateCookie(c)
+
+	key, err := getSecretKey()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get secret key", "details": err.Error()})
+		return "", "", false
+	}
+	claims := &oauthStateClaims{}
+	_, err = jwt.ParseWithClaims(cookie, claims, func(token *jwt.Token) (interface{}, error) {
+		return key, nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		c.JSON(400, gin.H{"error": "OAuth state cookie missing or expired, please start the login again", "details": err.Error()})
+		return "", "", false
+	}
+
+	nonce := decodeOAuthStateNonce(state)
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(nonce), []byte(claims.Nonce)) != 1 {
+		c.JSON(400, gin.H{"error": "OAuth state mismatch"})
+		return "", "", false
+	}
+
+	redirectBack := decodeOAuthStateRedirect(state)
+	if redirectBack == "" {
+		redirectBack = c.Query("redirect")
+	}
+	if !isAllowedRedirect(redirectBack, loginRedirectOrigins()) {
+		c.JSON(400, gin.H{"error": "Redirect target not allowed"})
+		return "", "", false
+	}
+	c.Set(oauthInviteKey, claims.Invite)
+	return claims.Verifier, redirectBack, true
+}
+
+func removeOAuthStateCookie(c *gin.Context) {
+	c.SetCookie(
+		oauthStateCookie, // cookie name
+		"",               // empty value
+		-1,               // max age -1 (delete immediately)
+		"/",              // path
+		"",               // domain (empty means current domain)
+		true,             // secure (set to true in production with HTTPS)
+		true,             // httpOnly
+	)
+}
+
 // decodeOAuthStateRedirect extracts the redirect from state
 func decodeOAuthStateRedirect(raw string) string {
 	if raw == "" {
@@ -47,6 +184,70 @@ func decodeOAuthStateRedirect(raw string) string {
 	return ""
 }
 
+// decodeOAuthStateNonce extracts the nonce from state
+func decodeOAuthStateNonce(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(raw); err == nil {
+		var m map[string]string
+		if json.Unmarshal(b, &m) == nil {
+			return m["n"]
+		}
+	}
+	return ""
+}
+
+// decodeOAuthStateLink extracts the account-linking token from state, empty for normal logins
+func decodeOAuthStateLink(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(raw); err == nil {
+		var m map[string]string
+		if json.Unmarshal(b, &m) == nil {
+			return m["l"]
+		}
+	}
+	return ""
+}
+
+// completeOAuthLogin finishes an OAuth callback once the provider identity is known:
+// it links the identity when the state carries a link token, otherwise logs the user in.
+// emailVerified tells whether the provider vouches that the user owns email.
+func completeOAuthLogin(c *gin.Context, db *gorm.DB, redirectBack string, provider models.AuthProvider, providerID, email string, emailVerified bool, message string, nicknameCandidates ...string) {
+	if linkToken := decodeOAuthStateLink(c.Query("state")); linkToken != "" {
+		completeIdentityLink(c, db, linkToken, redirectBack, provider, providerID, email)
+		return
+	}
+
+	user, err := ensureUserFromOAuth(c, db, provider, providerID, email, emailVerified, nicknameCandidates...)
+	if errors.Is(err, errAccountDeleted) {
+		c.JSON(403, gin.H{"error": "Account deleted"})
+		return
+	}
+	var refused *registrationError
+	if errors.As(err, &refused) {
+		writeRegistrationError(c, err)
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error", "details": err.Error()})
+		return
+	}
+	if !checkAccountEnabled(c, user) {
+		return
+	}
+
+	// Start a session and set auth cookies
+	if err := startLoginSession(c, db, user, string(provider)); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+
+	finalizeLoginResponse(c, redirectBack, user, message)
+}
+
 // finalizeLoginResponse redirects back with user info in query, or returns JSON when no redirect
 func finalizeLoginResponse(c *gin.Context, redirectBack string, user models.User, message string) {
 	if redirectBack != "" {
@@ -64,28 +265,70 @@ func finalizeLoginResponse(c *gin.Context, redirectBack string, user models.User
 	c.JSON(200, gin.H{"message": message, "user_id": user.ID, "role": user.Role, "nickname": user.Nickname})
 }
 
-// ensureUserFromOAuth finds or creates a user from provider + providerID
-func ensureUserFromOAuth(db *gorm.DB, provider models.AuthProvider, providerID, email string, nicknameCandidates ...string) (models.User, error) {
+var errAccountDeleted = errors.New("account has been deleted")
+
+// ensureUserFromOAuth resolves provider + providerID to a user through its linked identity,
+// creating the user and identity on first login if the registration mode allows it
+func ensureUserFromOAuth(c *gin.Context, db *gorm.DB, provider models.AuthProvider, providerID, email string, emailVerified bool, nicknameCandidates ...string) (models.User, error) {
 	var user models.User
-	if err := db.Where("provider = ? AND identifier = ?", provider, providerID).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			user = models.User{
-				Nickname:   fallbackNickname(nicknameCandidates...),
-				Role:       models.RoleUser,
-				Provider:   provider,
-				Email:      email,
-				Identifier: providerID,
+	var identity models.UserIdentity
+	err := db.Where("provider = ? AND subject = ?", provider, providerID).First(&identity).Error
+	if err == nil {
+		if err := db.First(&user, identity.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				// The identity stays linked to a deleted user so it can not silently create a new account
+				return models.User{}, errAccountDeleted
 			}
-			if err := db.Create(&user).Error; err != nil {
-				return models.User{}, err
-			}
-		} else {
 			return models.User{}, err
 		}
+		return user, nil
+	}
+	if err != gorm.ErrRecordNotFound {
+		return models.User{}, err
+	}
+
+	created := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Users created before identities existed only have provider + identifier on the user row
+		err := tx.Where("provider = ? AND identifier = ?", provider, providerID).First(&user).Error
+		if err == gorm.ErrRecordNotFound {
+			created = true
+			var invite *models.Invite
+			invite, err = allowOAuthRegistration(tx, email, emailVerified, c.GetString(oauthInviteKey))
+			if err == nil {
+				user = models.User{
+					Nickname:      fallbackNickname(nicknameCandidates...),
+					Role:          models.RoleUser,
+					Provider:      provider,
+					Email:         email,
+					EmailVerified: emailVerified,
+					Identifier:    providerID,
+				}
+				err = createUser(tx, &user, invite)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.UserIdentity{UserID: user.ID, Provider: provider, Subject: providerID, Email: email}).Error
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+	if created {
+		RecordAuthEvent(c, db, models.AuthEvent{UserID: user.ID, Type: models.AuthEventAccountCreated, Success: true, Details: string(provider)})
 	}
 	return user, nil
 }
 
+// oauthEndpoint returns the provider URL set in varName, or fallback. The variables exist to point tests at a fake server.
+func oauthEndpoint(varName, fallback string) string {
+	if v, err := config.GetVariableAsString(varName); err == nil {
+		return v
+	}
+	return fallback
+}
+
 // computeRedirectURL builds an absolute callback URL based on the current request's scheme/host and a router path
 func computeRedirectURL(callbackPath string) string {
 	// Read base URL from environment (.env), e.g. PUBLIC_BASE_URL=https://example.com
@@ -0,0 +1,455 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"personal_site/apipaths"
+	"personal_site/config"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// Generic OpenID Connect providers are configured entirely from .env:
+//
+//	OIDC_PROVIDERS=keycloak,authentik
+//	OIDC_KEYCLOAK_ISSUER=https://sso.example.com/realms/main
+//	OIDC_KEYCLOAK_CLIENT_ID=...
+//	OIDC_KEYCLOAK_CLIENT_SECRET=...
+//	OIDC_KEYCLOAK_SCOPES=openid email profile   (optional)
+//
+// Each provider gets /auth/login-<name> and /auth/login-<name>-callback.
+
+var (
+	oidcProviderNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+	oidcReservedNames       = map[string]bool{"github": true, "google": true, "line": true}
+	oidcSigningMethods      = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+	oidcProviders   = map[string]*oidcProvider{}
+	oidcProvidersMu sync.Mutex
+)
+
+// jwksRefreshInterval limits how often JWKS is fetched again when a token has an unknown kid
+const jwksRefreshInterval = time.Minute
+
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcIDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC / OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// OIDCProviderNames returns the valid provider names listed in OIDC_PROVIDERS
+func OIDCProviderNames() []string {
+	raw, err := config.GetVariableAsString("OIDC_PROVIDERS")
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !oidcProviderNamePattern.MatchString(name) || oidcReservedNames[name] {
+			log.Println("[OIDC] ignoring invalid provider name:", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func getOIDCProvider(name string) (*oidcProvider, error) {
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+
+	if p, ok := oidcProviders[name]; ok {
+		return p, nil
+	}
+
+	prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+	issuer, err := config.GetVariableAsString(prefix + "ISSUER")
+	if err != nil {
+		return nil, err
+	}
+	clientID, err := config.GetVariableAsString(prefix + "CLIENT_ID")
+	if err != nil {
+		return nil, err
+	}
+	clientSecret, err := config.GetVariableAsString(prefix + "CLIENT_SECRET")
+	if err != nil {
+		return nil, err
+	}
+	scopes := []string{"openid", "email", "profile"}
+	if raw, err := config.GetVariableAsString(prefix + "SCOPES"); err == nil {
+		scopes = strings.Fields(strings.ReplaceAll(raw, ",", " "))
+	}
+
+	p := &oidcProvider{
+		name:         name,
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+	}
+	oidcProviders[name] = p
+	return p, nil
+}
+
+// getDiscovery fetches and caches <issuer>/.well-known/openid-configuration
+func (p *oidcProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := getJSON(p.issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("discovery failed: %v", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match configured issuer %q", d.Issuer, p.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *oidcProvider) oauthConfig() (*oauth2.Config, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     p.clientID,
+		ClientSecret: p.clientSecret,
+		Scopes:       p.scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+		RedirectURL: computeRedirectURL(apipaths.OIDCCallbackPath(p.name)),
+	}, nil
+}
+
+// lookupKey returns the JWKS key with the given kid, refetching the JWKS (at most once a minute) when it is unknown
+func (p *oidcProvider) lookupKey(kid string) (any, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	p.keysFetched = time.Now()
+	p.keys = make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		pub, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = pub
+	}
+
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (p *oidcProvider) findKey(kid string) any {
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+	// Tokens without kid are accepted only when there is exactly one key
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// verifyIDToken checks signature (via JWKS), issuer, audience and expiry of an ID token
+func (p *oidcProvider) verifyIDToken(raw string) (*oidcIDTokenClaims, error) {
+	claims := &oidcIDTokenClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.lookupKey(kid)
+	},
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30*time.Second),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("missing sub claim")
+	}
+	return claims, nil
+}
+
+// fetchUserinfo fills email and name from the userinfo endpoint when the ID token does not carry them
+func (p *oidcProvider) fetchUserinfo(accessToken string, claims *oidcIDTokenClaims) error {
+	d, err := p.getDiscovery()
+	if err != nil || d.UserinfoEndpoint == "" {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	req, _ := http.NewRequest("GET", d.UserinfoEndpoint, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	var info struct {
+		Sub               string `json:"sub"`
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+	// Userinfo must describe the same user as the ID token
+	if info.Sub != claims.Subject {
+		return fmt.Errorf("userinfo sub does not match ID token")
+	}
+	claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+	if claims.Name == "" {
+		claims.Name = info.Name
+	}
+	if claims.PreferredUsername == "" {
+		claims.PreferredUsername = info.PreferredUsername
+	}
+	return nil
+}
+
+// OIDCLoginStart redirects to the authorization endpoint of a configured OIDC provider
+func OIDCLoginStart(c *gin.Context, name string) {
+	p, err := getOIDCProvider(name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "OIDC provider not configured", "details": err.Error()})
+		return
+	}
+	conf, err := p.oauthConfig()
+	if err != nil {
+		c.JSON(502, gin.H{"error": "OIDC provider unavailable", "details": err.Error()})
+		return
+	}
+
+	state, pkce, ok := beginOAuth(c)
+	if !ok {
+		return
+	}
+	// The state nonce doubles as the OIDC nonce so the ID token is bound to this login attempt
+	authURL := conf.AuthCodeURL(state, append(pkce, oauth2.SetAuthURLParam("nonce", decodeOAuthStateNonce(state)))...)
+	c.Redirect(302, authURL)
+}
+
+// OIDCLoginCallback handles the redirect back from a configured OIDC provider
+func OIDCLoginCallback(c *gin.Context, db *gorm.DB, name string) {
+	p, err := getOIDCProvider(name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "OIDC provider not configured", "details": err.Error()})
+		return
+	}
+	conf, err := p.oauthConfig()
+	if err != nil {
+		c.JSON(502, gin.H{"error": "OIDC provider unavailable", "details": err.Error()})
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(400, gin.H{"error": "Missing code"})
+		return
+	}
+	verifier, redirectBack, ok := verifyOAuthCallback(c)
+	if !ok {
+		return
+	}
+	state := c.Query("state")
+
+	token, err := conf.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Code exchange failed", "details": err.Error()})
+		return
+	}
+
+	rawIDToken, _ := token.Extra("id_token").(string)
+	if rawIDToken == "" {
+		c.JSON(401, gin.H{"error": "Missing ID token"})
+		return
+	}
+	claims, err := p.verifyIDToken(rawIDToken)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Invalid ID token", "details": err.Error()})
+		return
+	}
+	if nonce := decodeOAuthStateNonce(state); nonce == "" || claims.Nonce != nonce {
+		c.JSON(401, gin.H{"error": "ID token nonce mismatch"})
+		return
+	}
+
+	if claims.Email == "" {
+		if err := p.fetchUserinfo(token.AccessToken, claims); err != nil {
+			log.Println("[OIDC] userinfo error:", err, "provider:", name)
+		}
+	}
+
+	email, emailVerified := claims.Email, claims.EmailVerified
+	if email == "" {
+		email, emailVerified = fmt.Sprintf("oidc_%s_%s@users.noreply.local", name, claims.Subject), false
+	}
+
+	completeOAuthLogin(c, db, redirectBack, models.OIDCAuthProvider(name), claims.Subject, email, emailVerified, name+" login successful", claims.PreferredUsername, claims.Name)
+}
+
+// publicKey converts an RSA, EC or Ed25519 JWK into a Go public key
+func (k jsonWebKey) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func getJSON(url string, out any) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,235 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"personal_site/config"
+	"personal_site/passwordhash"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPasswordMinLength = 8
+	// breachedPrefixLength is the length of the SHA-1 hex prefix the list is grouped by, as in the
+	// k-anonymity range API of Have I Been Pwned
+	breachedPrefixLength = 5
+)
+
+// bundledBreachedPasswords lists common passwords, always checked in addition to PASSWORD_BREACHED_LIST_FILE
+//
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// passwordPolicy is the set of rules new passwords must follow. Config:
+// PASSWORD_MIN_LENGTH - minimum number of characters (default 8)
+// PASSWORD_MIN_CHARACTER_CLASSES - how many of lowercase, uppercase, digits and symbols must be used (default 1)
+// PASSWORD_DISALLOW_PERSONAL_INFO - reject passwords containing the email or nickname (default true)
+type passwordPolicy struct {
+	minLength        int
+	maxBytes         int // longest password the hash algorithm uses in full
+	minClasses       int
+	disallowPersonal bool
+}
+
+func loadPasswordPolicy() passwordPolicy {
+	policy := passwordPolicy{
+		minLength:        defaultPasswordMinLength,
+		maxBytes:         passwordhash.Default().MaxPasswordBytes(),
+		minClasses:       1,
+		disallowPersonal: true,
+	}
+	if raw, err := config.GetVariableAsString("PASSWORD_MIN_LENGTH"); err == nil {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			policy.minLength = n
+		}
+	}
+	if raw, err := config.GetVariableAsString("PASSWORD_MIN_CHARACTER_CLASSES"); err == nil {
+		if n, err := strconv.Atoi(raw); err == nil && n >= 1 && n <= 4 {
+			policy.minClasses = n
+		}
+	}
+	if raw, err := config.GetVariableAsString("PASSWORD_DISALLOW_PERSONAL_INFO"); err == nil {
+		if b, err := strconv.ParseBool(raw); err == nil {
+			policy.disallowPersonal = b
+		}
+	}
+	return policy
+}
+
+// breachedPasswords holds SHA-1 hashes of known breached passwords, grouped by their hex prefix
+type breachedPasswords struct {
+	ranges map[string][]string // prefix -> sorted suffixes
+}
+
+var (
+	breachedMu     sync.Mutex
+	cachedBreached *breachedPasswords
+)
+
+// getBreachedPasswords loads the bundled list and PASSWORD_BREACHED_LIST_FILE once
+var getBreachedPasswords = func() (*breachedPasswords, error) {
+	breachedMu.Lock()
+	defer breachedMu.Unlock()
+	if cachedBreached != nil {
+		return cachedBreached, nil
+	}
+
+	list := &breachedPasswords{ranges: map[string][]string{}}
+	if err := list.load(strings.NewReader(bundledBreachedPasswords)); err != nil {
+		return nil, fmt.Errorf("bundled breached password list: %v", err)
+	}
+	if path, err := config.GetVariableAsString("PASSWORD_BREACHED_LIST_FILE"); err == nil && path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PASSWORD_BREACHED_LIST_FILE: %v", err)
+		}
+		defer f.Close()
+		if err := list.load(f); err != nil {
+			return nil, fmt.Errorf("PASSWORD_BREACHED_LIST_FILE %s: %v", path, err)
+		}
+	}
+	cachedBreached = list
+	return list, nil
+}
+
+// load reads lines of "<SHA-1 hex>[:<count>]"; empty lines and lines starting with # are skipped
+func (b *breachedPasswords) load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		prefix := hash[:breachedPrefixLength]
+		b.ranges[prefix] = append(b.ranges[prefix], hash[breachedPrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for prefix := range b.ranges {
+		slices.Sort(b.ranges[prefix])
+		b.ranges[prefix] = slices.Compact(b.ranges[prefix])
+	}
+	return nil
+}
+
+func (b *breachedPasswords) contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, found := slices.BinarySearch(b.ranges[hash[:breachedPrefixLength]], hash[breachedPrefixLength:])
+	return found
+}
+
+// weakPasswordError is a broken password rule; its message is shown to the user
+type weakPasswordError struct {
+	message string
+}
+
+func (e *weakPasswordError) Error() string {
+	return e.message
+}
+
+func weakPassword(format string, args ...any) error {
+	return &weakPasswordError{message: fmt.Sprintf(format, args...)}
+}
+
+// validatePassword checks a new password against the configured policy and the breached password list.
+// Broken rules are returned as *weakPasswordError, other errors mean the check itself failed.
+func validatePassword(password, email, nickname string) error {
+	if err := loadPasswordPolicy().check(password, email, nickname); err != nil {
+		return err
+	}
+
+	list, err := getBreachedPasswords()
+	if err != nil {
+		return err
+	}
+	if list.contains(password) {
+		return weakPassword("password appears in a list of breached passwords, please choose another one")
+	}
+	return nil
+}
+
+// check applies the policy rules; email and nickname belong to the account and must not be part of the password
+func (policy passwordPolicy) check(password, email, nickname string) error {
+	if utf8.RuneCountInString(password) < policy.minLength {
+		return weakPassword("password must be at least %d characters", policy.minLength)
+	}
+	if len(password) > policy.maxBytes {
+		return weakPassword("password must be at most %d bytes", policy.maxBytes)
+	}
+
+	if classes := characterClasses(password); classes < policy.minClasses {
+		return weakPassword("password must use at least %d of lowercase letters, uppercase letters, digits and symbols", policy.minClasses)
+	}
+
+	if policy.disallowPersonal {
+		lower := strings.ToLower(password)
+		local, _, _ := strings.Cut(strings.ToLower(email), "@")
+		for _, part := range []string{local, strings.ToLower(strings.TrimSpace(nickname))} {
+			// Very short names would reject too many passwords by accident
+			if utf8.RuneCountInString(part) >= 3 && strings.Contains(lower, part) {
+				return weakPassword("password must not contain your email or nickname")
+			}
+		}
+	}
+	return nil
+}
+
+// characterClasses counts which of lowercase, uppercase, digits and other characters password uses
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// checkPasswordPolicy writes 400 and returns false when a new password is not acceptable
+func checkPasswordPolicy(c *gin.Context, password, email, nickname string) bool {
+	err := validatePassword(password, email, nickname)
+	if err == nil {
+		return true
+	}
+	var weak *weakPasswordError
+	if errors.As(err, &weak) {
+		c.JSON(400, gin.H{"error": "Password does not meet the requirements", "details": weak.Error()})
+		return false
+	}
+	log.Println("[PasswordPolicy] check error:", err)
+	c.JSON(500, gin.H{"error": "Failed to check password", "details": err.Error()})
+	return false
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	strict := passwordPolicy{minLength: 10, maxBytes: 72, minClasses: 3, disallowPersonal: true}
+
+	for password, ok := range map[string]bool{
+		"Sh0rt!":                   false, // too short
+		"alllowercaseletters":      false, // one class
+		"lowercase-and-symbols":    false, // two classes
+		"Upper-lower-and-symbols":  true,
+		"Digits-and-1-more":        true,
+		"Mit Ümlauten und Ziffer1": true,
+		"Alice-rocks-2024":         false, // contains the nickname
+		"My-ALICE.SMITH-pass":      false, // contains the email local part, case-insensitive
+		strings.Repeat("Aa1-", 19): false, // over the limit of 72 bytes
+	} {
+		err := strict.check(password, "alice.smith@example.com", "Alice")
+		if ok {
+			assert.NoError(t, err, password)
+			continue
+		}
+		var weak *weakPasswordError
+		assert.True(t, errors.As(err, &weak), password)
+	}
+
+	lenient := passwordPolicy{minLength: 8, maxBytes: 72, minClasses: 1}
+	assert.NoError(t, lenient.check("alice-in-wonderland", "alice@example.com", "alice"), "Personal info check can be disabled")
+	assert.NoError(t, strict.check("Bob-the-builder-1", "bo@example.com", "Bo"), "Names shorter than 3 characters are ignored")
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	list := &breachedPasswords{ranges: map[string][]string{}}
+	require.NoError(t, list.load(strings.NewReader(bundledBreachedPasswords)))
+	assert.True(t, list.contains("password123"))
+	assert.True(t, list.contains("qwerty"))
+	assert.False(t, list.contains("blue-kettle-42"))
+
+	// Lower case hashes and Have I Been Pwned counts are accepted
+	require.NoError(t, list.load(strings.NewReader("# custom list\n\nabf7aad6438836dbe526aa231abde2d0eef74d42:42\n")))
+	assert.True(t, list.contains("correct horse battery staple"))
+	assert.True(t, list.contains("password123"), "Loading another list keeps the previous hashes")
+
+	assert.Error(t, list.load(strings.NewReader("not-a-hash\n")))
+	assert.Error(t, list.load(strings.NewReader("ZZ52009B64FD0A2A49E6D8A939753077792B0554\n")))
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"personal_site/config"
+	"personal_site/mailer"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	resetPasswordPurpose    = "reset_password"
+	resetPasswordExpiration = time.Hour
+	verifyEmailPurpose      = "verify_email"
+	verifyEmailExpiration   = 48 * time.Hour
+)
+
+var errInvalidOneTimeToken = errors.New("invalid, expired or already used token")
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"` // checked by the password policy
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ForgotPassword emails a password reset link. It always answers the same way so it can not be used to find accounts.
+func ForgotPassword(c *gin.Context, db *gorm.DB) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Throttled like logins, so the endpoint can not be used to flood a mailbox or probe many addresses
+	if !allowRequest(c, loginIPCheck(c), loginEmailCheck(req.Email)) {
+		return
+	}
+
+	var user models.User
+	err := db.Where("email = ? AND provider = ?", req.Email, models.AuthProviderPassword).First(&user).Error
+	if err == nil {
+		if err := sendPasswordResetEmail(db, user); err != nil {
+			log.Println("[ForgotPassword] send reset email error:", err)
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(500, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+// ResetPassword sets a new password using a token from the reset email and logs out all sessions
+func ResetPassword(c *gin.Context, db *gorm.DB) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check the policy before the token is used up, so a rejected password can be retried
+	userID, _, err := validatePurposeToken(req.Token, resetPasswordPurpose)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	var user models.User
+	if err := db.Select("id", "email", "nickname").Where("id = ? AND provider = ?", userID, models.AuthProviderPassword).First(&user).Error; err != nil {
+		c.JSON(400, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if !checkPasswordPolicy(c, req.NewPassword, user.Email, user.Nickname) {
+		return
+	}
+
+	newHashedPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to hash new password"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		userID, err := consumeOneTimeToken(tx, req.Token, resetPasswordPurpose)
+		if err != nil {
+			return err
+		}
+		// The reset link reached the mailbox, which verifies the email as well
+		if err := tx.Model(&models.User{}).Where("id = ? AND provider = ?", userID, models.AuthProviderPassword).
+			UpdateColumns(map[string]any{"identifier": newHashedPassword, "email_verified": true}).Error; err != nil {
+			return err
+		}
+		_, err = RevokeUserSessions(tx, userID, "")
+		return err
+	})
+	if errors.Is(err, errInvalidOneTimeToken) {
+		c.JSON(400, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: user.ID, Type: models.AuthEventPasswordChanged, Success: true, Details: "reset"})
+
+	c.JSON(200, gin.H{"message": "Password reset successfully"})
+}
+
+// VerifyEmail marks the email of the user as verified using a token from the verification email
+func VerifyEmail(c *gin.Context, db *gorm.DB) {
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		userID, err := consumeOneTimeToken(tx, req.Token, verifyEmailPurpose)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).UpdateColumn("email_verified", true).Error
+	})
+	if errors.Is(err, errInvalidOneTimeToken) {
+		c.JSON(400, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to verify email"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerification sends a new verification email to the logged-in user
+func ResendVerification(c *gin.Context, db *gorm.DB) {
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(400, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	if err := sendVerificationEmail(db, user); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to send verification email"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Verification email sent"})
+}
+
+func sendPasswordResetEmail(db *gorm.DB, user models.User) error {
+	token, err := issueOneTimeToken(db, resetPasswordPurpose, user.ID, resetPasswordExpiration)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+		"If it was you, open the link below within %s:\n\n%s\n\n"+
+		"If you did not ask for this, you can ignore this email.\n",
+		user.Nickname, resetPasswordExpiration, tokenLink("RESET_PASSWORD_URL", token))
+	return mailer.Default().Send(user.Email, "Reset your password", body)
+}
+
+func sendVerificationEmail(db *gorm.DB, user models.User) error {
+	token, err := issueOneTimeToken(db, verifyEmailPurpose, user.ID, verifyEmailExpiration)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below within %s:\n\n%s\n",
+		user.Nickname, verifyEmailExpiration, tokenLink("VERIFY_EMAIL_URL", token))
+	return mailer.Default().Send(user.Email, "Verify your email", body)
+}
+
+// tokenLink appends the token to the frontend page configured in urlVar, or returns the bare token when it is not set
+func tokenLink(urlVar, token string) string {
+	base, err := config.GetVariableAsString(urlVar)
+	if err != nil {
+		return "token=" + token
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "token=" + token
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// issueOneTimeToken signs a purpose token and records its jti so consumeOneTimeToken accepts it only once.
+// Older unused tokens of the same purpose for the user are invalidated.
+func issueOneTimeToken(db *gorm.DB, purpose string, userID uint, ttl time.Duration) (string, error) {
+	now := time.Now()
+	record := models.OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenID:   uuid.NewString(),
+		ExpiresAt: now.Add(ttl),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.OneTimeToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return signPurposeToken(purpose, userID, ttl, record.TokenID)
+}
+
+// consumeOneTimeToken validates a token made by issueOneTimeToken, marks it used and returns its user ID
+func consumeOneTimeToken(db *gorm.DB, token, purpose string) (uint, error) {
+	userID, claims, err := validatePurposeToken(token, purpose)
+	if err != nil {
+		return 0, errInvalidOneTimeToken
+	}
+
+	result := db.Model(&models.OneTimeToken{}).
+		Where("token_id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", claims.ID, userID, purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return 0, errInvalidOneTimeToken
+	}
+	return userID, nil
+}
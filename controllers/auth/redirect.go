@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+
+	"personal_site/config"
+)
+
+// loginRedirectOrigins returns the origins a login may redirect back to, from
+// LOGIN_REDIRECT_ALLOWED_ORIGINS or else CORS_ALLOWED_ORIGINS. Without either only relative paths are allowed.
+func loginRedirectOrigins() []string {
+	raw, err := config.GetVariableAsString("LOGIN_REDIRECT_ALLOWED_ORIGINS")
+	if err != nil {
+		raw, _ = config.GetVariableAsString("CORS_ALLOWED_ORIGINS")
+	}
+
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(raw, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		// Wildcards are fine for CORS but never for redirects
+		if origin == "" || strings.Contains(origin, "*") {
+			continue
+		}
+		origins = append(origins, strings.ToLower(origin))
+	}
+	return origins
+}
+
+// isAllowedRedirect reports whether a post-login redirect target is a relative path on this site
+// or an absolute http(s) URL whose origin is in allowedOrigins. Empty means no redirect and is allowed.
+func isAllowedRedirect(target string, allowedOrigins []string) bool {
+	if target == "" {
+		return true
+	}
+	// Browsers treat backslashes like slashes, so "/\evil.com" would leave the site
+	if strings.ContainsAny(target, "\\\r\n\t") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		// Relative path only; "//evil.com" is protocol-relative and has a host
+		return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") && u.User == nil
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return false
+	}
+	origin := strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, allowed := range allowedOrigins {
+		if origin == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsAllowedRedirect(t *testing.T) {
+	origins := []string{"https://app.example.com", "http://localhost:3000"}
+
+	cases := []struct {
+		target  string
+		allowed bool
+	}{
+		{"", true},
+		{"/dashboard", true},
+		{"/login?next=%2Fhome", true},
+		{"https://app.example.com/after-login", true},
+		{"https://APP.example.com/after-login", true},
+		{"http://localhost:3000/", true},
+		{"https://evil.com/", false},
+		{"https://app.example.com.evil.com/", false},
+		{"http://app.example.com/", false}, // scheme is part of the origin
+		{"http://localhost:3001/", false},
+		{"//evil.com/", false},
+		{"/\\evil.com", false},
+		{"javascript:alert(1)", false},
+		{"https://user@app.example.com/", false},
+		{"dashboard", false},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.allowed, isAllowedRedirect(tc.target, origins), "target %q", tc.target)
+	}
+
+	assert.False(t, isAllowedRedirect("https://app.example.com/", nil), "Absolute URLs need an allow-list")
+	assert.True(t, isAllowedRedirect("/home", nil), "Relative paths are always allowed")
+}
@@ -0,0 +1,417 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"personal_site/config"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Registration modes, set with REGISTRATION_MODE
+const (
+	registrationOpen   = "open"   // anyone can sign up (default)
+	registrationInvite = "invite" // only with an invite code
+	registrationDomain = "domain" // emails of REGISTRATION_EMAIL_DOMAINS, others need an invite code
+	registrationClosed = "closed" // nobody can sign up, existing accounts keep working
+)
+
+const (
+	defaultInviteExpiration = 7 * 24 * time.Hour
+	maxInviteExpiration     = 90 * 24 * time.Hour
+
+	// verificationResendInterval is how often refused logins of an unverified account mail a new link
+	verificationResendInterval = 10 * time.Minute
+)
+
+// oauthInviteKey is the context key verifyOAuthCallback uses to hand the invite code of the login to ensureUserFromOAuth
+const oauthInviteKey = "oauth_invite"
+
+// registrationError explains why an account can not be created; it is returned to the client as is
+type registrationError struct {
+	reason string
+}
+
+func (e *registrationError) Error() string {
+	return e.reason
+}
+
+var (
+	errRegistrationClosed = &registrationError{"registration is closed"}
+	errInviteRequired     = &registrationError{"registration is invite-only, an invite code is required"}
+	errEmailDomain        = &registrationError{"registration is limited to some email domains, use an allowed email or an invite code"}
+	errInvalidInvite      = &registrationError{"invalid, expired or already used invite code"}
+	errEmailUnverified    = &registrationError{"registration is limited to some email domains and the provider did not verify this email, use an invite code"}
+)
+
+// registrationPolicy decides who may create an account. Config:
+// REGISTRATION_MODE - open, invite, domain or closed (default open)
+// REGISTRATION_EMAIL_DOMAINS - comma separated domains allowed to sign up in domain mode, e.g. "example.com,example.org"
+type registrationPolicy struct {
+	mode    string
+	domains []string // lower case, without "@"
+}
+
+func loadRegistrationPolicy() registrationPolicy {
+	policy := registrationPolicy{mode: registrationOpen}
+	if raw, ok := config.LookupVariable("REGISTRATION_MODE"); ok {
+		switch mode := strings.ToLower(strings.TrimSpace(raw)); mode {
+		case registrationOpen, registrationInvite, registrationDomain, registrationClosed:
+			policy.mode = mode
+		default:
+			// A typo must not open registration
+			policy.mode = registrationClosed
+		}
+	}
+	if raw, ok := config.LookupVariable("REGISTRATION_EMAIL_DOMAINS"); ok {
+		for _, domain := range strings.Split(raw, ",") {
+			if domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@")); domain != "" {
+				policy.domains = append(policy.domains, domain)
+			}
+		}
+	}
+	return policy
+}
+
+// check returns a *registrationError when an account for email may not be created. invited tells
+// whether the sign-up carries a valid invite code for that email.
+func (p registrationPolicy) check(email string, invited bool) error {
+	switch p.mode {
+	case registrationOpen:
+		return nil
+	case registrationInvite:
+		if !invited {
+			return errInviteRequired
+		}
+		return nil
+	case registrationDomain:
+		if !invited && !p.allowsDomain(email) {
+			return errEmailDomain
+		}
+		return nil
+	default:
+		return errRegistrationClosed
+	}
+}
+
+// requiresVerifiedEmail tells whether an account allowed by check has to prove it owns its email before it is
+// used. In domain mode the email is what lets it in, unless an invite did.
+func (p registrationPolicy) requiresVerifiedEmail(invited bool) bool {
+	return p.mode == registrationDomain && !invited
+}
+
+func (p registrationPolicy) allowsDomain(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range p.domains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// allowRegistration checks whether an account for email may be created with the optional invite code and
+// returns the invite to redeem along with the account, nil without one. Refusals are *registrationError.
+func allowRegistration(db *gorm.DB, email, inviteCode string) (*models.Invite, error) {
+	var invite *models.Invite
+	if inviteCode = strings.TrimSpace(inviteCode); inviteCode != "" {
+		invite = &models.Invite{}
+		if err := db.Where("code_hash = ?", hashToken(inviteCode)).First(invite).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, errInvalidInvite
+			}
+			return nil, err
+		}
+		if !invite.IsUsable(time.Now()) || (invite.Email != "" && !strings.EqualFold(invite.Email, email)) {
+			return nil, errInvalidInvite
+		}
+	}
+
+	if err := loadRegistrationPolicy().check(email, invite != nil); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// allowOAuthRegistration is allowRegistration for OAuth sign-ups, which also need the provider to have verified
+// the email when the policy requires it; there is no way to send them a verification email first
+func allowOAuthRegistration(db *gorm.DB, email string, emailVerified bool, inviteCode string) (*models.Invite, error) {
+	invite, err := allowRegistration(db, email, inviteCode)
+	if err != nil {
+		return nil, err
+	}
+	if !emailVerified && loadRegistrationPolicy().requiresVerifiedEmail(invite != nil) {
+		return nil, errEmailUnverified
+	}
+	return invite, nil
+}
+
+// checkRegistration is allowRegistration for handlers, writing the error response and returning false on refusal
+func checkRegistration(c *gin.Context, db *gorm.DB, email, inviteCode string) (*models.Invite, bool) {
+	invite, err := allowRegistration(db, email, inviteCode)
+	if err != nil {
+		writeRegistrationError(c, err)
+		return nil, false
+	}
+	return invite, true
+}
+
+// checkEmailVerified writes 403 and returns false for accounts that signed up with a typed email which still has to
+// be verified (User.EmailVerificationRequired). As they can not log in to ask for a new link, one is mailed when no
+// link was sent within verificationResendInterval.
+func checkEmailVerified(c *gin.Context, db *gorm.DB, user models.User) bool {
+	if user.EmailVerified || !user.EmailVerificationRequired {
+		return true
+	}
+
+	var recent int64
+	err := db.Model(&models.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL AND created_at > ?", user.ID, verifyEmailPurpose, time.Now().Add(-verificationResendInterval)).
+		Count(&recent).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Database error", "details": err.Error()})
+		return false
+	}
+	if recent == 0 {
+		if err := sendVerificationEmail(db, user); err != nil {
+			log.Println("[Registration] send verification email error:", err)
+		}
+	}
+	c.JSON(403, gin.H{"error": "Email not verified", "details": "open the link in the verification email sent to your address, then log in again"})
+	return false
+}
+
+func writeRegistrationError(c *gin.Context, err error) {
+	var refused *registrationError
+	if errors.As(err, &refused) {
+		c.JSON(403, gin.H{"error": "Registration not allowed", "details": refused.reason})
+		return
+	}
+	c.JSON(500, gin.H{"error": "Failed to check registration", "details": err.Error()})
+}
+
+// redeemInvite marks the invite with inviteID as used by userID. The conditional update makes sure two sign-ups
+// can not both use it. Call it in the transaction that creates the user.
+func redeemInvite(tx *gorm.DB, inviteID, userID uint) error {
+	now := time.Now()
+	result := tx.Model(&models.Invite{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", inviteID, now).
+		Updates(map[string]any{"used_at": now, "used_by": userID})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidInvite
+	}
+	return nil
+}
+
+// createUser creates user, with the role and account expiry of the invite it was allowed with (if any), redeems
+// the invite and hands its grants to the user. Guests always get an expiry. Call it in a transaction so a used up
+// invite also rolls back the user.
+func createUser(tx *gorm.DB, user *models.User, invite *models.Invite) error {
+	if invite != nil {
+		user.Role = invite.Role
+		user.ExpiresAt = invite.AccountExpiresAt
+	}
+	if user.Role == models.RoleGuest && user.ExpiresAt == nil {
+		expiresAt := time.Now().Add(defaultGuestLifetime)
+		user.ExpiresAt = &expiresAt
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	if invite == nil {
+		return nil
+	}
+	if err := redeemInvite(tx, invite.ID, user.ID); err != nil {
+		return err
+	}
+	return tx.Model(&models.GuestGrant{}).Where("invite_id = ? AND user_id = 0", invite.ID).Update("user_id", user.ID).Error
+}
+
+// ================= Admin: invites =================
+
+type createInviteRequest struct {
+	Email     string       `json:"email" binding:"omitempty,email"`
+	Role      *models.Role `json:"role"`
+	ExpiresAt *time.Time   `json:"expires_at"`
+	// AccountExpiresAt limits how long the created account works, guest accounts always get a limit
+	AccountExpiresAt *time.Time `json:"account_expires_at"`
+	// Grants are handed to the created account, to share folders and reurls with a guest
+	Grants []grantRequest `json:"grants" binding:"dive"`
+}
+
+type inviteResponse struct {
+	ID        uint        `json:"id"`
+	Prefix    string      `json:"prefix"`
+	Email     string      `json:"email"`
+	Role      models.Role `json:"role"`
+	CreatedBy uint        `json:"created_by"`
+	CreatedAt time.Time   `json:"created_at"`
+	ExpiresAt time.Time   `json:"expires_at"`
+	UsedAt    *time.Time  `json:"used_at"`
+	UsedBy    uint        `json:"used_by"`
+
+	AccountExpiresAt *time.Time `json:"account_expires_at"`
+}
+
+// CreateInvite creates a single-use invite code. The code is only returned this once.
+// Without expires_at the invite expires after 7 days. Guest invites without account_expires_at create
+// accounts that work for 30 days from the sign-up.
+func CreateInvite(c *gin.Context, db *gorm.DB) {
+	var req createInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	role := models.RoleUser
+	if req.Role != nil {
+		if !req.Role.IsValid() {
+			c.JSON(400, gin.H{"error": "Invalid role"})
+			return
+		}
+		role = *req.Role
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(defaultInviteExpiration)
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) || req.ExpiresAt.Sub(now) > maxInviteExpiration {
+			c.JSON(400, gin.H{"error": "expires_at must be in the future and at most 90 days away"})
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+	if req.AccountExpiresAt != nil && !req.AccountExpiresAt.After(expiresAt) {
+		c.JSON(400, gin.H{"error": "account_expires_at must be after the invite expires"})
+		return
+	}
+
+	grants, ok := newGrants(c, db, req.Grants)
+	if !ok {
+		return
+	}
+
+	admin, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate invite", "details": err.Error()})
+		return
+	}
+
+	invite := models.Invite{
+		CodeHash:  hashToken(code),
+		Prefix:    code[:6],
+		Email:     req.Email,
+		Role:      role,
+		CreatedBy: admin.ID,
+		ExpiresAt: expiresAt,
+
+		AccountExpiresAt: req.AccountExpiresAt,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&invite).Error; err != nil {
+			return err
+		}
+		for i := range grants {
+			grants[i].InviteID = invite.ID
+		}
+		if len(grants) == 0 {
+			return nil
+		}
+		return tx.Create(&grants).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create invite", "details": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{
+		"message": "Invite created, copy the code now, it will not be shown again",
+		"code":    code,
+		"data":    newInviteResponse(invite),
+		"grants":  newGrantResponses(grants),
+	})
+}
+
+// ListInvites lists all invites, newest first. With `unused=true` only invites that can still be used.
+func ListInvites(c *gin.Context, db *gorm.DB) {
+	query := db.Order("id DESC")
+	if c.Query("unused") == "true" {
+		query = query.Where("used_at IS NULL AND expires_at > ?", time.Now())
+	}
+
+	var invites []models.Invite
+	if err := query.Find(&invites).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list invites", "details": err.Error()})
+		return
+	}
+
+	result := make([]inviteResponse, 0, len(invites))
+	for _, invite := range invites {
+		result = append(result, newInviteResponse(invite))
+	}
+
+	c.JSON(200, gin.H{"data": result})
+}
+
+// DeleteInvite withdraws an invite so its code can no longer be used, along with the grants waiting on it
+func DeleteInvite(c *gin.Context, db *gorm.DB) {
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid invite id"})
+		return
+	}
+
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.Invite{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected
+		return tx.Where("invite_id = ? AND user_id = 0", id).Delete(&models.GuestGrant{}).Error
+	})
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete invite", "details": err.Error()})
+		return
+	}
+	if deleted == 0 {
+		c.JSON(404, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Invite deleted"})
+}
+
+func newInviteResponse(invite models.Invite) inviteResponse {
+	return inviteResponse{
+		ID:        invite.ID,
+		Prefix:    invite.Prefix,
+		Email:     invite.Email,
+		Role:      invite.Role,
+		CreatedBy: invite.CreatedBy,
+		CreatedAt: invite.CreatedAt,
+		ExpiresAt: invite.ExpiresAt,
+		UsedAt:    invite.UsedAt,
+		UsedBy:    invite.UsedBy,
+
+		AccountExpiresAt: invite.AccountExpiresAt,
+	}
+}
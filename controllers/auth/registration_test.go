@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationPolicy(t *testing.T) {
+	open := registrationPolicy{mode: registrationOpen}
+	assert.NoError(t, open.check("anyone@example.net", false))
+
+	invite := registrationPolicy{mode: registrationInvite}
+	assert.Equal(t, errInviteRequired, invite.check("anyone@example.net", false))
+	assert.NoError(t, invite.check("anyone@example.net", true))
+
+	domain := registrationPolicy{mode: registrationDomain, domains: []string{"example.com", "corp.example.org"}}
+	assert.NoError(t, domain.check("alice@example.com", false))
+	assert.NoError(t, domain.check("Bob@Corp.Example.Org", false), "Domains are case-insensitive")
+	assert.Equal(t, errEmailDomain, domain.check("mallory@evil-example.com", false))
+	assert.Equal(t, errEmailDomain, domain.check("mallory@example.com.evil.net", false))
+	assert.Equal(t, errEmailDomain, domain.check("", false), "Providers without email need an invite")
+	assert.NoError(t, domain.check("guest@example.net", true), "Invites bypass the allow-list")
+	assert.True(t, domain.requiresVerifiedEmail(false), "The domain only lets in who owns the email")
+	assert.False(t, domain.requiresVerifiedEmail(true))
+	assert.False(t, open.requiresVerifiedEmail(false))
+
+	closed := registrationPolicy{mode: registrationClosed}
+	assert.Equal(t, errRegistrationClosed, closed.check("alice@example.com", true))
+}
+
+func TestLoadRegistrationPolicy(t *testing.T) {
+	t.Setenv("REGISTRATION_MODE", " Invte ")
+	t.Setenv("REGISTRATION_EMAIL_DOMAINS", "@Example.com, ,corp.example.org")
+
+	policy := loadRegistrationPolicy()
+	assert.Equal(t, registrationClosed, policy.mode, "An unknown mode closes registration")
+	assert.Equal(t, []string{"example.com", "corp.example.org"}, policy.domains)
+}
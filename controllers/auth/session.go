@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+	"personal_site/schemas"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// sessionLastSeenInterval limits how often LastSeenAt is written while a session is used
+const sessionLastSeenInterval = time.Minute
+
+type sessionResponse struct {
+	ID         uint       `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	IssuedAt   time.Time  `json:"issued_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+}
+
+// StartSession creates a server-side session for user and returns an access token bound to it
+// (jti = session TokenID) together with the plain refresh token. Only the refresh token hash is stored.
+func StartSession(db *gorm.DB, user models.User, userAgent, ip string) (string, string, error) {
+	refreshToken, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	session := models.Session{
+		UserID:           user.ID,
+		TokenID:          uuid.NewString(),
+		RefreshTokenHash: hashToken(refreshToken),
+		UserAgent:        truncate(userAgent, 512),
+		IP:               ip,
+		ExpiresAt:        time.Now().Add(schemas.RefreshTokenExpiration()),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return "", "", fmt.Errorf("failed to create session: %v", err)
+	}
+
+	accessToken, err := GenerateSessionToken(newTokenPayload(user), user.ID, session.TokenID)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// ValidateSession checks that the session an access token was issued for is still active
+// and returns the current state of its user
+func ValidateSession(db *gorm.DB, tokenID string) (models.User, error) {
+	if tokenID == "" {
+		return models.User{}, fmt.Errorf("token is not bound to a session")
+	}
+
+	var session models.Session
+	if err := db.Where("token_id = ?", tokenID).First(&session).Error; err != nil {
+		return models.User{}, fmt.Errorf("session not found")
+	}
+	now := time.Now()
+	if !session.IsActive(now) {
+		return models.User{}, fmt.Errorf("session has been revoked or expired")
+	}
+
+	// Deleted users are not found (soft delete), disabled and expired users are rejected
+	var user models.User
+	if err := db.Select("id", "role", "nickname", "disabled", "expires_at", "token_version", "storage_dir").First(&user, session.UserID).Error; err != nil {
+		return models.User{}, fmt.Errorf("user not found")
+	}
+	if err := accountError(user); err != nil {
+		return models.User{}, err
+	}
+
+	if session.LastSeenAt == nil || now.Sub(*session.LastSeenAt) > sessionLastSeenInterval {
+		db.Model(&session).UpdateColumn("last_seen_at", now)
+	}
+	return user, nil
+}
+
+// ReissueAccessToken replaces the auth cookie with a token carrying the current claims of user,
+// bound to the same session. Used when a token is still valid but its role or nickname are stale.
+func ReissueAccessToken(c *gin.Context, user models.User, sessionTokenID string) error {
+	accessToken, err := GenerateSessionToken(newTokenPayload(user), user.ID, sessionTokenID)
+	if err != nil {
+		return err
+	}
+	setAuthCookie(c, accessToken)
+	return nil
+}
+
+// Refresh exchanges the refresh_token cookie for a new access token and rotates the refresh token
+func Refresh(c *gin.Context, db *gorm.DB) {
+	refreshToken, err := c.Cookie("refresh_token")
+	if err != nil || refreshToken == "" {
+		c.JSON(401, gin.H{"error": "Refresh cookie is required"})
+		return
+	}
+
+	var session models.Session
+	if err := db.Where("refresh_token_hash = ?", hashToken(refreshToken)).First(&session).Error; err != nil || !session.IsActive(time.Now()) {
+		removeAuthCookie(c)
+		removeRefreshCookie(c)
+		c.JSON(401, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, session.UserID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if !checkAccountEnabled(c, user) {
+		removeAuthCookie(c)
+		removeRefreshCookie(c)
+		return
+	}
+
+	newRefreshToken, err := randomToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+
+	// Only rotate if nobody else rotated this token in the meantime
+	result := db.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ?", session.ID, session.RefreshTokenHash).
+		Updates(map[string]any{
+			"refresh_token_hash": hashToken(newRefreshToken),
+			"last_seen_at":       time.Now(),
+		})
+	if result.Error != nil {
+		c.JSON(500, gin.H{"error": "Failed to refresh session", "details": result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(401, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	accessToken, err := GenerateSessionToken(newTokenPayload(user), user.ID, session.TokenID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+
+	setAuthCookie(c, accessToken)
+	setRefreshCookie(c, newRefreshToken)
+
+	c.JSON(200, loginResponse{
+		UserID:   user.ID,
+		Message:  "Token refreshed",
+		Role:     string(user.Role),
+		Nickname: user.Nickname,
+	})
+}
+
+// ListSessions lists the active sessions of the logged-in user
+func ListSessions(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", tokenUser.ID, time.Now()).
+		Order("created_at DESC").Find(&sessions).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list sessions", "details": err.Error()})
+		return
+	}
+
+	result := make([]sessionResponse, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, sessionResponse{
+			ID:         s.ID,
+			UserAgent:  s.UserAgent,
+			IP:         s.IP,
+			IssuedAt:   s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			Current:    s.TokenID == tokenUser.SessionID,
+		})
+	}
+
+	c.JSON(200, gin.H{"data": result})
+}
+
+// RevokeSession revokes one session of the logged-in user by id
+func RevokeSession(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid session id"})
+		return
+	}
+
+	var session models.Session
+	if err := db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, tokenUser.ID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(404, gin.H{"error": "Session not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Failed to revoke session", "details": err.Error()})
+		return
+	}
+
+	if err := db.Model(&session).Update("revoked_at", time.Now()).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke session", "details": err.Error()})
+		return
+	}
+
+	if session.TokenID == tokenUser.SessionID {
+		removeAuthCookie(c)
+		removeRefreshCookie(c)
+	}
+
+	c.JSON(200, gin.H{"message": "Session revoked"})
+}
+
+// RevokeAllSessions logs the user out everywhere. With `except_current=true` the calling session is kept.
+func RevokeAllSessions(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	keepCurrent := c.Query("except_current") == "true"
+	exceptTokenID := ""
+	if keepCurrent {
+		exceptTokenID = tokenUser.SessionID
+	}
+
+	revoked, err := RevokeUserSessions(db, tokenUser.ID, exceptTokenID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to revoke sessions", "details": err.Error()})
+		return
+	}
+
+	if !keepCurrent {
+		removeAuthCookie(c)
+		removeRefreshCookie(c)
+	}
+
+	c.JSON(200, gin.H{"message": "Sessions revoked", "revoked": revoked})
+}
+
+// RevokeUserSessions revokes every active session of userID except the one with exceptTokenID (if not empty)
+// and returns how many were revoked
+func RevokeUserSessions(db *gorm.DB, userID uint, exceptTokenID string) (int64, error) {
+	query := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptTokenID != "" {
+		query = query.Where("token_id <> ?", exceptTokenID)
+	}
+
+	result := query.Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// startLoginSession starts a session for user, sets both auth cookies and records the login made with method
+func startLoginSession(c *gin.Context, db *gorm.DB, user models.User, method string) error {
+	accessToken, refreshToken, err := StartSession(db, user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return err
+	}
+	setAuthCookie(c, accessToken)
+	setRefreshCookie(c, refreshToken)
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: user.ID, Type: models.AuthEventLogin, Success: true, Details: method})
+	return nil
+}
+
+// revokeCurrentSession revokes the session identified by the refresh cookie, or by the access token when
+// there is no refresh cookie, and returns its user id. Requests without any session are ignored (id 0).
+func revokeCurrentSession(c *gin.Context, db *gorm.DB) (uint, error) {
+	query := db.Where("revoked_at IS NULL")
+
+	if refreshToken, err := c.Cookie("refresh_token"); err == nil && refreshToken != "" {
+		query = query.Where("refresh_token_hash = ?", hashToken(refreshToken))
+	} else if accessToken, err := c.Cookie("auth_token"); err == nil && accessToken != "" {
+		token, err := ValidateToken(accessToken)
+		if err != nil {
+			return 0, nil
+		}
+		claims, ok := token.Claims.(*schemas.TokenClaims)
+		if !ok || claims.ID == "" {
+			return 0, nil
+		}
+		query = query.Where("token_id = ?", claims.ID)
+	} else {
+		return 0, nil
+	}
+
+	var session models.Session
+	if err := query.First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return session.UserID, db.Model(&session).Update("revoked_at", time.Now()).Error
+}
+
+func newTokenPayload(user models.User) schemas.TokenPayload {
+	return schemas.TokenPayload{
+		UserID:       user.ID,
+		Role:         string(user.Role),
+		Nickname:     user.Nickname,
+		TokenVersion: user.TokenVersion,
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
+
+// randomToken returns 32 random bytes encoded as base64url
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hex SHA-256 of a token; tokens are high-entropy so no salt is needed
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func setRefreshCookie(c *gin.Context, token string) {
+	c.SetCookie(
+		"refresh_token", // cookie name
+		token,           // cookie value
+		int(schemas.RefreshTokenExpiration().Seconds()), // max age in seconds
+		"/",  // path
+		"",   // domain (empty means current domain)
+		true, // secure (set to true in production with HTTPS)
+		true, // httpOnly
+	)
+}
+
+func removeRefreshCookie(c *gin.Context) {
+	c.SetCookie(
+		"refresh_token", // cookie name
+		"",              // empty value
+		-1,              // max age -1 (delete immediately)
+		"/",             // path
+		"",              // domain (empty means current domain)
+		true,            // secure (set to true in production with HTTPS)
+		true,            // httpOnly
+	)
+}
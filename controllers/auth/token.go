@@ -2,8 +2,11 @@ package auth
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 
 	"personal_site/config"
 	"personal_site/schemas"
@@ -21,6 +24,31 @@ func GenerateToken(payload schemas.TokenPayload, id uint) (string, error) {
 	claims := schemas.NewTokenClaims(id)
 	claims.Payload = payload
 
+	return signClaims(claims)
+}
+
+// GenerateSessionToken signs an access token whose jti is the TokenID of a server-side session
+func GenerateSessionToken(payload schemas.TokenPayload, id uint, sessionTokenID string) (string, error) {
+	claims := schemas.NewTokenClaims(id)
+	claims.Payload = payload
+	claims.ID = sessionTokenID
+
+	return signClaims(claims)
+}
+
+// signClaims signs an access token with the configured signing key (RS256/EdDSA, with kid header)
+// or, when there is none, with HS256 and JWT_SECRET_KEY
+func signClaims(claims *schemas.TokenClaims) (string, error) {
+	keys, err := getKeySet()
+	if err != nil {
+		return "", fmt.Errorf("failed to load signing keys: %v", err)
+	}
+	if keys.signing != nil {
+		token := jwt.NewWithClaims(keys.signing.method, claims)
+		token.Header["kid"] = keys.signing.kid
+		return token.SignedString(keys.signing.private)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 	key, err := getSecretKey()
@@ -30,21 +58,40 @@ func GenerateToken(payload schemas.TokenPayload, id uint) (string, error) {
 	return token.SignedString(key)
 }
 
+// ValidateToken parses an access token. HS256 tokens are checked with JWT_SECRET_KEY and only accepted
+// while no signing key is set (or until JWT_HS256_ACCEPTED_UNTIL), RS256 and EdDSA tokens with the
+// signing or verification key named by their kid header.
 func ValidateToken(tokenString string) (*jwt.Token, error) {
-	key, err := getSecretKey()
+	keys, err := getKeySet()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get secret key: %v", err)
+		return nil, fmt.Errorf("failed to load verification keys: %v", err)
 	}
 
 	token, err := jwt.ParseWithClaims(tokenString, &schemas.TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			if !keys.acceptsHS256(time.Now()) {
+				return nil, fmt.Errorf("HS256 tokens are not accepted once a signing key is set")
+			}
+			key, err := getSecretKey()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get secret key: %v", err)
+			}
+			return key, nil
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.verify[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if key.method.Alg() != token.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return key, nil
-	})
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse token: %v", err)
+		return nil, fmt.Errorf("failed to parse token: %w", err)
 	}
 
 	if !token.Valid {
@@ -53,3 +100,58 @@ func ValidateToken(tokenString string) (*jwt.Token, error) {
 
 	return token, nil
 }
+
+// generatePurposeToken signs a short-lived token for userID that is only accepted by validatePurposeToken with the same purpose
+func generatePurposeToken(purpose string, userID uint, ttl time.Duration) (string, error) {
+	return signPurposeToken(purpose, userID, ttl, uuid.NewString())
+}
+
+func signPurposeToken(purpose string, userID uint, ttl time.Duration, tokenID string) (string, error) {
+	now := time.Now()
+	claims := &schemas.PurposeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        tokenID,
+		},
+		Purpose: purpose,
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+	key, err := getSecretKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret key: %v", err)
+	}
+	return token.SignedString(key)
+}
+
+// validatePurposeToken parses a token made by generatePurposeToken and returns the user ID it was issued for
+func validatePurposeToken(tokenString, purpose string) (uint, *schemas.PurposeClaims, error) {
+	key, err := getSecretKey()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get secret key: %v", err)
+	}
+
+	claims := &schemas.PurposeClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key, nil
+	})
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to parse token: %v", err)
+	}
+	if !token.Valid || claims.Purpose != purpose {
+		return 0, nil, fmt.Errorf("token is invalid")
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("token is invalid")
+	}
+	return uint(userID), claims, nil
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 Appendix B test vectors (SHA1, 8 digits)
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		assert.Equal(t, want, hotp(key, uint64(unix/30), 8), "time %d", unix)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	require.NoError(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / totpPeriod
+
+	t.Run("current code", func(t *testing.T) {
+		matched, ok := verifyTOTP(secret, hotp(key, uint64(step), totpDigits), now, 0)
+		assert.True(t, ok)
+		assert.Equal(t, step, matched)
+	})
+
+	t.Run("previous step within skew", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, hotp(key, uint64(step-1), totpDigits), now, 0)
+		assert.True(t, ok)
+	})
+
+	t.Run("code outside window", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, hotp(key, uint64(step-3), totpDigits), now, 0)
+		assert.False(t, ok)
+	})
+
+	t.Run("replayed code", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, hotp(key, uint64(step), totpDigits), now, step)
+		assert.False(t, ok)
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		_, ok := verifyTOTP(secret, "abcdef", now, 0)
+		assert.False(t, ok)
+	})
+}
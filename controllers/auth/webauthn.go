@@ -0,0 +1,650 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"personal_site/config"
+	"personal_site/controllers/utils"
+	"personal_site/models"
+	"personal_site/webauthn"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	webAuthnChallengeExpiration = 5 * time.Minute
+	maxPasskeysPerUser          = 20
+)
+
+var (
+	errPasskeysNotConfigured = errors.New("WEBAUTHN_ORIGINS is not set")
+	errInvalidChallenge      = errors.New("invalid, expired or already used challenge")
+)
+
+type passkeySignupBeginRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	Nickname   string `json:"nickname" binding:"required"`
+	InviteCode string `json:"invite_code"` // as for /auth/register
+}
+
+// publicKeyCredential is the JSON form of a PublicKeyCredential (PublicKeyCredential.toJSON()),
+// binary fields are base64url encoded
+type publicKeyCredential struct {
+	ID       string `json:"id" binding:"required"`
+	Type     string `json:"type" binding:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+		AttestationObject string `json:"attestationObject"` // registration only
+		AuthenticatorData string `json:"authenticatorData"` // assertion only
+		Signature         string `json:"signature"`         // assertion only
+		UserHandle        string `json:"userHandle"`        // assertion only, optional
+	} `json:"response"`
+}
+
+type passkeyRegisterFinishRequest struct {
+	Name       string              `json:"name"`
+	Credential publicKeyCredential `json:"credential" binding:"required"`
+}
+
+type passkeyLoginBeginRequest struct {
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
+type passkeyLoginFinishRequest struct {
+	Credential publicKeyCredential `json:"credential" binding:"required"`
+}
+
+type passkeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// PasskeyRegisterBegin returns the options for navigator.credentials.create() to add a passkey to the logged-in user
+func PasskeyRegisterBegin(c *gin.Context, db *gorm.DB) {
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := db.Where("user_id = ?", user.ID).Find(&credentials).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list passkeys", "details": err.Error()})
+		return
+	}
+	if len(credentials) >= maxPasskeysPerUser {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("At most %d passkeys per user", maxPasskeysPerUser)})
+		return
+	}
+
+	// All credentials of a user share one user handle so authenticators replace rather than duplicate them
+	userHandle := ""
+	if len(credentials) > 0 {
+		userHandle = credentials[0].UserHandle
+	} else if user.Provider == models.AuthProviderPasskey {
+		userHandle = user.Identifier
+	}
+
+	challenge, ok := beginRegistration(c, db, models.WebAuthnChallenge{UserID: user.ID, UserHandle: userHandle})
+	if !ok {
+		return
+	}
+
+	exclude := make([]credentialDescriptor, 0, len(credentials))
+	for _, cred := range credentials {
+		exclude = append(exclude, credentialDescriptor{Type: "public-key", ID: cred.CredentialID})
+	}
+	c.JSON(200, gin.H{"publicKey": creationOptions(rp, challenge, user.Email, user.Nickname, exclude, "preferred")})
+}
+
+// PasskeyRegisterFinish verifies the new credential and adds it to the logged-in user
+func PasskeyRegisterFinish(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req passkeyRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	challenge, cred, ok := finishRegistration(c, db, req)
+	if !ok {
+		return
+	}
+	if challenge.UserID != tokenUser.ID {
+		c.JSON(400, gin.H{"error": "Passkey registration failed", "details": errInvalidChallenge.Error()})
+		return
+	}
+
+	cred.UserID = tokenUser.ID
+	if err := db.Create(&cred).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save passkey", "details": err.Error()})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "Passkey added", "data": newPasskeyResponse(cred)})
+}
+
+// PasskeySignupBegin returns the options for navigator.credentials.create() to create an account without a password
+func PasskeySignupBegin(c *gin.Context, db *gorm.DB) {
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+
+	var req passkeySignupBeginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+	nickname := strings.TrimSpace(req.Nickname)
+	if nickname == "" || len(nickname) > 64 {
+		c.JSON(400, gin.H{"error": "Nickname must be 1 to 64 characters"})
+		return
+	}
+	invite, ok := checkRegistration(c, db, req.Email, req.InviteCode)
+	if !ok {
+		return
+	}
+	if !checkPasskeyEmailFree(c, db, req.Email) {
+		return
+	}
+
+	pending := models.WebAuthnChallenge{Email: req.Email, Nickname: nickname}
+	if invite != nil {
+		pending.InviteID = invite.ID
+	}
+	challenge, ok := beginRegistration(c, db, pending)
+	if !ok {
+		return
+	}
+	// A passkey-only account must be found without an email, so ask for a discoverable credential
+	c.JSON(200, gin.H{"publicKey": creationOptions(rp, challenge, req.Email, nickname, []credentialDescriptor{}, "required")})
+}
+
+// PasskeySignupFinish verifies the credential, creates the account and logs it in
+func PasskeySignupFinish(c *gin.Context, db *gorm.DB) {
+	var req passkeyRegisterFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	challenge, cred, ok := finishRegistration(c, db, req)
+	if !ok {
+		return
+	}
+	if challenge.UserID != 0 || challenge.Email == "" {
+		c.JSON(400, gin.H{"error": "Passkey registration failed", "details": errInvalidChallenge.Error()})
+		return
+	}
+	// The email may have been taken since the ceremony began
+	if !checkPasskeyEmailFree(c, db, challenge.Email) {
+		return
+	}
+
+	user := models.User{
+		Nickname:   challenge.Nickname,
+		Role:       models.RoleUser,
+		Provider:   models.AuthProviderPasskey,
+		Email:      challenge.Email,
+		Identifier: challenge.UserHandle,
+
+		EmailVerificationRequired: loadRegistrationPolicy().requiresVerifiedEmail(challenge.InviteID != 0),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var invite *models.Invite
+		if challenge.InviteID != 0 {
+			invite = &models.Invite{}
+			if err := tx.First(invite, challenge.InviteID).Error; err != nil {
+				return errInvalidInvite
+			}
+		}
+		if err := createUser(tx, &user, invite); err != nil {
+			return err
+		}
+		cred.UserID = user.ID
+		return tx.Create(&cred).Error
+	})
+	if errors.Is(err, errInvalidInvite) {
+		writeRegistrationError(c, err)
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create user", "details": err.Error()})
+		return
+	}
+	RecordAuthEvent(c, db, models.AuthEvent{UserID: user.ID, Type: models.AuthEventAccountCreated, Success: true, Details: string(models.AuthProviderPasskey)})
+
+	// Sign-up succeeds even if the email can not be sent; the user can ask for it again
+	if err := sendVerificationEmail(db, user); err != nil {
+		log.Println("[PasskeySignup] send verification email error:", err)
+	}
+
+	// Accounts let in by their email domain log in once the email is verified
+	if user.EmailVerificationRequired {
+		c.JSON(201, gin.H{"message": "User registered, verify your email to log in", "user_id": user.ID, "email_verification_required": true})
+		return
+	}
+
+	if err := startLoginSession(c, db, user, "passkey"); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+
+	c.JSON(201, loginResponse{
+		UserID:   user.ID,
+		Message:  "User registered successfully",
+		Role:     string(user.Role),
+		Nickname: user.Nickname,
+	})
+}
+
+// PasskeyLoginBegin returns the options for navigator.credentials.get(). Without an email the browser
+// offers the discoverable passkeys it has for this site.
+func PasskeyLoginBegin(c *gin.Context, db *gorm.DB) {
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+
+	var req passkeyLoginBeginRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+			return
+		}
+	}
+
+	allow := make([]credentialDescriptor, 0)
+	if req.Email != "" {
+		var ids []string
+		err := db.Model(&models.WebAuthnCredential{}).
+			Where("user_id IN (?)", db.Model(&models.User{}).Select("id").Where("email = ?", req.Email)).
+			Pluck("credential_id", &ids).Error
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to find passkeys", "details": err.Error()})
+			return
+		}
+		for _, id := range ids {
+			allow = append(allow, credentialDescriptor{Type: "public-key", ID: id})
+		}
+	}
+
+	challenge, ok := storeChallenge(c, db, models.WebAuthnChallenge{Ceremony: models.WebAuthnCeremonyLogin})
+	if !ok {
+		return
+	}
+
+	c.JSON(200, gin.H{"publicKey": gin.H{
+		"challenge":        challenge.Challenge,
+		"rpId":             rp.ID,
+		"timeout":          webAuthnChallengeExpiration.Milliseconds(),
+		"allowCredentials": allow,
+		"userVerification": "required",
+	}})
+}
+
+// PasskeyLoginFinish verifies the assertion and logs the owner of the passkey in
+func PasskeyLoginFinish(c *gin.Context, db *gorm.DB) {
+	rp, ok := relyingParty(c)
+	if !ok {
+		return
+	}
+
+	var req passkeyLoginFinishRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid input", "details": err.Error()})
+		return
+	}
+
+	if !allowRequest(c, loginIPCheck(c)) {
+		return
+	}
+
+	resp := req.Credential.Response
+	clientDataJSON, err1 := decodeBase64URL(resp.ClientDataJSON)
+	authData, err2 := decodeBase64URL(resp.AuthenticatorData)
+	signature, err3 := decodeBase64URL(resp.Signature)
+	if err := errors.Join(err1, err2, err3); err != nil || req.Credential.Type != "public-key" {
+		c.JSON(400, gin.H{"error": "Invalid credential", "details": fmt.Sprint(err)})
+		return
+	}
+
+	challenge, err := consumeChallenge(db, clientDataJSON, models.WebAuthnCeremonyLogin)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Passkey login failed", "details": err.Error()})
+		return
+	}
+
+	var cred models.WebAuthnCredential
+	if err := db.Where("credential_id = ?", strings.TrimRight(req.Credential.ID, "=")).First(&cred).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Passkey login failed", "details": "unknown passkey"})
+		return
+	}
+	if resp.UserHandle != "" && strings.TrimRight(resp.UserHandle, "=") != cred.UserHandle {
+		c.JSON(401, gin.H{"error": "Passkey login failed", "details": "user handle mismatch"})
+		return
+	}
+
+	signCount, err := rp.VerifyAssertion(webauthn.Credential{
+		PublicKey: cred.PublicKey,
+		Algorithm: cred.Algorithm,
+		SignCount: cred.SignCount,
+	}, clientDataJSON, authData, signature, challenge.Challenge)
+	if err != nil {
+		recordFailedLogin(c, db, cred.UserID, "", "invalid_passkey")
+		c.JSON(401, gin.H{"error": "Passkey login failed", "details": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := db.First(&user, cred.UserID).Error; err != nil {
+		c.JSON(401, gin.H{"error": "Passkey login failed", "details": errAccountDeleted.Error()})
+		return
+	}
+	if !checkAccountEnabled(c, user) || !checkEmailVerified(c, db, user) {
+		return
+	}
+
+	if err := db.Model(&cred).UpdateColumns(map[string]any{"sign_count": signCount, "last_used_at": time.Now()}).Error; err != nil {
+		log.Println("[PasskeyLogin] update credential error:", err)
+	}
+
+	// A passkey already is possession plus user verification, so no second factor is asked for
+	if err := startLoginSession(c, db, user, "passkey"); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate token", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, loginResponse{
+		UserID:   user.ID,
+		Message:  "Login successful",
+		Role:     string(user.Role),
+		Nickname: user.Nickname,
+	})
+}
+
+// ListPasskeys lists the passkeys of the logged-in user
+func ListPasskeys(c *gin.Context, db *gorm.DB) {
+	tokenUser, err := utils.GetTokenUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := db.Where("user_id = ?", tokenUser.ID).Order("created_at").Find(&credentials).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list passkeys", "details": err.Error()})
+		return
+	}
+
+	result := make([]passkeyResponse, 0, len(credentials))
+	for _, cred := range credentials {
+		result = append(result, newPasskeyResponse(cred))
+	}
+
+	c.JSON(200, gin.H{"data": result})
+}
+
+// DeletePasskey removes one passkey of the logged-in user by id. Passkey-only accounts keep their last passkey.
+func DeletePasskey(c *gin.Context, db *gorm.DB) {
+	user, ok := loadCurrentUser(c, db)
+	if !ok {
+		return
+	}
+
+	var id uint
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &id); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid passkey id"})
+		return
+	}
+
+	var cred models.WebAuthnCredential
+	if err := db.Where("id = ? AND user_id = ?", id, user.ID).First(&cred).Error; err != nil {
+		c.JSON(404, gin.H{"error": "Passkey not found"})
+		return
+	}
+
+	if user.Provider == models.AuthProviderPasskey {
+		var count int64
+		if err := db.Model(&models.WebAuthnCredential{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+			c.JSON(500, gin.H{"error": "Failed to delete passkey", "details": err.Error()})
+			return
+		}
+		if count <= 1 {
+			c.JSON(409, gin.H{"error": "Can not delete the last passkey of an account without a password"})
+			return
+		}
+	}
+
+	if err := db.Unscoped().Delete(&cred).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete passkey", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Passkey deleted"})
+}
+
+// relyingParty reads the passkey settings, writing an error response when they are missing
+func relyingParty(c *gin.Context) (webauthn.RelyingParty, bool) {
+	raw, err := config.GetVariableAsString("WEBAUTHN_ORIGINS")
+	var origins []string
+	if err == nil {
+		for _, origin := range strings.Split(raw, ",") {
+			if origin = strings.TrimRight(strings.TrimSpace(origin), "/"); origin != "" {
+				origins = append(origins, strings.ToLower(origin))
+			}
+		}
+	} else {
+		origins = loginRedirectOrigins()
+	}
+	if len(origins) == 0 {
+		c.JSON(500, gin.H{"error": "Passkeys are not configured", "details": errPasskeysNotConfigured.Error()})
+		return webauthn.RelyingParty{}, false
+	}
+
+	rpID, err := config.GetVariableAsString("WEBAUTHN_RP_ID")
+	if err != nil {
+		u, err := url.Parse(origins[0])
+		if err != nil || u.Hostname() == "" {
+			c.JSON(500, gin.H{"error": "Passkeys are not configured", "details": fmt.Sprintf("invalid origin %q", origins[0])})
+			return webauthn.RelyingParty{}, false
+		}
+		rpID = u.Hostname()
+	}
+
+	return webauthn.RelyingParty{ID: rpID, Origins: origins}, true
+}
+
+// creationOptions builds PublicKeyCredentialCreationOptions in the JSON form of PublicKeyCredential.parseCreationOptionsFromJSON()
+func creationOptions(rp webauthn.RelyingParty, challenge models.WebAuthnChallenge, email, nickname string, exclude []credentialDescriptor, residentKey string) gin.H {
+	name, err := config.GetVariableAsString("WEBAUTHN_RP_NAME")
+	if err != nil {
+		name = "夢.台灣"
+	}
+
+	params := make([]gin.H, 0, len(webauthn.SupportedAlgorithms))
+	for _, alg := range webauthn.SupportedAlgorithms {
+		params = append(params, gin.H{"type": "public-key", "alg": alg})
+	}
+
+	return gin.H{
+		"challenge":          challenge.Challenge,
+		"rp":                 gin.H{"id": rp.ID, "name": name},
+		"user":               gin.H{"id": challenge.UserHandle, "name": email, "displayName": nickname},
+		"pubKeyCredParams":   params,
+		"timeout":            webAuthnChallengeExpiration.Milliseconds(),
+		"attestation":        "none",
+		"excludeCredentials": exclude,
+		"authenticatorSelection": gin.H{
+			"residentKey":        residentKey,
+			"requireResidentKey": residentKey == "required",
+			"userVerification":   "required",
+		},
+	}
+}
+
+// beginRegistration stores a registration challenge, generating the user handle when there is none yet
+func beginRegistration(c *gin.Context, db *gorm.DB, challenge models.WebAuthnChallenge) (models.WebAuthnChallenge, bool) {
+	if challenge.UserHandle == "" {
+		userHandle, err := randomToken()
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to generate challenge", "details": err.Error()})
+			return models.WebAuthnChallenge{}, false
+		}
+		challenge.UserHandle = userHandle
+	}
+	challenge.Ceremony = models.WebAuthnCeremonyRegister
+	return storeChallenge(c, db, challenge)
+}
+
+// finishRegistration consumes the challenge answered by a registration and verifies the new credential
+func finishRegistration(c *gin.Context, db *gorm.DB, req passkeyRegisterFinishRequest) (models.WebAuthnChallenge, models.WebAuthnCredential, bool) {
+	rp, ok := relyingParty(c)
+	if !ok {
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > 64 {
+		c.JSON(400, gin.H{"error": "Name must be at most 64 characters"})
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+
+	clientDataJSON, err1 := decodeBase64URL(req.Credential.Response.ClientDataJSON)
+	attestationObject, err2 := decodeBase64URL(req.Credential.Response.AttestationObject)
+	if err := errors.Join(err1, err2); err != nil || req.Credential.Type != "public-key" {
+		c.JSON(400, gin.H{"error": "Invalid credential", "details": fmt.Sprint(err)})
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+
+	challenge, err := consumeChallenge(db, clientDataJSON, models.WebAuthnCeremonyRegister)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Passkey registration failed", "details": err.Error()})
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+
+	verified, err := rp.VerifyRegistration(clientDataJSON, attestationObject, challenge.Challenge)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Passkey registration failed", "details": err.Error()})
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+
+	credentialID := base64.RawURLEncoding.EncodeToString(verified.ID)
+	var count int64
+	if err := db.Model(&models.WebAuthnCredential{}).Where("credential_id = ?", credentialID).Count(&count).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save passkey", "details": err.Error()})
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+	if count > 0 {
+		c.JSON(409, gin.H{"error": "Passkey is already registered"})
+		return models.WebAuthnChallenge{}, models.WebAuthnCredential{}, false
+	}
+
+	return challenge, models.WebAuthnCredential{
+		Name:         name,
+		CredentialID: credentialID,
+		PublicKey:    verified.PublicKey,
+		Algorithm:    verified.Algorithm,
+		UserHandle:   challenge.UserHandle,
+		SignCount:    verified.SignCount,
+	}, true
+}
+
+// storeChallenge saves a new random challenge for a ceremony, dropping expired ones on the way
+func storeChallenge(c *gin.Context, db *gorm.DB, challenge models.WebAuthnChallenge) (models.WebAuthnChallenge, bool) {
+	if err := db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnChallenge{}).Error; err != nil {
+		log.Println("[WebAuthn] remove expired challenges error:", err)
+	}
+
+	value, err := webauthn.NewChallenge()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate challenge", "details": err.Error()})
+		return models.WebAuthnChallenge{}, false
+	}
+	challenge.Challenge = value
+	challenge.ExpiresAt = time.Now().Add(webAuthnChallengeExpiration)
+
+	if err := db.Create(&challenge).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to generate challenge", "details": err.Error()})
+		return models.WebAuthnChallenge{}, false
+	}
+	return challenge, true
+}
+
+// consumeChallenge finds the unexpired challenge clientDataJSON answers and deletes it so it can not be answered again
+func consumeChallenge(db *gorm.DB, clientDataJSON []byte, ceremony string) (models.WebAuthnChallenge, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return models.WebAuthnChallenge{}, err
+	}
+
+	var challenge models.WebAuthnChallenge
+	err = db.Where("challenge = ? AND ceremony = ? AND expires_at > ?", clientData.Challenge, ceremony, time.Now()).First(&challenge).Error
+	if err != nil {
+		return models.WebAuthnChallenge{}, errInvalidChallenge
+	}
+
+	// Only the request that actually deletes the row may use the challenge
+	result := db.Unscoped().Delete(&models.WebAuthnChallenge{}, challenge.ID)
+	if result.Error != nil {
+		return models.WebAuthnChallenge{}, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return models.WebAuthnChallenge{}, errInvalidChallenge
+	}
+	return challenge, nil
+}
+
+// checkPasskeyEmailFree writes 409 when a passkey account with email already exists
+func checkPasskeyEmailFree(c *gin.Context, db *gorm.DB, email string) bool {
+	var count int64
+	if err := db.Model(&models.User{}).Where("provider = ? AND email = ?", models.AuthProviderPasskey, email).Count(&count).Error; err != nil {
+		c.JSON(500, gin.H{"error": "Failed to check email", "details": err.Error()})
+		return false
+	}
+	if count > 0 {
+		c.JSON(409, gin.H{"error": "Email is already registered"})
+		return false
+	}
+	return true
+}
+
+// decodeBase64URL decodes base64url with or without padding, as browsers and libraries differ
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func newPasskeyResponse(cred models.WebAuthnCredential) passkeyResponse {
+	return passkeyResponse{
+		ID:         cred.ID,
+		Name:       cred.Name,
+		CreatedAt:  cred.CreatedAt,
+		LastUsedAt: cred.LastUsedAt,
+	}
+}
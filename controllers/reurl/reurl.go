@@ -101,12 +101,12 @@ func CreateReurl(c *gin.Context, db *gorm.DB) {
     c.JSON(http.StatusCreated, gin.H{"data": reurl})
 }
 
-// ListReurls lists mappings. Admins see all, regular users see their own.
+// ListReurls lists mappings. Users with reurl:manage_all see all, others see their own and those shared with them.
 func ListReurls(c *gin.Context, db *gorm.DB) {
     user, _ := utils.GetTokenUser(c) // AuthRequired ensures existence
 
     var results []models.Reurl
-    if utils.IsAdminUser(c) {
+    if utils.HasPermission(c, models.PermReurlManageAll) {
 		_ = ClearExpiredUrls(db, nil, nil, nil)
         if err := db.Preload("Owner").Find(&results).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": "db error", "details": err.Error()})
@@ -117,7 +117,7 @@ func ListReurls(c *gin.Context, db *gorm.DB) {
     }
 
 	_ = ClearExpiredUrls(db, &user.ID, nil, nil)
-    if err := db.Where("owner_id = ?", user.ID).Find(&results).Error; err != nil {
+    if err := db.Where("owner_id = ? OR id IN (?)", user.ID, grantedReurls(db, user.ID)).Find(&results).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "db error", "details": err.Error()})
         return
     }
@@ -125,7 +125,7 @@ func ListReurls(c *gin.Context, db *gorm.DB) {
     c.JSON(http.StatusOK, gin.H{"data": results})
 }
 
-// GetReurl returns a single mapping by ID. Admins can view any; users can view only their own.
+// GetReurl returns a single mapping by ID. Admins can view any; users can view their own and those shared with them.
 func GetReurl(c *gin.Context, db *gorm.DB) {
     idStr := c.Param("id")
 
@@ -149,12 +149,9 @@ func GetReurl(c *gin.Context, db *gorm.DB) {
         return
     }
 
-    if !utils.IsAdminUser(c) {
-        user, _ := utils.GetTokenUser(c)
-        if reurl.OwnerID != user.ID {
-            c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
-            return
-        }
+    if !canView(c, db, reurl) {
+        c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+        return
     }
 
     c.JSON(http.StatusOK, gin.H{"data": reurl})
@@ -184,12 +181,9 @@ func PatchReurl(c *gin.Context, db *gorm.DB) {
         return
     }
 
-    if !utils.IsAdminUser(c) {
-        user, _ := utils.GetTokenUser(c)
-        if reurl.OwnerID != user.ID {
-            c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
-            return
-        }
+    if !canManage(c, reurl) {
+        c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+        return
     }
 
     var req PatchReurlRequest
@@ -264,12 +258,9 @@ func DeleteReurl(c *gin.Context, db *gorm.DB) {
         return
     }
 
-    if !utils.IsAdminUser(c) {
-        user, _ := utils.GetTokenUser(c)
-        if reurl.OwnerID != user.ID {
-            c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
-            return
-        }
+    if !canManage(c, reurl) {
+        c.JSON(http.StatusForbidden, gin.H{"error": "not allowed"})
+        return
     }
 
     if err := db.Delete(&reurl).Error; err != nil {
@@ -280,6 +271,33 @@ func DeleteReurl(c *gin.Context, db *gorm.DB) {
     c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// canManage reports whether the current user owns reurl or may manage every mapping
+func canManage(c *gin.Context, reurl models.Reurl) bool {
+    if utils.HasPermission(c, models.PermReurlManageAll) {
+        return true
+    }
+    user, _ := utils.GetTokenUser(c)
+    return reurl.OwnerID == user.ID
+}
+
+// canView reports whether the current user can manage reurl or was given read access to it by a GuestGrant
+func canView(c *gin.Context, db *gorm.DB, reurl models.Reurl) bool {
+    if canManage(c, reurl) {
+        return true
+    }
+    user, _ := utils.GetTokenUser(c)
+    var count int64
+    if err := db.Model(&models.GuestGrant{}).Where("user_id = ? AND resource = ? AND reurl_id = ?", user.ID, models.GrantReurl, reurl.ID).Count(&count).Error; err != nil {
+        return false
+    }
+    return count > 0
+}
+
+// grantedReurls is a subquery selecting the ids of the mappings shared with userID
+func grantedReurls(db *gorm.DB, userID uint) *gorm.DB {
+    return db.Model(&models.GuestGrant{}).Select("reurl_id").Where("user_id = ? AND resource = ?", userID, models.GrantReurl)
+}
+
 // Redirect looks up mapping by key (public) and performs HTTP redirect if found and not expired.
 func Redirect(c *gin.Context, db *gorm.DB) {
     key := c.Param("key")
@@ -1,13 +1,19 @@
 package storage
 
 import (
-	"io"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"log"
-	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type updateFileRequest struct {
@@ -24,7 +30,7 @@ func GetFile(c *gin.Context) {
 	c.File(filePath)
 }
 
-func DeleteFile(c *gin.Context) {
+func DeleteFile(c *gin.Context, db *gorm.DB) {
 	filePath, err := convertToStoragePath(c.Param("file_path"), c)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Cannot delete file"})
@@ -36,18 +42,89 @@ func DeleteFile(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Failed to delete file"})
 		return
 	}
+	invalidateUsage(db, utils.GetUserID(c))
 
 	c.JSON(200, gin.H{"message": "File deleted successfully"})
 }
 
-func UploadFile(c *gin.Context) {
-	err := saveFile(c)
+// UploadFile stores one chunk of a chunked upload. Chunks may arrive in any order; the request with the last
+// chunk_index merges all of them into the file synchronously, so its response tells whether the file is complete.
+// The merge is recorded as a models.MergeJob, see GetMergeJob.
+func UploadFile(c *gin.Context, db *gorm.DB) {
+	fileID := c.PostForm("file_id")
+	chunkIndex, indexErr := strconv.Atoi(c.PostForm("chunk_index"))
+	totalChunks, totalErr := strconv.Atoi(c.PostForm("total_chunks"))
+	if !validFileID(fileID) || indexErr != nil || totalErr != nil || totalChunks < 1 || chunkIndex < 0 || chunkIndex >= totalChunks {
+		c.JSON(400, gin.H{"error": "Cannot upload file", "details": "file_id, chunk_index and total_chunks are required"})
+		return
+	}
+	expectedSHA256 := strings.ToLower(c.PostForm("sha256"))
+	if _, err := hex.DecodeString(expectedSHA256); err != nil || (expectedSHA256 != "" && len(expectedSHA256) != sha256.Size*2) {
+		c.JSON(400, gin.H{"error": "sha256 must be a hex encoded SHA-256"})
+		return
+	}
+
+	file, header, err := c.Request.FormFile("chunk_data")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Missing chunk_data"})
+		return
+	}
+	defer file.Close()
+	if !checkQuota(c, db, header.Size) {
+		return
+	}
+
+	// 暫存目錄
+	tmpDir, err := convertToTmpDataPath(fileID, c)
+	if err == nil {
+		err = mkDirIfNotExists(tmpDir)
+	}
+	if err == nil {
+		err = writeMultipartFile(filepath.Join(tmpDir, strconv.Itoa(chunkIndex)), file)
+	}
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to save file"})
 		return
 	}
 
-	c.JSON(201, gin.H{"message": "File uploaded successfully"})
+	if chunkIndex+1 < totalChunks {
+		c.JSON(201, gin.H{"message": "Chunk uploaded successfully"})
+		return
+	}
+
+	if missing := missingChunks(tmpDir, totalChunks); len(missing) > 0 {
+		c.JSON(409, gin.H{"error": "Missing chunks, upload them and send the last chunk again", "missing": missing})
+		return
+	}
+	filePath, err := convertToStoragePath(c.Param("file_path"), c)
+	if err == nil {
+		err = mkDirIfNotExists(filepath.Dir(filePath))
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	job := models.MergeJob{
+		UserID:         utils.GetUserID(c),
+		FileID:         fileID,
+		Path:           c.Param("file_path"),
+		TotalChunks:    totalChunks,
+		ExpectedSHA256: expectedSHA256,
+	}
+	job, err = runMergeJob(db, job, tmpDir, filePath)
+	if errors.Is(err, errChecksumMismatch) {
+		c.JSON(422, gin.H{"error": "Checksum mismatch, upload the file again", "job": newMergeJobResponse(job)})
+		return
+	}
+	if err != nil {
+		log.Println("[UploadFile] merge chunks error:", err, "path:", filePath)
+		c.JSON(500, gin.H{"error": "Failed to merge file", "details": err.Error(), "job": newMergeJobResponse(job)})
+		return
+	}
+
+	invalidateUsage(db, job.UserID)
+	c.JSON(201, gin.H{"message": "File uploaded successfully", "job": newMergeJobResponse(job)})
 }
 
 func UpdateFile(c *gin.Context) {
@@ -80,86 +157,7 @@ func UpdateFile(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "File updated successfully"})
 }
 
-func saveFile(c *gin.Context) error {
-	fileID := c.PostForm("file_id")
-	chunkIndexStr := c.PostForm("chunk_index")
-	totalChunksStr := c.PostForm("total_chunks")
-
-	chunkIndex, err := strconv.Atoi(chunkIndexStr)
-	if err != nil {
-		return err
-	}
-	totalChunks, err := strconv.Atoi(totalChunksStr)
-	if err != nil {
-		return err
-	}
-
-	file, _, err := c.Request.FormFile("chunk_data")
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// 暫存目錄
-	tmpDir, err := convertToTmpDataPath(fileID, c)
-	if err != nil {
-		return err
-	}
-	err = mkDirIfNotExists(tmpDir)
-	if err != nil {
-		return err
-	}
-
-	chunkPath := filepath.Join(tmpDir, chunkIndexStr)
-	err = writeMultipartFile(chunkPath, file)
-	if err != nil {
-		return err
-	}
-
-	if chunkIndex+1 == totalChunks {
-		filePath, err := convertToStoragePath(c.Param("file_path"), c)
-		if err != nil {
-			return err
-		}
-		if err := mkDirIfNotExists(filepath.Dir(filePath)); err != nil {
-			return err
-		}
-
-		// Merge in background to avoid blocking the request
-		go func(tmpDir, filePath string, totalChunks int) {
-			defer os.RemoveAll(tmpDir)
-
-			finalOut, err := os.Create(filePath)
-			if err != nil {
-				log.Println("merge create final file error:", err)
-				return
-			}
-			defer finalOut.Close()
-
-			// Merge all chunks 0..totalChunks-1
-			for i := 0; i < totalChunks; i++ {
-				chunkFilePath := filepath.Join(tmpDir, strconv.Itoa(i))
-				chunkFile, err := os.Open(chunkFilePath)
-				if err != nil {
-					log.Println("open chunk error:", err, "path:", chunkFilePath)
-					return
-				}
-				if _, err = io.Copy(finalOut, chunkFile); err != nil {
-					chunkFile.Close()
-					log.Println("copy chunk error:", err, "path:", chunkFilePath)
-					return
-				}
-				chunkFile.Close()
-			}
-
-			// Cleanup tmp dir
-			if err := os.RemoveAll(tmpDir); err != nil {
-				log.Println("cleanup tmp dir error:", err, "dir:", tmpDir)
-			}
-		}(tmpDir, filePath, totalChunks)
-
-		return nil
-	}
-
-	return nil
+// validFileID 檢查 file_id 是單一路徑元素，避免分塊寫到暫存目錄之外
+func validFileID(fileID string) bool {
+	return fileID != "" && fileID != "." && fileID != ".." && !strings.ContainsAny(fileID, `/\`)
 }
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"personal_site/controllers/utils"
+
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type updateFolderRequest struct {
@@ -70,7 +73,7 @@ func UpdateFolder(c *gin.Context) {
 	c.JSON(200, gin.H{"message": "Folder updated successfully"})
 }
 
-func DeleteFolder(c *gin.Context) {
+func DeleteFolder(c *gin.Context, db *gorm.DB) {
 	folderPath, err := convertToStoragePath(c.Param("folder_path"), c)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Failed to delete folder"})
@@ -82,6 +85,7 @@ func DeleteFolder(c *gin.Context) {
 		c.JSON(500, gin.H{"error": "Failed to delete folder"})
 		return
 	}
+	invalidateUsage(db, utils.GetUserID(c))
 
 	c.JSON(200, gin.H{"message": "Folder deleted successfully"})
 }
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// maxMergeJobError is the size of models.MergeJob.Error
+const maxMergeJobError = 512
+
+// errChecksumMismatch is returned by mergeChunks when the merged file does not have the SHA-256 the client sent
+var errChecksumMismatch = errors.New("SHA-256 of the merged file does not match")
+
+type mergeJobResponse struct {
+	FileID      string                `json:"file_id"`
+	Path        string                `json:"path"`
+	TotalChunks int                   `json:"total_chunks"`
+	Status      models.MergeJobStatus `json:"status"`
+	Error       string                `json:"error,omitempty"`
+	SHA256      string                `json:"sha256,omitempty"`
+	Size        int64                 `json:"size"`
+	CreatedAt   time.Time             `json:"created_at"`
+	FinishedAt  *time.Time            `json:"finished_at"`
+}
+
+// GetMergeJob reports the latest merge of the current user's chunked upload with the :file_id path parameter,
+// e.g. for a client whose last chunk request timed out.
+func GetMergeJob(c *gin.Context, db *gorm.DB) {
+	var job models.MergeJob
+	err := db.Where("user_id = ? AND file_id = ?", utils.GetUserID(c), c.Param("file_id")).Order("id DESC").First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(404, gin.H{"error": "No merge job for this file_id"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to find merge job", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": newMergeJobResponse(job)})
+}
+
+// runMergeJob merges the chunks of a complete chunked upload into filePath and records the outcome as a
+// models.MergeJob. The chunks are removed once merged, and after a checksum mismatch since one of them is
+// corrupt; after other failures they are kept so the client can retry by sending the last chunk again.
+func runMergeJob(db *gorm.DB, job models.MergeJob, tmpDir, filePath string) (models.MergeJob, error) {
+	job.Status = models.MergeJobMerging
+	if err := db.Create(&job).Error; err != nil {
+		return job, err
+	}
+
+	sum, size, mergeErr := mergeChunks(tmpDir, filePath, job.TotalChunks, job.ExpectedSHA256)
+	now := time.Now()
+	job.FinishedAt = &now
+	if mergeErr != nil {
+		job.Status = models.MergeJobFailed
+		job.Error = truncateError(mergeErr.Error())
+	} else {
+		job.Status = models.MergeJobCompleted
+		job.SHA256 = sum
+		job.Size = size
+	}
+	if mergeErr == nil || errors.Is(mergeErr, errChecksumMismatch) {
+		_ = rmdir(tmpDir)
+	}
+
+	if err := db.Model(&job).Select("status", "error", "sha256", "size", "finished_at").Updates(&job).Error; err != nil {
+		// The job would stay "merging" forever, so the failure to record it is reported along with the merge error
+		return job, errors.Join(mergeErr, fmt.Errorf("record merge job: %w", err))
+	}
+	return job, mergeErr
+}
+
+// truncateError cuts a merge error down to the size of MergeJob.Error without splitting a UTF-8 character;
+// errors carry full paths that can be longer
+func truncateError(message string) string {
+	if len(message) <= maxMergeJobError {
+		return message
+	}
+	return strings.ToValidUTF8(message[:maxMergeJobError], "")
+}
+
+// missingChunks 回傳暫存目錄中缺少的分塊編號
+func missingChunks(tmpDir string, totalChunks int) []int {
+	missing := make([]int, 0)
+	for i := 0; i < totalChunks; i++ {
+		if _, err := os.Stat(filepath.Join(tmpDir, strconv.Itoa(i))); err != nil {
+			missing = append(missing, i)
+		}
+	}
+	return missing
+}
+
+// mergeChunks 檢查分塊 0..totalChunks-1 皆存在後，依序合併到 filePath 同目錄下的暫存檔，
+// 完成後才以 rename 原子地取代 filePath，因此失敗時不會留下不完整的檔案。
+// 回傳合併後檔案的 SHA-256（hex）與大小；expectedSHA256 不為空且不相符時回傳 errChecksumMismatch
+func mergeChunks(tmpDir, filePath string, totalChunks int, expectedSHA256 string) (string, int64, error) {
+	if missing := missingChunks(tmpDir, totalChunks); len(missing) > 0 {
+		return "", 0, fmt.Errorf("missing chunks %v", missing)
+	}
+
+	partFile, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.part")
+	if err != nil {
+		return "", 0, fmt.Errorf("create temp file: %w", err)
+	}
+	partPath := partFile.Name()
+	committed := false
+	defer func() {
+		partFile.Close()
+		if !committed {
+			os.Remove(partPath)
+		}
+	}()
+
+	hash := sha256.New()
+	out := io.MultiWriter(partFile, hash)
+	var size int64
+	for i := 0; i < totalChunks; i++ {
+		chunkFile, err := os.Open(filepath.Join(tmpDir, strconv.Itoa(i)))
+		if err != nil {
+			return "", 0, fmt.Errorf("open chunk %d: %w", i, err)
+		}
+		n, err := io.Copy(out, chunkFile)
+		chunkFile.Close()
+		if err != nil {
+			return "", 0, fmt.Errorf("copy chunk %d: %w", i, err)
+		}
+		size += n
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if expectedSHA256 != "" && sum != expectedSHA256 {
+		return "", 0, fmt.Errorf("%w: got %s", errChecksumMismatch, sum)
+	}
+
+	if err := partFile.Sync(); err != nil {
+		return "", 0, fmt.Errorf("sync temp file: %w", err)
+	}
+	if err := partFile.Close(); err != nil {
+		return "", 0, fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(partPath, filePath); err != nil {
+		return "", 0, fmt.Errorf("rename temp file: %w", err)
+	}
+	committed = true
+	return sum, size, nil
+}
+
+func newMergeJobResponse(job models.MergeJob) mergeJobResponse {
+	return mergeJobResponse{
+		FileID:      job.FileID,
+		Path:        job.Path,
+		TotalChunks: job.TotalChunks,
+		Status:      job.Status,
+		Error:       job.Error,
+		SHA256:      job.SHA256,
+		Size:        job.Size,
+		CreatedAt:   job.CreatedAt,
+		FinishedAt:  job.FinishedAt,
+	}
+}
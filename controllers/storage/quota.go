@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"personal_site/config"
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Unlimited is the quota of users who may store any amount
+const Unlimited int64 = -1
+
+// usageRefreshInterval is how long a cached usage is trusted before the tree is walked again, which picks up
+// files changed outside the API
+const usageRefreshInterval = time.Hour
+
+// defaultRoleQuotas are the storage quotas of each role unless STORAGE_QUOTA_<ROLE> is set, e.g.
+// STORAGE_QUOTA_USER=20GB or STORAGE_QUOTA_ADMIN=unlimited. A user's StorageQuota overrides the role's.
+var defaultRoleQuotas = map[models.Role]int64{
+	models.RoleAdmin:     Unlimited,
+	models.RoleUser:      10 << 30,
+	models.RoleGuest:     0,
+	models.RoleAnonymous: 100 << 20,
+}
+
+var quotaUnits = []struct {
+	suffix string
+	size   int64
+}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+type usageResponse struct {
+	Used    int64  `json:"used"`    // bytes stored
+	Pending int64  `json:"pending"` // bytes reserved by unfinished uploads
+	Limit   *int64 `json:"limit"`   // quota in bytes, null when unlimited
+}
+
+// GetUsage reports how much of their quota the current user uses
+func GetUsage(c *gin.Context, db *gorm.DB) {
+	userID := utils.GetUserID(c)
+	limit, err := quotaLimit(c, db)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get storage usage", "details": err.Error()})
+		return
+	}
+	used, err := storageUsage(db, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get storage usage", "details": err.Error()})
+		return
+	}
+	pending, err := pendingUploadSize(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to get storage usage", "details": err.Error()})
+		return
+	}
+
+	response := usageResponse{Used: used, Pending: pending}
+	if limit != Unlimited {
+		response.Limit = &limit
+	}
+	c.JSON(200, gin.H{"data": response})
+}
+
+// ParseStorageQuota reads a quota as bytes with an optional unit (B, KB, MB, GB, TB; powers of 1024),
+// or "unlimited" for Unlimited
+func ParseStorageQuota(raw string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(raw))
+	if value == "UNLIMITED" || value == "-1" {
+		return Unlimited, nil
+	}
+	unit := int64(1)
+	for _, u := range quotaUnits {
+		if number, found := strings.CutSuffix(value, u.suffix); found {
+			value, unit = strings.TrimSpace(number), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/unit {
+		return 0, fmt.Errorf("invalid storage quota %q", raw)
+	}
+	return n * unit, nil
+}
+
+// checkQuota writes 413 and returns false when storing incoming more bytes would exceed the current user's quota.
+// Unfinished uploads count as well, so parallel uploads can not exceed it together.
+func checkQuota(c *gin.Context, db *gorm.DB, incoming int64) bool {
+	userID := utils.GetUserID(c)
+	limit, err := quotaLimit(c, db)
+	if err == nil && limit == Unlimited {
+		return true
+	}
+	var used, pending int64
+	if err == nil {
+		used, err = storageUsage(db, userID)
+	}
+	if err == nil {
+		pending, err = pendingUploadSize(userID)
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to check storage quota", "details": err.Error()})
+		return false
+	}
+
+	if used+pending+incoming > limit {
+		c.JSON(413, gin.H{"error": "Storage quota exceeded", "used": used, "pending": pending, "limit": limit})
+		return false
+	}
+	return true
+}
+
+// quotaLimit returns the current user's quota in bytes: their own StorageQuota, or the one of their role
+func quotaLimit(c *gin.Context, db *gorm.DB) (int64, error) {
+	user, err := utils.GetTokenUser(c)
+	if err != nil {
+		return 0, err
+	}
+	if !user.IsAnonymous() {
+		var stored models.User
+		if err := db.Select("id", "storage_quota").First(&stored, user.ID).Error; err != nil {
+			return 0, err
+		}
+		if stored.StorageQuota != nil {
+			return *stored.StorageQuota, nil
+		}
+	}
+	return roleQuota(user.Role), nil
+}
+
+// roleQuota reads STORAGE_QUOTA_<ROLE>, falling back to defaultRoleQuotas
+func roleQuota(role models.Role) int64 {
+	if raw, err := config.GetVariableAsString("STORAGE_QUOTA_" + strings.ToUpper(string(role))); err == nil {
+		if quota, err := ParseStorageQuota(raw); err == nil {
+			return quota
+		}
+	}
+	return defaultRoleQuotas[role]
+}
+
+// storageUsage returns the bytes stored under data/<userID>, walking the tree only when the cached
+// models.StorageUsage is missing, stale or older than usageRefreshInterval
+func storageUsage(db *gorm.DB, userID uint) (int64, error) {
+	var usage models.StorageUsage
+	err := db.Where("user_id = ?", userID).First(&usage).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, err
+	}
+	if err == nil && !usage.Stale && time.Since(usage.ComputedAt) < usageRefreshInterval {
+		return usage.Used, nil
+	}
+
+	storageRoot, err := GetStorageRoot()
+	if err != nil {
+		return 0, err
+	}
+	used, err := dirSize(filepath.Join(storageRoot, "data", fmt.Sprintf("%d", userID)))
+	if err != nil {
+		return 0, err
+	}
+	err = db.Where(map[string]any{"user_id": userID}).
+		Assign(map[string]any{"used": used, "stale": false, "computed_at": time.Now()}).
+		FirstOrCreate(&usage).Error
+	return used, err
+}
+
+// invalidateUsage marks the cached usage of a user stale after files were added or removed
+func invalidateUsage(db *gorm.DB, userID uint) {
+	_ = db.Model(&models.StorageUsage{}).Where("user_id = ?", userID).Update("stale", true).Error
+}
+
+// pendingUploadSize 計算使用者未完成的上傳佔用的空間：續傳上傳以 Upload-Length 計算（整個檔案已預留），
+// 分塊上傳以已寫入的分塊計算
+func pendingUploadSize(userID uint) (int64, error) {
+	storageRoot, err := GetStorageRoot()
+	if err != nil {
+		return 0, err
+	}
+	userTmpDir := filepath.Join(storageRoot, "tmp", fmt.Sprintf("%d", userID))
+	entries, err := os.ReadDir(userTmpDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		uploadDir := filepath.Join(userTmpDir, entry.Name())
+		if content, err := os.ReadFile(filepath.Join(uploadDir, uploadInfoFile)); err == nil {
+			var info uploadInfo
+			if err := json.Unmarshal(content, &info); err == nil {
+				total += info.Length
+				continue
+			}
+		}
+		size, err := dirSize(uploadDir)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// dirSize 計算目錄下所有檔案的大小總和，目錄不存在時為 0
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			// Removed while walking
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type sharedFolderResponse struct {
+	GrantID uint   `json:"grant_id"`
+	OwnerID uint   `json:"owner_id"`
+	Owner   string `json:"owner"` // nickname of the user sharing the folder
+	Path    string `json:"path"`
+}
+
+// ListShared lists the folders other users shared with the current user. Their content is read with
+// the grant_id under /shared/:grant_id/folder and /shared/:grant_id/file.
+func ListShared(c *gin.Context, db *gorm.DB) {
+	user, err := utils.GetTokenUser(c)
+	if err != nil || user.IsAnonymous() {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	result := make([]sharedFolderResponse, 0)
+	err = db.Table("guest_grants").
+		Select("guest_grants.id AS grant_id, guest_grants.owner_id, users.nickname AS owner, guest_grants.path").
+		Joins("JOIN users ON users.id = guest_grants.owner_id AND users.deleted_at IS NULL").
+		Where("guest_grants.user_id = ? AND guest_grants.resource = ? AND guest_grants.deleted_at IS NULL", user.ID, models.GrantStorage).
+		Order("guest_grants.id").
+		Scan(&result).Error
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to list shared folders", "details": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"data": result})
+}
+
+func ListSharedFolder(c *gin.Context, db *gorm.DB) {
+	folderPath, ok := convertToSharedPath(c, db, c.Param("folder_path"))
+	if !ok {
+		return
+	}
+
+	folderContent, err := getFolderContent(folderPath)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Folder not found"})
+		return
+	}
+
+	c.JSON(200, folderContent)
+}
+
+func GetSharedFile(c *gin.Context, db *gorm.DB) {
+	filePath, ok := convertToSharedPath(c, db, c.Param("file_path"))
+	if !ok {
+		return
+	}
+
+	if info, err := os.Stat(filePath); err != nil || info.IsDir() {
+		c.JSON(404, gin.H{"error": "File not found"})
+		return
+	}
+
+	c.File(filePath)
+}
+
+// convertToSharedPath 將路徑轉換為 :grant_id 分享的資料夾中的實際路徑，失敗時寫入錯誤回應
+// 路徑會先被清理，因此無法跳出分享的資料夾
+func convertToSharedPath(c *gin.Context, db *gorm.DB, path string) (string, bool) {
+	user, err := utils.GetTokenUser(c)
+	if err != nil || user.IsAnonymous() {
+		c.JSON(401, gin.H{"error": "Unauthorized"})
+		return "", false
+	}
+
+	var grantID uint
+	if _, err := fmt.Sscanf(c.Param("grant_id"), "%d", &grantID); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid grant id"})
+		return "", false
+	}
+
+	var grant models.GuestGrant
+	if err := db.Where("id = ? AND user_id = ? AND resource = ?", grantID, user.ID, models.GrantStorage).First(&grant).Error; err != nil {
+		writeSharedLookupError(c, err)
+		return "", false
+	}
+	var owner models.User
+	if err := db.Select("id", "storage_dir").First(&owner, grant.OwnerID).Error; err != nil {
+		writeSharedLookupError(c, err)
+		return "", false
+	}
+
+	storageRoot, err := GetStorageRoot()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to find shared folder", "details": err.Error()})
+		return "", false
+	}
+	sharedRoot := filepath.Join(storageRoot, "data", fmt.Sprintf("%d", owner.ID), owner.StorageDir, filepath.FromSlash(grant.Path))
+	return filepath.Join(sharedRoot, filepath.Clean("/"+path)), true
+}
+
+func writeSharedLookupError(c *gin.Context, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(404, gin.H{"error": "Shared folder not found"})
+		return
+	}
+	c.JSON(500, gin.H{"error": "Failed to find shared folder", "details": err.Error()})
+}
@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"personal_site/controllers/utils"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Resumable uploads speak the tus protocol 1.0.0 (https://tus.io/protocols/resumable-upload) with the creation,
+// expiration and termination extensions. An upload lives in tmp/<user>/<upload id> like a chunked upload: every
+// PATCH is stored as the next numbered chunk and uploadInfoFile keeps the length and destination, so the offset
+// survives restarts. Uploads untouched for TmpExpiration are removed by tasks.ClearTmpStorage, unless a PATCH is
+// still writing them.
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,expiration,termination"
+	uploadInfoFile = "info.json"
+)
+
+// uploadInfo is the state of a resumable upload that is not kept in its chunks
+type uploadInfo struct {
+	Length   int64  `json:"length"`   // total size in bytes, Upload-Length
+	Path     string `json:"path"`     // destination in the user's storage
+	Metadata string `json:"metadata"` // Upload-Metadata as sent by the client, returned by HEAD
+}
+
+// uploadLocks holds a *sync.Mutex per upload tmp dir so only one request writes an upload at a time
+var uploadLocks sync.Map
+
+// TusOptions tells tus clients which protocol version and extensions are supported
+func TusOptions(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", tusExtensions)
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload starts a resumable upload. Upload-Length is the file size; Upload-Metadata must carry the
+// destination as `path` (or just a `filename` for the storage root). The whole length counts against the
+// storage quota from now on, so an upload that does not fit is refused before any data is sent.
+func CreateUpload(c *gin.Context, db *gorm.DB) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		c.JSON(400, gin.H{"error": "Upload-Length must be a size in bytes"})
+		return
+	}
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Upload-Metadata", "details": err.Error()})
+		return
+	}
+	destination := metadata["path"]
+	if destination == "" {
+		destination = metadata["filename"]
+	}
+	destination = filepath.ToSlash(filepath.Clean("/" + destination))
+	if destination == "/" {
+		c.JSON(400, gin.H{"error": "Upload-Metadata must contain a path or filename"})
+		return
+	}
+	if !checkQuota(c, db, length) {
+		return
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	tmpDir, err := convertToTmpDataPath(uploadID, c)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create upload"})
+		return
+	}
+	info := uploadInfo{Length: length, Path: destination, Metadata: c.GetHeader("Upload-Metadata")}
+	if err := writeUploadInfo(tmpDir, info); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	// An empty file is complete right away
+	if length == 0 {
+		if err := finishUpload(c, db, tmpDir, info, 0); err != nil {
+			c.JSON(500, gin.H{"error": "Failed to save file"})
+			return
+		}
+	} else {
+		setUploadExpires(c, tmpDir)
+	}
+
+	c.Header("Location", strings.TrimSuffix(c.Request.URL.Path, "/")+"/"+uploadID)
+	c.Header("Upload-Offset", "0")
+	c.Status(http.StatusCreated)
+}
+
+// GetUploadOffset answers HEAD with how many bytes of the upload arrived, so the client knows where to resume
+func GetUploadOffset(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	tmpDir, info, ok := loadUpload(c)
+	if !ok {
+		return
+	}
+	_, offset, err := countChunks(tmpDir)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if info.Metadata != "" {
+		c.Header("Upload-Metadata", info.Metadata)
+	}
+	setUploadExpires(c, tmpDir)
+	c.Status(http.StatusOK)
+}
+
+// PatchUpload appends the request body at Upload-Offset. Bytes that arrived before a connection dropped are
+// kept, so the client resumes from the offset HEAD reports. The last PATCH moves the file into the storage.
+func PatchUpload(c *gin.Context, db *gorm.DB) {
+	if !checkTusResumable(c) {
+		return
+	}
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(415, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+
+	tmpDir, info, ok := loadUpload(c)
+	if !ok {
+		return
+	}
+	lock, _ := uploadLocks.LoadOrStore(tmpDir, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		c.JSON(423, gin.H{"error": "Upload is being written by another request"})
+		return
+	}
+	defer lock.(*sync.Mutex).Unlock()
+	// The request holding the lock before may have finished the upload
+	if _, err := os.Stat(filepath.Join(tmpDir, uploadInfoFile)); err != nil {
+		c.JSON(404, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	chunks, offset, err := countChunks(tmpDir)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Failed to read upload"})
+		return
+	}
+	if requested, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64); err != nil || requested != offset {
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		c.JSON(409, gin.H{"error": "Upload-Offset does not match the upload", "offset": offset})
+		return
+	}
+
+	chunkPath := filepath.Join(tmpDir, strconv.Itoa(chunks))
+	remaining := info.Length - offset
+	written, copyErr := writeChunk(chunkPath, io.LimitReader(c.Request.Body, remaining+1))
+	if written > remaining {
+		_ = remove(chunkPath)
+		c.JSON(413, gin.H{"error": "Body is longer than the rest of the upload", "offset": offset})
+		return
+	}
+	if written == 0 {
+		_ = remove(chunkPath)
+	} else {
+		chunks++
+	}
+	offset += written
+	c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+	// The expiry counts from the end of the last write, not from when its chunk was created
+	now := time.Now()
+	_ = os.Chtimes(tmpDir, now, now)
+
+	if copyErr != nil {
+		log.Println("[PatchUpload] write chunk error:", copyErr, "path:", chunkPath)
+		c.JSON(500, gin.H{"error": "Upload interrupted, resume from the offset", "offset": offset})
+		return
+	}
+
+	if offset == info.Length {
+		if err := finishUpload(c, db, tmpDir, info, chunks); err != nil {
+			log.Println("[PatchUpload] finish upload error:", err, "path:", info.Path)
+			c.JSON(500, gin.H{"error": "Failed to save file"})
+			return
+		}
+	} else {
+		setUploadExpires(c, tmpDir)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DeleteUpload cancels an upload and removes what arrived so far
+func DeleteUpload(c *gin.Context) {
+	if !checkTusResumable(c) {
+		return
+	}
+
+	tmpDir, _, ok := loadUpload(c)
+	if !ok {
+		return
+	}
+	lock, _ := uploadLocks.LoadOrStore(tmpDir, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		c.JSON(423, gin.H{"error": "Upload is being written by another request"})
+		return
+	}
+	defer lock.(*sync.Mutex).Unlock()
+	defer uploadLocks.Delete(tmpDir)
+
+	if err := rmdir(tmpDir); err != nil {
+		c.JSON(500, gin.H{"error": "Failed to delete upload"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RemoveIdleUpload removes an upload tmp dir for tasks.ClearTmpStorage together with its entry in uploadLocks.
+// It returns false without removing anything while a request holds the upload's lock, since a long PATCH does
+// not change the dir's modification time until it ends.
+func RemoveIdleUpload(tmpDir string) (bool, error) {
+	lock, _ := uploadLocks.LoadOrStore(tmpDir, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return false, nil
+	}
+	defer lock.(*sync.Mutex).Unlock()
+	defer uploadLocks.Delete(tmpDir)
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// checkTusResumable writes 412 and returns false when the client speaks another tus version
+func checkTusResumable(c *gin.Context) bool {
+	c.Header("Tus-Resumable", tusVersion)
+	if c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		uploadError(c, http.StatusPreconditionFailed, "Unsupported Tus-Resumable version")
+		return false
+	}
+	return true
+}
+
+// loadUpload reads the upload from the :upload_id path parameter, writing 404 when the current user has no such upload
+func loadUpload(c *gin.Context) (string, uploadInfo, bool) {
+	uploadID := c.Param("upload_id")
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		uploadError(c, http.StatusNotFound, "Upload not found")
+		return "", uploadInfo{}, false
+	}
+	tmpDir, err := convertToTmpDataPath(uploadID, c)
+	if err != nil {
+		uploadError(c, http.StatusInternalServerError, "Failed to read upload")
+		return "", uploadInfo{}, false
+	}
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, uploadInfoFile))
+	if errors.Is(err, os.ErrNotExist) {
+		// Never created, finished, deleted or expired
+		uploadError(c, http.StatusNotFound, "Upload not found")
+		return "", uploadInfo{}, false
+	}
+	var info uploadInfo
+	if err == nil {
+		err = json.Unmarshal(content, &info)
+	}
+	if err != nil {
+		uploadError(c, http.StatusInternalServerError, "Failed to read upload")
+		return "", uploadInfo{}, false
+	}
+	return tmpDir, info, true
+}
+
+// uploadError writes a JSON error, or just the status for HEAD requests which have no body
+func uploadError(c *gin.Context, code int, message string) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(code)
+		return
+	}
+	c.JSON(code, gin.H{"error": message})
+}
+
+func writeUploadInfo(tmpDir string, info uploadInfo) error {
+	if err := mkDirIfNotExists(tmpDir); err != nil {
+		return err
+	}
+	content, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(tmpDir, uploadInfoFile), content, 0o644)
+}
+
+// finishUpload merges the chunks of a complete upload into its destination and removes the upload
+func finishUpload(c *gin.Context, db *gorm.DB, tmpDir string, info uploadInfo, totalChunks int) error {
+	filePath, err := convertToStoragePath(info.Path, c)
+	if err != nil {
+		return err
+	}
+	if err := mkDirIfNotExists(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+	if _, _, err := mergeChunks(tmpDir, filePath, totalChunks, ""); err != nil {
+		return err
+	}
+	invalidateUsage(db, utils.GetUserID(c))
+	uploadLocks.Delete(tmpDir)
+	return rmdir(tmpDir)
+}
+
+// setUploadExpires tells the client until when the upload can be resumed
+func setUploadExpires(c *gin.Context, tmpDir string) {
+	if info, err := os.Stat(tmpDir); err == nil {
+		c.Header("Upload-Expires", info.ModTime().Add(TmpExpiration).UTC().Format(http.TimeFormat))
+	}
+}
+
+// parseUploadMetadata decodes Upload-Metadata: comma separated pairs of a key and a base64 value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("value of %s is not base64", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
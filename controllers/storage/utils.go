@@ -8,11 +8,17 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"personal_site/config"
 	"personal_site/controllers/utils"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// TmpExpiration 是暫存目錄（分塊上傳、可續傳上傳）多久未修改後會被 tasks.ClearTmpStorage 清除
+const TmpExpiration = time.Hour
+
 // mkDirIfNotExists 創建目錄（如果不存在）
 // 參數 dir 應該是相對於專案根目錄的路徑
 // 例如: "storage/uploads", "tmp/cache", "logs" 等
@@ -129,6 +135,32 @@ func writeMultipartFile(filePath string, file multipart.File) error {
 	return nil
 }
 
+// writeChunk 將 r 寫入新的分塊檔案，回傳寫入的位元組數；連線中斷時已寫入的部分會保留
+func writeChunk(chunkPath string, r io.Reader) (int64, error) {
+	out, err := os.Create(chunkPath)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	return io.Copy(out, r)
+}
+
+// countChunks 計算暫存目錄中連續的分塊 0..n-1 數量與總大小
+func countChunks(tmpDir string) (int, int64, error) {
+	var size int64
+	for i := 0; ; i++ {
+		info, err := os.Stat(filepath.Join(tmpDir, strconv.Itoa(i)))
+		if os.IsNotExist(err) {
+			return i, size, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		size += info.Size()
+	}
+}
+
 func convertToTmpDataPath(path string, c *gin.Context) (string, error) {
 	userID := utils.GetUserID(c)
 	tmpDataPath := filepath.Join("tmp", fmt.Sprintf("%d", userID), path)
@@ -151,8 +183,8 @@ func convertToTmpDataPath(path string, c *gin.Context) (string, error) {
 
 func convertToStoragePath(path string, c *gin.Context) (string, error) {
 	userID := utils.GetUserID(c)
-	userNickname := utils.GetUserNickname(c)
-	storagePath := filepath.Join("data", fmt.Sprintf("%d", userID), userNickname, path)
+	storageDir := utils.GetUserStorageDir(c)
+	storagePath := filepath.Join("data", fmt.Sprintf("%d", userID), storageDir, path)
 	storageRoot, err := GetStorageRoot()
 	if err != nil {
 		return "", err
@@ -160,7 +192,27 @@ func convertToStoragePath(path string, c *gin.Context) (string, error) {
 	return filepath.Join(storageRoot, storagePath), nil
 }
 
+// RemoveUserData 刪除使用者的整個儲存空間（data 與 tmp 目錄）
+func RemoveUserData(userID uint) error {
+	storageRoot, err := GetStorageRoot()
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{"data", "tmp"} {
+		if err := rmdir(filepath.Join(storageRoot, dir, fmt.Sprintf("%d", userID))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetStorageRoot 回傳儲存空間的根目錄：設定了 STORAGE_ROOT 時使用它，否則為專案根目錄下的 storage
+// STORAGE_ROOT 每次都重新讀取，測試會把它指向各自的暫存目錄
 func GetStorageRoot() (string, error) {
+	if root, ok := config.LookupVariable("STORAGE_ROOT"); ok {
+		return filepath.Abs(root)
+	}
+
 	// 獲取專案根目錄路徑
 	projectRoot, err := getProjectRoot()
 	if err != nil {
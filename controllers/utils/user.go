@@ -2,6 +2,7 @@ package utils
 
 import (
 	"errors"
+	"personal_site/models"
 	"personal_site/schemas"
 
 	"github.com/gin-gonic/gin"
@@ -26,7 +27,7 @@ func GetUserIDStrict(c *gin.Context) (uint, error) {
 func GetUserNickname(c *gin.Context) string {
 	userNickname, err := GetUserNicknameStrict(c)
 	if err != nil {
-		return "anonymous"
+		return schemas.AnonymousUser().Nickname
 	}
 	return userNickname
 }
@@ -39,6 +40,15 @@ func GetUserNicknameStrict(c *gin.Context) (string, error) {
 	return tu.Nickname, nil
 }
 
+// GetUserStorageDir returns the folder under data/<id> holding the current user's files
+func GetUserStorageDir(c *gin.Context) string {
+	tu, err := GetTokenUser(c)
+	if err != nil || tu.StorageDir == "" {
+		return schemas.AnonymousUser().StorageDir
+	}
+	return tu.StorageDir
+}
+
 // GetTokenUser returns the TokenUser stored in the context (set by auth middleware)
 func GetTokenUser(c *gin.Context) (schemas.TokenUser, error) {
 	if user, exists := c.Get("user"); exists {
@@ -49,12 +59,12 @@ func GetTokenUser(c *gin.Context) (schemas.TokenUser, error) {
 	return schemas.TokenUser{}, errors.New("user not authenticated")
 }
 
-// IsAdminUser returns true when the current user has admin role.
-func IsAdminUser(c *gin.Context) bool {
-	if user, exists := c.Get("user"); exists {
-		if userInfo, ok := user.(schemas.TokenUser); ok {
-			return userInfo.Role == "admin"
-		}
+// HasPermission returns true when the role of the current user grants p
+// and, for requests made with an API token, the token has p in its scopes.
+func HasPermission(c *gin.Context, p models.Permission) bool {
+	userInfo, err := GetTokenUser(c)
+	if err != nil {
+		return false
 	}
-	return false
+	return userInfo.HasPermission(p)
 }
@@ -20,9 +20,12 @@ func initMySQLDB(dsn string) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to MySQL database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.YTDataAPITokenHistory{}, &models.BattleCatLevel{}, &models.Reurl{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.YTDataAPITokenHistory{}, &models.BattleCatLevel{}, &models.Reurl{}, &models.Session{}, &models.RecoveryCode{}, &models.OneTimeToken{}, &models.UserIdentity{}, &models.APIToken{}, &models.WebAuthnCredential{}, &models.WebAuthnChallenge{}, &models.AuthEvent{}, &models.Invite{}, &models.GuestGrant{}, &models.MergeJob{}, &models.StorageUsage{}); err != nil {
 		return nil, fmt.Errorf("auto migrate failed: %v", err)
 	}
+	if err := backfillStorageDirs(db); err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
@@ -33,13 +36,27 @@ func initSQLiteDB(dsn string) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to SQLite database: %v", err)
 	}
 
-	if err := db.AutoMigrate(&models.User{}, &models.YTDataAPITokenHistory{}, &models.BattleCatLevel{}, &models.Reurl{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.YTDataAPITokenHistory{}, &models.BattleCatLevel{}, &models.Reurl{}, &models.Session{}, &models.RecoveryCode{}, &models.OneTimeToken{}, &models.UserIdentity{}, &models.APIToken{}, &models.WebAuthnCredential{}, &models.WebAuthnChallenge{}, &models.AuthEvent{}, &models.Invite{}, &models.GuestGrant{}, &models.MergeJob{}, &models.StorageUsage{}); err != nil {
 		return nil, fmt.Errorf("auto migrate failed: %v", err)
 	}
+	if err := backfillStorageDirs(db); err != nil {
+		return nil, err
+	}
 
 	return db, nil
 }
 
+// backfillStorageDirs gives users created before StorageDir existed the folder their files
+// already live in, which used to be named after the current nickname
+func backfillStorageDirs(db *gorm.DB) error {
+	err := db.Model(&models.User{}).Unscoped().Where("storage_dir = ''").
+		UpdateColumn("storage_dir", gorm.Expr("nickname")).Error
+	if err != nil {
+		return fmt.Errorf("backfill storage dirs failed: %v", err)
+	}
+	return nil
+}
+
 func InitDB() (*gorm.DB, error) {
 
 	dsn, err := config.GetVariableAsString("DATABASE_DSN")
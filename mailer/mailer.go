@@ -0,0 +1,115 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"personal_site/config"
+)
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+var defaultMailer Mailer
+
+// Default returns the mailer selected by MAIL_DRIVER ("smtp", or "file" which is the default)
+func Default() Mailer {
+	if defaultMailer != nil {
+		return defaultMailer
+	}
+
+	driver, _ := config.GetVariableAsString("MAIL_DRIVER")
+	switch driver {
+	case "smtp":
+		host, _ := config.GetVariableAsString("SMTP_HOST")
+		port, err := config.GetVariableAsString("SMTP_PORT")
+		if err != nil {
+			port = "587"
+		}
+		username, _ := config.GetVariableAsString("SMTP_USERNAME")
+		password, _ := config.GetVariableAsString("SMTP_PASSWORD")
+		from, _ := config.GetVariableAsString("MAIL_FROM")
+		defaultMailer = &SMTPMailer{Host: host, Port: port, Username: username, Password: password, From: from}
+	default:
+		path, _ := config.GetVariableAsString("MAIL_FILE_PATH")
+		defaultMailer = &FileMailer{Path: path}
+	}
+	return defaultMailer
+}
+
+// SetDefault replaces the mailer returned by Default, e.g. in tests
+func SetDefault(m Mailer) {
+	defaultMailer = m
+}
+
+// SMTPMailer sends emails through an SMTP server with PLAIN auth (STARTTLS is used when the server offers it)
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if m.Host == "" || m.From == "" {
+		return fmt.Errorf("SMTP mailer is not configured")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	msg := buildMessage(m.From, to, subject, body)
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, []byte(msg))
+}
+
+// FileMailer appends emails to a file instead of sending them, or writes them to the log when Path is empty.
+// Useful for development and tests.
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(to, subject, body string) error {
+	msg := buildMessage("noreply@localhost", to, subject, body)
+
+	if m.Path == "" {
+		log.Printf("[FileMailer] %s", msg)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(msg + "\r\n")
+	return err
+}
+
+func buildMessage(from, to, subject, body string) string {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	b.WriteString("\r\n")
+	return b.String()
+}
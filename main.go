@@ -53,10 +53,24 @@ func main() {
 			"Accept",
 			"X-Requested-With",
 			"Cache-Control",
+			// tus resumable uploads
+			"Tus-Resumable",
+			"Upload-Length",
+			"Upload-Metadata",
+			"Upload-Offset",
 		},
 		ExposeHeaders: []string{
 			"Content-Length",
 			"Content-Type",
+			// tus resumable uploads
+			"Location",
+			"Tus-Resumable",
+			"Tus-Version",
+			"Tus-Extension",
+			"Upload-Offset",
+			"Upload-Length",
+			"Upload-Metadata",
+			"Upload-Expires",
 		},
 		AllowCredentials: true,
 		MaxAge:           30 * 24 * time.Hour,
@@ -1,80 +1,145 @@
 package middlewares
 
 import (
+	"errors"
+	"log"
+	"strings"
+
 	authController "personal_site/controllers/auth"
+	"personal_site/models"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 
 	"personal_site/schemas"
 )
 
-func AuthRequired() gin.HandlerFunc {
+func AuthRequired(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token, err := c.Cookie("auth_token")
-		if err != nil || token == "" {
-			c.JSON(401, gin.H{"error": "Authorization cookie is required"})
-			c.Abort()
-			return
-		}
-
-		validToken, err := authController.ValidateToken(token)
-		if err != nil || !validToken.Valid {
-			c.JSON(401, gin.H{"error": "Invalid or expired token", "details": err.Error()})
+		token := credentials(c)
+		if token == "" {
+			c.JSON(401, gin.H{"error": "Authorization cookie or bearer token is required"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := validToken.Claims.(*schemas.TokenClaims)
-		if !ok {
-			c.JSON(401, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+		user, err := authenticate(c, db, token)
+		if err != nil {
+			rejectToken(c, db, err)
 			return
 		}
 
-		user := (&claims.Payload).ExtractUser()
-
 		c.Set("user", user)
 
 		c.Next()
 	}
 }
 
-func AuthOptional() gin.HandlerFunc {
+func AuthOptional(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		token, err := c.Cookie("auth_token")
-
-		if err != nil || token == "" {
-			// No authentication, continue without setting user
-			anonymousUser := schemas.TokenUser{
-				ID:       0,
-				Nickname: "anonymous",
-				Role:     "anonymous",
-			}
+		token := credentials(c)
 
-			c.Set("user", anonymousUser)
+		if token == "" {
+			// No authentication, continue as the anonymous user
+			c.Set("user", schemas.AnonymousUser())
 			c.Next()
 			return
 		}
 
-		validToken, err := authController.ValidateToken(token)
-		if err != nil || !validToken.Valid {
-			// Invalid token, continue without setting user
-			c.JSON(401, gin.H{"error": "Invalid or expired token", "details": err.Error()})
-			c.Abort()
-			return
-		}
-
-		claims, ok := validToken.Claims.(*schemas.TokenClaims)
-		if !ok {
-			// Invalid claims, continue without setting user
-			c.JSON(401, gin.H{"error": "Invalid token claims"})
-			c.Abort()
+		user, err := authenticate(c, db, token)
+		if err != nil {
+			rejectToken(c, db, err)
 			return
 		}
 
-		user := (&claims.Payload).ExtractUser()
 		c.Set("user", user)
 
 		c.Next()
 	}
 }
+
+// rejectToken aborts with 403 for disabled or expired accounts and 401 for any other token problem. Rejections go to the
+// audit log, except expired access tokens which clients run into routinely before they refresh.
+func rejectToken(c *gin.Context, db *gorm.DB, err error) {
+	if !errors.Is(err, jwt.ErrTokenExpired) {
+		authController.RecordAuthEvent(c, db, models.AuthEvent{Type: models.AuthEventTokenRejected, Details: err.Error()})
+	}
+	if errors.Is(err, authController.ErrAccountDisabled) {
+		c.JSON(403, gin.H{"error": "Account disabled"})
+	} else if errors.Is(err, authController.ErrAccountExpired) {
+		c.JSON(403, gin.H{"error": "Account expired"})
+	} else {
+		c.JSON(401, gin.H{"error": "Invalid or expired token", "details": err.Error()})
+	}
+	c.Abort()
+}
+
+// credentials returns the token sent as `Authorization: Bearer <token>`, or else the auth_token cookie
+func credentials(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	token, err := c.Cookie("auth_token")
+	if err != nil {
+		return ""
+	}
+	return token
+}
+
+// authenticateAPIToken resolves a personal access token to its user, limited to the token's scopes
+func authenticateAPIToken(db *gorm.DB, token string) (schemas.TokenUser, error) {
+	apiToken, dbUser, err := authController.ValidateAPIToken(db, token)
+	if err != nil {
+		return schemas.TokenUser{}, err
+	}
+
+	return schemas.TokenUser{
+		ID:         dbUser.ID,
+		Role:       dbUser.Role,
+		Nickname:   dbUser.Nickname,
+		StorageDir: dbUser.StorageDir,
+		APITokenID: apiToken.ID,
+		Scopes:     apiToken.ScopeList(),
+	}, nil
+}
+
+// authenticate validates the access token, checks its jti against the session table and returns the
+// current user. Role and nickname come from the database; tokens carrying stale values are re-issued.
+func authenticate(c *gin.Context, db *gorm.DB, token string) (schemas.TokenUser, error) {
+	if strings.HasPrefix(token, authController.APITokenPrefix) {
+		return authenticateAPIToken(db, token)
+	}
+
+	validToken, err := authController.ValidateToken(token)
+	if err != nil {
+		return schemas.TokenUser{}, err
+	}
+
+	claims, ok := validToken.Claims.(*schemas.TokenClaims)
+	if !ok {
+		return schemas.TokenUser{}, errors.New("invalid token claims")
+	}
+
+	dbUser, err := authController.ValidateSession(db, claims.ID)
+	if err != nil {
+		return schemas.TokenUser{}, err
+	}
+
+	if claims.Payload.TokenVersion != dbUser.TokenVersion {
+		// The request is served with the fresh values either way
+		if err := authController.ReissueAccessToken(c, dbUser, claims.ID); err != nil {
+			log.Println("[authenticate] reissue token error:", err)
+		}
+	}
+
+	return schemas.TokenUser{
+		ID:         dbUser.ID,
+		Role:       dbUser.Role,
+		Nickname:   dbUser.Nickname,
+		StorageDir: dbUser.StorageDir,
+		SessionID:  claims.ID,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"personal_site/controllers/utils"
+	"personal_site/models"
+)
+
+// RequireRole only lets users with one of roles through. It must run after AuthRequired or AuthOptional.
+func RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := utils.GetTokenUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(403, gin.H{"error": "Permission denied"})
+	}
+}
+
+// RequirePermission only lets users whose role grants all of perms through.
+// It must run after AuthRequired or AuthOptional.
+func RequirePermission(perms ...models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, err := utils.GetTokenUser(c); err != nil {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		for _, perm := range perms {
+			if !utils.HasPermission(c, perm) {
+				c.AbortWithStatusJSON(403, gin.H{"error": "Permission denied", "details": "missing permission " + string(perm)})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects requests made with a personal API token, for account endpoints that need a
+// real login (managing tokens, sessions, password ...). It must run after AuthRequired.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := utils.GetTokenUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if user.APITokenID != 0 {
+			c.AbortWithStatusJSON(403, gin.H{"error": "API tokens can not be used here, please login"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIToken is a personal access token a user created for scripts, sent as `Authorization: Bearer <token>`.
+// TokenHash: SHA-256 of the token; the token itself is only shown once when it is created
+// Prefix: first characters of the token so users can tell their tokens apart
+// Scopes: space separated permissions; the token can only use those its user's role also grants
+// ExpiresAt: optional expiry, nil means the token works until revoked
+// RevokedAt: set when the user deletes the token
+type APIToken struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"size:64;not null"`
+	TokenHash  string `gorm:"size:64;not null;uniqueIndex"`
+	Prefix     string `gorm:"size:16;not null"`
+	Scopes     string `gorm:"size:512;not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time `gorm:"index"`
+}
+
+// IsActive reports whether the token can still be used at the given time.
+func (t *APIToken) IsActive(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// ScopeList splits Scopes into permission names
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// AuthEventType names what happened in an AuthEvent
+type AuthEventType string
+
+const (
+	AuthEventLogin           AuthEventType = "login"        // a session was started, Details is the login method
+	AuthEventLoginFailed     AuthEventType = "login_failed" // Details is the reason
+	AuthEventLogout          AuthEventType = "logout"
+	AuthEventPasswordChanged AuthEventType = "password_changed" // by the user, or through a reset link (Details "reset")
+	AuthEventAccountCreated  AuthEventType = "account_created"  // Details is the provider the account signed up with
+	AuthEventTokenRejected   AuthEventType = "token_rejected"   // the auth middleware refused a token, Details is why
+)
+
+// AuthEvent is one entry of the authentication audit log.
+// UserID: the account concerned, 0 when it is not known (unknown email, unreadable token) or was deleted
+// Email: the email a failed login tried or of the deleted account, empty otherwise
+// Success: false for failed attempts, e.g. a wrong old password on ChangePassword
+// IP / UserAgent: the client that made the request
+type AuthEvent struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint          `gorm:"not null;index"`
+	Type       AuthEventType `gorm:"size:32;not null;index"`
+	Success    bool          `gorm:"not null"`
+	Email      string        `gorm:"size:128"`
+	IP         string        `gorm:"size:64"`
+	UserAgent  string        `gorm:"size:512"`
+	Details    string        `gorm:"size:256"`
+}
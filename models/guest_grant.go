@@ -0,0 +1,28 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Resources a GuestGrant can share
+const (
+	GrantStorage = "storage" // a folder of the owner's storage
+	GrantReurl   = "reurl"   // one redirect mapping
+)
+
+// GuestGrant gives a user, usually a guest, read-only access to a storage folder or a reurl of another user.
+// UserID: user the grant is for, 0 while it waits on an unused invite
+// InviteID: invite whose account receives the grant when it signs up, 0 for grants made to an existing user
+// Resource: GrantStorage or GrantReurl
+// OwnerID: user whose folder or mapping is shared
+// Path: shared folder relative to the owner's storage, "/" for all of it (storage grants)
+// ReurlID: shared mapping (reurl grants)
+type GuestGrant struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint   `gorm:"not null;default:0;index"`
+	InviteID   uint   `gorm:"not null;default:0;index"`
+	Resource   string `gorm:"size:16;not null"`
+	OwnerID    uint   `gorm:"not null;index"`
+	Path       string `gorm:"size:1024"`
+	ReurlID    uint   `gorm:"not null;default:0"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invite is a single-use code an admin hands out so someone can sign up while registration is
+// invite-only or limited to some email domains, optionally with another role than user.
+// CodeHash: SHA-256 of the code; the code itself is only shown to the admin who created it
+// Prefix: first characters of the code so admins can tell their invites apart
+// Email: when set, only an account with this email can use the invite
+// Role: role of the account created with the invite
+// CreatedBy: admin who created the invite
+// ExpiresAt: when the invite stops working
+// AccountExpiresAt: when the account created with the invite stops working, nil for no limit
+// UsedAt / UsedBy: set when an account was created with the invite
+// The GuestGrant rows with the invite's ID are handed to the account created with it.
+type Invite struct {
+	gorm.Model       `gorm:"embedded"`
+	CodeHash         string    `gorm:"size:64;not null;uniqueIndex"`
+	Prefix           string    `gorm:"size:16;not null"`
+	Email            string    `gorm:"size:128"`
+	Role             Role      `gorm:"size:32;not null"`
+	CreatedBy        uint      `gorm:"not null;index"`
+	ExpiresAt        time.Time `gorm:"not null"`
+	AccountExpiresAt *time.Time
+	UsedAt           *time.Time
+	UsedBy           uint `gorm:"not null;default:0"`
+}
+
+// IsUsable reports whether the invite can still create an account at the given time.
+func (i *Invite) IsUsable(now time.Time) bool {
+	return i.UsedAt == nil && now.Before(i.ExpiresAt)
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MergeJobStatus is the state of a MergeJob
+type MergeJobStatus string
+
+const (
+	MergeJobMerging   MergeJobStatus = "merging"
+	MergeJobCompleted MergeJobStatus = "completed"
+	MergeJobFailed    MergeJobStatus = "failed" // Error says why
+)
+
+// MergeJob tracks merging the chunks of a chunked upload into the uploaded file.
+// UserID / FileID: owner (0 for anonymous) and client-chosen file_id of the upload
+// Path: destination in the user's storage
+// TotalChunks: number of chunks merged
+// ExpectedSHA256: hex SHA-256 the client sent to check the merged file against, empty when none
+// SHA256 / Size: hex SHA-256 and size of the merged file
+// FinishedAt: when the merge completed or failed
+type MergeJob struct {
+	gorm.Model     `gorm:"embedded"`
+	UserID         uint           `gorm:"not null;index:idx_merge_job_file"`
+	FileID         string         `gorm:"size:128;not null;index:idx_merge_job_file"`
+	Path           string         `gorm:"size:1024;not null"`
+	TotalChunks    int            `gorm:"not null"`
+	Status         MergeJobStatus `gorm:"size:16;not null"`
+	Error          string         `gorm:"size:512"`
+	ExpectedSHA256 string         `gorm:"size:64"`
+	SHA256         string         `gorm:"size:64"`
+	Size           int64          `gorm:"not null;default:0"`
+	FinishedAt     *time.Time
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OneTimeToken records a signed token (password reset, email verification, ...) so it can only be used once.
+// TokenID is the jti of the signed token; the token itself is never stored.
+type OneTimeToken struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint      `gorm:"not null;index"`
+	Purpose    string    `gorm:"size:32;not null;index"`
+	TokenID    string    `gorm:"size:64;not null;uniqueIndex"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	UsedAt     *time.Time
+}
@@ -0,0 +1,60 @@
+package models
+
+// RoleAnonymous is the role of requests without a logged-in user. It is never stored on a user.
+const RoleAnonymous Role = "anonymous"
+
+// Permission names one kind of action. Routes require permissions, roles grant them.
+type Permission string
+
+const (
+	PermStorageRead  Permission = "storage:read"
+	PermStorageWrite Permission = "storage:write"
+
+	PermReurlRead      Permission = "reurl:read"
+	PermReurlWrite     Permission = "reurl:write"
+	PermReurlManageAll Permission = "reurl:manage_all" // read and change mappings of other users
+
+	PermUsersManage Permission = "users:manage"
+)
+
+// IsValid reports whether p is a known permission
+func (p Permission) IsValid() bool {
+	switch p {
+	case PermStorageRead, PermStorageWrite, PermReurlRead, PermReurlWrite, PermReurlManageAll, PermUsersManage:
+		return true
+	default:
+		return false
+	}
+}
+
+// rolePermissions is the permission table: what each role is allowed to do
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermStorageRead, PermStorageWrite,
+		PermReurlRead, PermReurlWrite, PermReurlManageAll,
+		PermUsersManage,
+	},
+	RoleUser: {
+		PermStorageRead, PermStorageWrite,
+		PermReurlRead, PermReurlWrite,
+	},
+	// Guests only read folders and mappings shared with them through a GuestGrant
+	RoleGuest: {
+		PermStorageRead,
+		PermReurlRead,
+	},
+	// Users who are not logged in share one storage
+	RoleAnonymous: {
+		PermStorageRead, PermStorageWrite,
+	},
+}
+
+// HasPermission reports whether the permission table grants p to role r
+func (r Role) HasPermission(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
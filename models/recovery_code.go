@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the authenticator is lost.
+// Only the SHA-256 of the code is stored.
+type RecoveryCode struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint   `gorm:"not null;index"`
+	CodeHash   string `gorm:"size:64;not null;index"`
+	UsedAt     *time.Time
+}
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is a server-side login session.
+// TokenID: jti carried by every access token issued for this session
+// RefreshTokenHash: SHA-256 of the current refresh token, rotated on every refresh
+// UserAgent / IP: client that logged in, shown when listing sessions
+// LastSeenAt: last time an access token of this session was used (updated at most once a minute)
+// ExpiresAt: when the refresh token stops being accepted
+// RevokedAt: set on logout; a revoked session rejects its access tokens immediately
+type Session struct {
+	gorm.Model       `gorm:"embedded"`
+	UserID           uint   `gorm:"not null;index"`
+	TokenID          string `gorm:"size:64;not null;uniqueIndex"`
+	RefreshTokenHash string `gorm:"size:64;not null;uniqueIndex"`
+	UserAgent        string `gorm:"size:512"`
+	IP               string `gorm:"size:64"`
+	LastSeenAt       *time.Time
+	ExpiresAt        time.Time  `gorm:"not null"`
+	RevokedAt        *time.Time `gorm:"index"`
+}
+
+// IsActive reports whether the session can still be used at the given time.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// StorageUsage caches how many bytes a user stores under data/<id>, so quota checks do not walk the tree on
+// every upload.
+// UserID: owner of the storage (0 for anonymous users)
+// Used: total size of the files in bytes
+// Stale: set when files were added or removed; the next quota check walks the tree again
+// ComputedAt: when Used was computed
+type StorageUsage struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint  `gorm:"not null;uniqueIndex"`
+	Used       int64 `gorm:"not null;default:0"`
+	Stale      bool  `gorm:"not null;default:false"`
+	ComputedAt time.Time
+}
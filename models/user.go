@@ -2,6 +2,9 @@ package models
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -30,14 +33,23 @@ const (
 	AuthProviderGitHub   AuthProvider = "github"
 	AuthProviderGoogle   AuthProvider = "google"
 	AuthProviderLine     AuthProvider = "line"
+	AuthProviderPasskey  AuthProvider = "passkey" // signed up with a passkey, Identifier is the WebAuthn user handle
+
+	// AuthProviderOIDCPrefix prefixes the name of a configured OpenID Connect provider, e.g. "oidc:keycloak"
+	AuthProviderOIDCPrefix = "oidc:"
 )
 
+// OIDCAuthProvider returns the provider value stored for users of the configured OIDC provider name
+func OIDCAuthProvider(name string) AuthProvider {
+	return AuthProvider(AuthProviderOIDCPrefix + name)
+}
+
 func (a AuthProvider) IsValid() bool {
 	switch a {
-	case AuthProviderPassword, AuthProviderGitHub, AuthProviderGoogle, AuthProviderLine:
+	case AuthProviderPassword, AuthProviderGitHub, AuthProviderGoogle, AuthProviderLine, AuthProviderPasskey:
 		return true
 	default:
-		return false
+		return strings.HasPrefix(string(a), AuthProviderOIDCPrefix) && len(a) > len(AuthProviderOIDCPrefix)
 	}
 }
 
@@ -45,9 +57,58 @@ type User struct {
 	gorm.Model `gorm:"embedded"` // ID, CreatedAt, UpdatedAt
 	Nickname   string            `gorm:"size:64;not null"`
 	Role       Role              `gorm:"size:32;not null"`
-	Provider   AuthProvider      `gorm:"size:16;not null;index:,unique,composite:uni_provider_email"`
+	Provider   AuthProvider      `gorm:"size:64;not null;index:,unique,composite:uni_provider_email"`
 	Email      string            `gorm:"size:128;not null;index:,unique,composite:uni_provider_email"`
-	Identifier string            `gorm:"size:256;not null;index"` // hashed password, or provider id
+	Identifier string            `gorm:"size:256;not null;index" json:"-"` // hashed password, or provider id
+
+	EmailVerified bool `gorm:"not null;default:false"`
+	// EmailVerificationRequired is set on accounts let in by their email domain, which can not log in until EmailVerified
+	EmailVerificationRequired bool `gorm:"not null;default:false"`
+	Disabled                  bool `gorm:"not null;default:false"` // set by an admin; disabled users can not log in or use existing sessions
+	// ExpiresAt limits how long the account works (always set for guests); afterwards it is treated like a disabled one
+	ExpiresAt *time.Time
+
+	// TOTP two-factor authentication (password accounts only)
+	TOTPSecret       string `gorm:"size:64" json:"-"`            // base32 secret, set when enrollment starts
+	TOTPEnabled      bool   `gorm:"not null;default:false"`      // true once the first code was verified
+	TOTPLastUsedStep int64  `gorm:"not null;default:0" json:"-"` // last accepted time step, prevents code replay
+
+	// Login lockout: failed password / second-factor attempts since the last success
+	FailedLoginCount int        `gorm:"not null;default:0" json:"-"`
+	LockedUntil      *time.Time // logins are refused until this time
+
+	// TokenVersion is bumped whenever a value baked into access tokens (role, nickname) changes,
+	// so the auth middleware can spot stale tokens and re-issue them
+	TokenVersion uint `gorm:"not null;default:0" json:"-"`
+	// StorageDir is the folder under data/<id> holding the user's files. It is fixed when the user
+	// is created so renaming the user does not move their storage.
+	StorageDir string `gorm:"size:64;not null;default:''" json:"-"`
+	// StorageQuota overrides the storage quota of the user's role in bytes, -1 for unlimited; nil uses the role's
+	StorageQuota *int64
+}
+
+// IsExpired reports whether the account stopped working at the given time
+func (u *User) IsExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
+	if u.StorageDir == "" {
+		u.StorageDir = DefaultStorageDir(u.Nickname)
+	}
+	return nil
+}
+
+// DefaultStorageDir turns a nickname into a single safe path element for StorageDir
+func DefaultStorageDir(nickname string) string {
+	name := filepath.Base(filepath.Clean("/" + nickname))
+	if name == "/" || name == "." {
+		return "files"
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
 }
 
 func (u *User) BeforeSave(tx *gorm.DB) (err error) {
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// UserIdentity links an external login (GitHub, Google, LINE, OIDC ...) to a user.
+// One user may have several identities; (Provider, Subject) belongs to exactly one user.
+// Subject: the provider's stable user id (GitHub id, OIDC sub, LINE userId)
+// Email: email reported by the provider when the identity was linked
+type UserIdentity struct {
+	gorm.Model `gorm:"embedded"`
+	UserID     uint         `gorm:"not null;index"`
+	Provider   AuthProvider `gorm:"size:64;not null;index:,unique,composite:uni_provider_subject"`
+	Subject    string       `gorm:"size:256;not null;index:,unique,composite:uni_provider_subject"`
+	Email      string       `gorm:"size:128"`
+}
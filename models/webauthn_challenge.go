@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthn ceremonies a challenge was issued for
+const (
+	WebAuthnCeremonyRegister = "register"
+	WebAuthnCeremonyLogin    = "login"
+)
+
+// WebAuthnChallenge is a pending passkey ceremony. It is deleted when the ceremony finishes, so every
+// challenge can only be answered once.
+// UserID: user adding a passkey, 0 for logins and passkey sign-ups
+// Email, Nickname: account to create when a passkey sign-up finishes
+// UserHandle: base64url WebAuthn user.id sent with the registration options
+// InviteID: invite a passkey sign-up was allowed with, redeemed when it finishes
+type WebAuthnChallenge struct {
+	gorm.Model `gorm:"embedded"`
+	Challenge  string    `gorm:"size:64;not null;uniqueIndex"`
+	Ceremony   string    `gorm:"size:16;not null"`
+	UserID     uint      `gorm:"not null;default:0"`
+	Email      string    `gorm:"size:128"`
+	Nickname   string    `gorm:"size:64"`
+	UserHandle string    `gorm:"size:128"`
+	InviteID   uint      `gorm:"not null;default:0"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+}
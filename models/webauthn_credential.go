@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthnCredential is a passkey registered by a user.
+// CredentialID: base64url credential id chosen by the authenticator
+// PublicKey: COSE_Key of the credential
+// UserHandle: base64url WebAuthn user.id, the same for all credentials of a user
+// SignCount: last signature counter seen, used to spot cloned authenticators
+type WebAuthnCredential struct {
+	gorm.Model   `gorm:"embedded"`
+	UserID       uint   `gorm:"not null;index"`
+	Name         string `gorm:"size:64;not null"`
+	CredentialID string `gorm:"size:1400;not null;uniqueIndex"`
+	PublicKey    []byte `gorm:"not null" json:"-"`
+	Algorithm    int64  `gorm:"not null"`
+	UserHandle   string `gorm:"size:128;not null;index" json:"-"`
+	SignCount    uint32 `gorm:"not null;default:0" json:"-"`
+	LastUsedAt   *time.Time
+}
@@ -0,0 +1,173 @@
+// Package passwordhash hashes and verifies passwords. New hashes use Argon2id (or bcrypt when configured);
+// hashes of either algorithm are verified, and Verify reports when a stored hash should be replaced
+// because it was made with another algorithm or weaker parameters.
+package passwordhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"personal_site/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms new hashes can be made with
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+// Argon2Params are the Argon2id cost parameters. The defaults follow the OWASP recommendation.
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params are used unless PASSWORD_ARGON2_* is set
+var DefaultArgon2Params = Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// Hasher makes new hashes with Algorithm and the parameters of that algorithm
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+}
+
+var defaultHasher *Hasher
+
+// Default returns the hasher configured by PASSWORD_HASH_ALGORITHM ("argon2id", the default, or "bcrypt"),
+// PASSWORD_ARGON2_MEMORY (KiB), PASSWORD_ARGON2_ITERATIONS, PASSWORD_ARGON2_PARALLELISM and PASSWORD_BCRYPT_COST
+func Default() *Hasher {
+	if defaultHasher != nil {
+		return defaultHasher
+	}
+
+	h := &Hasher{Algorithm: Argon2id, Argon2: DefaultArgon2Params, BcryptCost: bcrypt.DefaultCost}
+	if algorithm, err := config.GetVariableAsString("PASSWORD_HASH_ALGORITHM"); err == nil && algorithm == Bcrypt {
+		h.Algorithm = Bcrypt
+	}
+	if n, ok := configUint("PASSWORD_ARGON2_MEMORY", 32); ok && n >= 8*uint64(h.Argon2.Parallelism) {
+		h.Argon2.Memory = uint32(n)
+	}
+	if n, ok := configUint("PASSWORD_ARGON2_ITERATIONS", 32); ok && n > 0 {
+		h.Argon2.Iterations = uint32(n)
+	}
+	if n, ok := configUint("PASSWORD_ARGON2_PARALLELISM", 8); ok && n > 0 && h.Argon2.Memory >= 8*uint32(n) {
+		h.Argon2.Parallelism = uint8(n)
+	}
+	if n, ok := configUint("PASSWORD_BCRYPT_COST", 8); ok && int(n) >= bcrypt.MinCost && int(n) <= bcrypt.MaxCost {
+		h.BcryptCost = int(n)
+	}
+
+	defaultHasher = h
+	return h
+}
+
+// SetDefault replaces the hasher returned by Default, e.g. in tests
+func SetDefault(h *Hasher) {
+	defaultHasher = h
+}
+
+func configUint(varName string, bits int) (uint64, bool) {
+	raw, err := config.GetVariableAsString(varName)
+	if err != nil {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(raw, 10, bits)
+	return n, err == nil
+}
+
+// MaxPasswordBytes is the longest password the algorithm uses in full; bcrypt ignores everything after 72 bytes
+func (h *Hasher) MaxPasswordBytes() int {
+	if h.Algorithm == Bcrypt {
+		return 72
+	}
+	return 256
+}
+
+// Hash returns the encoded hash of password: a PHC string for Argon2id, the usual $2a$ form for bcrypt
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.Algorithm == Bcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	p := h.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches encoded and, if it does, whether encoded should be
+// replaced by a new Hash because its algorithm or parameters differ from h
+func (h *Hasher) Verify(password, encoded string) (match bool, needsRehash bool) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		p, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, false
+		}
+		computed := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false
+		}
+		return true, h.Algorithm != Argon2id || p != h.Argon2
+	case strings.HasPrefix(encoded, "$2"):
+		if bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return true, h.Algorithm != Bcrypt || err != nil || cost != h.BcryptCost
+	default:
+		return false, false
+	}
+}
+
+// decodeArgon2id parses "$argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>"
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return Argon2Params{}, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id parameters: %v", err)
+	}
+	if p.Iterations == 0 || p.Parallelism == 0 || p.Memory < 8*uint32(p.Parallelism) {
+		return Argon2Params{}, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id salt: %v", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, errors.New("invalid argon2id key")
+	}
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,77 @@
+package passwordhash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap keeps the tests fast; only the parameters matter, not their strength
+var cheap = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2id(t *testing.T) {
+	h := &Hasher{Algorithm: Argon2id, Argon2: cheap, BcryptCost: bcrypt.MinCost}
+
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$"), hash)
+
+	other, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "Every hash has its own salt")
+
+	match, rehash := h.Verify("correct horse", hash)
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	match, _ = h.Verify("wrong horse", hash)
+	assert.False(t, match)
+
+	stronger := &Hasher{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}
+	match, rehash = stronger.Verify("correct horse", hash)
+	assert.True(t, match, "Hashes keep their own parameters")
+	assert.True(t, rehash, "Other parameters ask for a rehash")
+
+	for _, broken := range []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHRzYWx0$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+		"plain text",
+		"",
+	} {
+		match, _ := h.Verify("correct horse", broken)
+		assert.False(t, match, broken)
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	argon := &Hasher{Algorithm: Argon2id, Argon2: cheap, BcryptCost: bcrypt.MinCost}
+	match, rehash := argon.Verify("correct horse", string(legacy))
+	assert.True(t, match, "bcrypt hashes are still verified")
+	assert.True(t, rehash, "and upgraded to Argon2id")
+
+	h := &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+	match, rehash = h.Verify("correct horse", string(legacy))
+	assert.True(t, match)
+	assert.False(t, rehash)
+
+	h.BcryptCost = bcrypt.MinCost + 1
+	_, rehash = h.Verify("correct horse", string(legacy))
+	assert.True(t, rehash, "A higher cost asks for a rehash")
+
+	hash, err := h.Hash("correct horse")
+	require.NoError(t, err)
+	cost, err := bcrypt.Cost([]byte(hash))
+	require.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+
+	assert.Equal(t, 72, h.MaxPasswordBytes())
+	assert.Greater(t, argon.MaxPasswordBytes(), 72)
+}
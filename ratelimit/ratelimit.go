@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled evenly over Per (e.g. 10 per minute)
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseLimit parses "<burst>/<duration>", e.g. "10/1m" or "100/1h"
+func ParseLimit(raw string) (Limit, error) {
+	burstPart, perPart, found := strings.Cut(strings.TrimSpace(raw), "/")
+	if !found {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <burst>/<duration>", raw)
+	}
+	burst, err := strconv.Atoi(burstPart)
+	if err != nil || burst <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit burst %q", burstPart)
+	}
+	per, err := time.ParseDuration(perPart)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit duration %q", perPart)
+	}
+	return Limit{Burst: burst, Per: per}, nil
+}
+
+// Store keeps one token bucket per key.
+// MemoryStore is the default; a DB-backed store can implement Store to share limits between instances.
+type Store interface {
+	// Take removes one token from the bucket of key. When the bucket is empty it returns false
+	// and how long until the next token is available.
+	Take(key string, limit Limit, now time.Time) (bool, time.Duration, error)
+	// Reset refills the bucket of key, e.g. when an admin unlocks an account
+	Reset(key string) error
+}
+
+var defaultStore Store
+
+// Default returns the process-wide store, an in-memory store unless SetDefault was called
+func Default() Store {
+	if defaultStore == nil {
+		defaultStore = NewMemoryStore()
+	}
+	return defaultStore
+}
+
+// SetDefault replaces the store returned by Default, e.g. with a DB-backed store or a fresh store in tests
+func SetDefault(s Store) {
+	defaultStore = s
+}
+
+// MemoryStore is an in-memory Store. Buckets that have been full for a while are dropped.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	per     time.Duration
+}
+
+// sweepInterval is how often idle buckets are removed from a MemoryStore
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	if limit.Burst <= 0 || limit.Per <= 0 {
+		return true, 0, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	rate := float64(limit.Burst) / limit.Per.Seconds() // tokens per second
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*rate)
+	}
+	b.updated = now
+	b.per = limit.Per
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return false, wait, nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.buckets, key)
+	return nil
+}
+
+// sweep drops buckets untouched for a full period, they would be full again anyway
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.per {
+			delete(s.buckets, key)
+		}
+	}
+}
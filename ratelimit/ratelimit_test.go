@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Burst: 10, Per: time.Minute}, limit)
+
+	for _, raw := range []string{"", "10", "0/1m", "-1/1m", "10/0s", "ten/1m", "10/forever"} {
+		_, err := ParseLimit(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	limit := Limit{Burst: 3, Per: 3 * time.Second} // one token per second
+	now := time.Now()
+
+	t.Run("Burst then reject", func(t *testing.T) {
+		s := NewMemoryStore()
+		for i := 0; i < 3; i++ {
+			ok, _, err := s.Take("k", limit, now)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		}
+
+		ok, wait, err := s.Take("k", limit, now)
+		require.NoError(t, err)
+		assert.False(t, ok)
+		assert.Equal(t, time.Second, wait)
+	})
+
+	t.Run("Refill over time", func(t *testing.T) {
+		s := NewMemoryStore()
+		for i := 0; i < 3; i++ {
+			s.Take("k", limit, now)
+		}
+
+		ok, _, _ := s.Take("k", limit, now.Add(500*time.Millisecond))
+		assert.False(t, ok, "Half a token is not enough")
+		ok, _, _ = s.Take("k", limit, now.Add(1500*time.Millisecond))
+		assert.True(t, ok)
+	})
+
+	t.Run("Keys are independent", func(t *testing.T) {
+		s := NewMemoryStore()
+		for i := 0; i < 3; i++ {
+			s.Take("a", limit, now)
+		}
+
+		ok, _, _ := s.Take("b", limit, now)
+		assert.True(t, ok)
+	})
+
+	t.Run("Reset refills", func(t *testing.T) {
+		s := NewMemoryStore()
+		for i := 0; i < 3; i++ {
+			s.Take("k", limit, now)
+		}
+		require.NoError(t, s.Reset("k"))
+
+		ok, _, _ := s.Take("k", limit, now)
+		assert.True(t, ok)
+	})
+}
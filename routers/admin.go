@@ -0,0 +1,70 @@
+package routers
+
+import (
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	adminController "personal_site/controllers/admin"
+	authController "personal_site/controllers/auth"
+	"personal_site/middlewares"
+	"personal_site/models"
+)
+
+// adminRouter registers admin-only endpoints under the API prefix + `/admin`.
+type adminRouter struct{}
+
+func (adminRouter) RegisterRoutes(r *gin.RouterGroup, db *gorm.DB) {
+	r.Use(middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermUsersManage))
+
+	// User management
+	r.GET("/users", func(c *gin.Context) {
+		adminController.ListUsers(c, db)
+	})
+	r.GET("/users/:id", func(c *gin.Context) {
+		adminController.GetUser(c, db)
+	})
+	r.PATCH("/users/:id", func(c *gin.Context) {
+		adminController.UpdateUser(c, db)
+	})
+	r.DELETE("/users/:id", func(c *gin.Context) {
+		adminController.DeleteUser(c, db)
+	})
+	r.POST("/users/:id/logout", func(c *gin.Context) {
+		adminController.ForceLogout(c, db)
+	})
+
+	// Guest accounts and what is shared with them
+	r.POST("/guests", func(c *gin.Context) {
+		authController.CreateGuest(c, db)
+	})
+	r.GET("/users/:id/grants", func(c *gin.Context) {
+		authController.ListGrants(c, db)
+	})
+	r.POST("/users/:id/grants", func(c *gin.Context) {
+		authController.AddGrant(c, db)
+	})
+	r.DELETE("/users/:id/grants/:grant_id", func(c *gin.Context) {
+		authController.DeleteGrant(c, db)
+	})
+
+	// Invite codes for sign-ups
+	r.GET("/invites", func(c *gin.Context) {
+		authController.ListInvites(c, db)
+	})
+	r.POST("/invites", func(c *gin.Context) {
+		authController.CreateInvite(c, db)
+	})
+	r.DELETE("/invites/:id", func(c *gin.Context) {
+		authController.DeleteInvite(c, db)
+	})
+
+	// Authentication audit log
+	r.GET("/auth-events", func(c *gin.Context) {
+		adminController.ListAuthEvents(c, db)
+	})
+
+	// Lift a login lockout
+	r.POST("/users/:id/unlock", func(c *gin.Context) {
+		authController.UnlockUser(c, db)
+	})
+}
@@ -20,11 +20,119 @@ func (a authRouter) RegisterRoutes(r *gin.RouterGroup, db *gorm.DB) {
 		authController.Login(c, db)
 	})
 
+	r.POST("/login/mfa", func(c *gin.Context) {
+		authController.LoginMFA(c, db)
+	})
+
 	r.POST("/logout", func(c *gin.Context) {
-		authController.Logout(c)
+		authController.Logout(c, db)
+	})
+
+	r.POST("/refresh", func(c *gin.Context) {
+		authController.Refresh(c, db)
+	})
+
+	// Password reset and email verification
+	r.POST("/forgot-password", func(c *gin.Context) {
+		authController.ForgotPassword(c, db)
+	})
+	r.POST("/reset-password", func(c *gin.Context) {
+		authController.ResetPassword(c, db)
+	})
+	r.POST("/verify-email", func(c *gin.Context) {
+		authController.VerifyEmail(c, db)
+	})
+	r.POST("/resend-verification", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.ResendVerification(c, db)
+	})
+
+	// Sessions
+	r.GET("/sessions", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.ListSessions(c, db)
+	})
+	r.DELETE("/sessions", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.RevokeAllSessions(c, db)
+	})
+	r.DELETE("/sessions/:id", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.RevokeSession(c, db)
+	})
+
+	// TOTP two-factor authentication
+	r.POST("/mfa/totp/setup", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.SetupTOTP(c, db)
+	})
+	r.POST("/mfa/totp/enable", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.EnableTOTP(c, db)
+	})
+	r.POST("/mfa/totp/disable", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.DisableTOTP(c, db)
+	})
+
+	// Linked login providers
+	r.GET("/identities", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.ListIdentities(c, db)
+	})
+	r.GET("/identities/link/:provider", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.LinkIdentityStart(c, db)
+	})
+	r.DELETE("/identities/:id", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.UnlinkIdentity(c, db)
+	})
+
+	// Personal API tokens
+	r.GET("/tokens", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.ListAPITokens(c, db)
+	})
+	r.POST("/tokens", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.CreateAPIToken(c, db)
+	})
+	r.DELETE("/tokens/:id", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.RevokeAPIToken(c, db)
+	})
+
+	// Passkeys (WebAuthn)
+	r.POST("/webauthn/signup/begin", func(c *gin.Context) {
+		authController.PasskeySignupBegin(c, db)
+	})
+	r.POST("/webauthn/signup/finish", func(c *gin.Context) {
+		authController.PasskeySignupFinish(c, db)
+	})
+	r.POST("/webauthn/login/begin", func(c *gin.Context) {
+		authController.PasskeyLoginBegin(c, db)
+	})
+	r.POST("/webauthn/login/finish", func(c *gin.Context) {
+		authController.PasskeyLoginFinish(c, db)
+	})
+	r.POST("/webauthn/register/begin", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.PasskeyRegisterBegin(c, db)
+	})
+	r.POST("/webauthn/register/finish", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.PasskeyRegisterFinish(c, db)
+	})
+	r.GET("/webauthn/credentials", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.ListPasskeys(c, db)
+	})
+	r.DELETE("/webauthn/credentials/:id", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.DeletePasskey(c, db)
+	})
+
+	// Profile of the logged-in user
+	r.GET("/me", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.GetMe(c, db)
+	})
+	r.PATCH("/me", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.UpdateMe(c, db)
+	})
+	r.DELETE("/me", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.DeleteMe(c, db)
 	})
 
-	r.POST("/change-password", middlewares.AuthRequired(), func(c *gin.Context) {
+	// Recent security activity of the logged-in user
+	r.GET("/activity", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
+		authController.ListSecurityActivity(c, db)
+	})
+
+	r.POST("/change-password", middlewares.AuthRequired(db), middlewares.RequireSession(), func(c *gin.Context) {
 		authController.ChangePassword(c, db)
 	})
 
@@ -43,4 +151,23 @@ func (a authRouter) RegisterRoutes(r *gin.RouterGroup, db *gorm.DB) {
 	r.GET(apipaths.GoogleCallbackRel, func(c *gin.Context) {
 		authController.GoogleLoginCallback(c, db)
 	})
+
+	// LINE Login
+	r.GET(apipaths.LineLoginRel, func(c *gin.Context) {
+		authController.LineLoginStart(c)
+	})
+	r.GET(apipaths.LineCallbackRel, func(c *gin.Context) {
+		authController.LineLoginCallback(c, db)
+	})
+
+	// Generic OpenID Connect providers listed in OIDC_PROVIDERS
+	for _, name := range authController.OIDCProviderNames() {
+		name := name
+		r.GET(apipaths.OIDCLoginRel(name), func(c *gin.Context) {
+			authController.OIDCLoginStart(c, name)
+		})
+		r.GET(apipaths.OIDCCallbackRel(name), func(c *gin.Context) {
+			authController.OIDCLoginCallback(c, db, name)
+		})
+	}
 }
@@ -6,6 +6,7 @@ import (
 
     reurlController "personal_site/controllers/reurl"
     "personal_site/middlewares"
+    "personal_site/models"
 )
 
 // reurlRouter registers RESTful endpoints to manage redirect mappings.
@@ -14,33 +15,33 @@ type reurlRouter struct{}
 
 func (reurlRouter) RegisterRoutes(r *gin.RouterGroup, db *gorm.DB) {
     // List all mappings
-    r.GET("/", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.GET("/", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlRead), func(c *gin.Context) {
         reurlController.ListReurls(c, db)
     })
-    r.GET("", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.GET("", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlRead), func(c *gin.Context) {
         reurlController.ListReurls(c, db)
     })
 
     // Create a new mapping (protected)
-    r.POST("/", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.POST("/", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlWrite), func(c *gin.Context) {
         reurlController.CreateReurl(c, db)
     })
-    r.POST("", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.POST("", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlWrite), func(c *gin.Context) {
         reurlController.CreateReurl(c, db)
     })
 
     // Get a mapping by ID
-    r.GET("/:id", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.GET("/:id", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlRead), func(c *gin.Context) {
         reurlController.GetReurl(c, db)
     })
 
     // Patch a mapping by ID (protected)
-    r.PATCH("/:id", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.PATCH("/:id", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlWrite), func(c *gin.Context) {
         reurlController.PatchReurl(c, db)
     })
 
     // Delete a mapping by ID (protected)
-    r.DELETE("/:id", middlewares.AuthRequired(), func(c *gin.Context) {
+    r.DELETE("/:id", middlewares.AuthRequired(db), middlewares.RequirePermission(models.PermReurlWrite), func(c *gin.Context) {
         reurlController.DeleteReurl(c, db)
     })
 
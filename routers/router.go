@@ -3,6 +3,7 @@ package routers
 import (
 	"personal_site/config"
 	"personal_site/controllers"
+	authController "personal_site/controllers/auth"
 	"personal_site/middlewares"
 
 	"github.com/gin-gonic/gin"
@@ -29,7 +30,15 @@ func RegisterRouters(r *gin.Engine, db *gorm.DB) {
 	var reurlRouterVal Router = reurlRouter{}
 	reurlRouterVal.RegisterRoutes(mainRouter.Group("/reurl"), db)
 
-	mainRouter.GET("/get-yt-data-api-token", middlewares.AuthOptional(), func(c *gin.Context) {
+	var adminRouterVal Router = adminRouter{}
+	adminRouterVal.RegisterRoutes(mainRouter.Group("/admin"), db)
+
+	// Public keys for verifying access tokens
+	mainRouter.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		authController.JWKS(c)
+	})
+
+	mainRouter.GET("/get-yt-data-api-token", middlewares.AuthOptional(db), func(c *gin.Context) {
 		controllers.GetYTDataAPIToken(c, db)
 	})
 }
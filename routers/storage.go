@@ -6,38 +6,74 @@ import (
 
 	storageController "personal_site/controllers/storage"
 	"personal_site/middlewares"
+	"personal_site/models"
 )
 
 type storageRouter struct{}
 
 func (s storageRouter) RegisterRoutes(r *gin.RouterGroup, db *gorm.DB) {
-	r.Use(middlewares.AuthOptional())
+	r.Use(middlewares.AuthOptional(db))
 
 	// folder
-	r.POST("/folder/*folder_path", func(c *gin.Context) {
+	r.POST("/folder/*folder_path", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
 		storageController.CreateFolder(c)
 	})
-	r.GET("/folder/*folder_path", func(c *gin.Context) {
+	r.GET("/folder/*folder_path", middlewares.RequirePermission(models.PermStorageRead), func(c *gin.Context) {
 		storageController.ListFolder(c)
 	})
-	r.PATCH("/folder/*folder_path", func(c *gin.Context) {
+	r.PATCH("/folder/*folder_path", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
 		storageController.UpdateFolder(c)
 	})
-	r.DELETE("/folder/*folder_path", func(c *gin.Context) {
-		storageController.DeleteFolder(c)
+	r.DELETE("/folder/*folder_path", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.DeleteFolder(c, db)
 	})
 
 	// file
-	r.GET("/file/*file_path", func(c *gin.Context) {
+	r.GET("/file/*file_path", middlewares.RequirePermission(models.PermStorageRead), func(c *gin.Context) {
 		storageController.GetFile(c)
 	})
-	r.POST("/file/*file_path", func(c *gin.Context) {
-		storageController.UploadFile(c)
+	r.POST("/file/*file_path", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.UploadFile(c, db)
 	})
-	r.PATCH("/file/*file_path", func(c *gin.Context) {
+	r.GET("/merge-jobs/:file_id", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.GetMergeJob(c, db)
+	})
+	r.PATCH("/file/*file_path", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
 		storageController.UpdateFile(c)
 	})
-	r.DELETE("/file/*file_path", func(c *gin.Context) {
-		storageController.DeleteFile(c)
+	r.DELETE("/file/*file_path", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.DeleteFile(c, db)
+	})
+
+	r.GET("/usage", middlewares.RequirePermission(models.PermStorageRead), func(c *gin.Context) {
+		storageController.GetUsage(c, db)
+	})
+
+	// resumable uploads (tus protocol)
+	r.OPTIONS("/uploads", func(c *gin.Context) {
+		storageController.TusOptions(c)
+	})
+	r.POST("/uploads", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.CreateUpload(c, db)
+	})
+	r.HEAD("/uploads/:upload_id", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.GetUploadOffset(c)
+	})
+	r.PATCH("/uploads/:upload_id", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.PatchUpload(c, db)
+	})
+	r.DELETE("/uploads/:upload_id", middlewares.RequirePermission(models.PermStorageWrite), func(c *gin.Context) {
+		storageController.DeleteUpload(c)
+	})
+
+	// folders other users shared with the current user, read-only
+	r.GET("/shared", middlewares.RequirePermission(models.PermStorageRead), func(c *gin.Context) {
+		storageController.ListShared(c, db)
+	})
+	r.GET("/shared/:grant_id/folder/*folder_path", middlewares.RequirePermission(models.PermStorageRead), func(c *gin.Context) {
+		storageController.ListSharedFolder(c, db)
+	})
+	r.GET("/shared/:grant_id/file/*file_path", middlewares.RequirePermission(models.PermStorageRead), func(c *gin.Context) {
+		storageController.GetSharedFile(c, db)
 	})
 }
@@ -1,8 +1,11 @@
 package schemas
 
 import (
+	"log"
 	"personal_site/config"
+	"personal_site/models"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,15 +18,67 @@ type TokenClaims struct {
 }
 
 type TokenPayload struct {
-	UserID   uint   `json:"user_id"`
-	Role     string `json:"role"`
-	Nickname string `json:"nickname"`
+	UserID       uint   `json:"user_id"`
+	Role         string `json:"role"`
+	Nickname     string `json:"nickname"`
+	TokenVersion uint   `json:"token_version"` // models.User.TokenVersion when the token was issued
 }
 
+// PurposeClaims are carried by short-lived tokens that are not access tokens,
+// e.g. the "mfa pending" token returned by login. Purpose keeps one kind of token
+// from being accepted where another is expected.
+type PurposeClaims struct {
+	jwt.RegisteredClaims
+	Purpose string `json:"purpose"`
+}
+
+// TokenUser is the user a request is made by. Requests without credentials get AnonymousUser, so
+// handlers reason about one role model: anonymous, guest, user or admin, see models.Role.
 type TokenUser struct {
-	ID       uint
-	Role     string
-	Nickname string
+	ID         uint
+	Role       models.Role
+	Nickname   string
+	StorageDir string   // folder under data/<id> holding the user's files
+	SessionID  string   // jti of the access token, empty for anonymous users and API tokens
+	APITokenID uint     // personal access token the request was made with, 0 for cookie sessions
+	Scopes     []string // permissions an API token is limited to, nil for cookie sessions
+}
+
+// AnonymousUser is the TokenUser of requests without credentials. Anonymous users share one storage folder.
+func AnonymousUser() TokenUser {
+	return TokenUser{
+		ID:         0,
+		Role:       models.RoleAnonymous,
+		Nickname:   "anonymous",
+		StorageDir: "anonymous",
+	}
+}
+
+// IsAnonymous reports whether the request was made without credentials
+func (u TokenUser) IsAnonymous() bool {
+	return u.Role == models.RoleAnonymous
+}
+
+// IsGuest reports whether the user is a guest, who may only read what was shared with them
+func (u TokenUser) IsGuest() bool {
+	return u.Role == models.RoleGuest
+}
+
+// HasPermission reports whether the role grants p and, for requests made with an API token,
+// the token has p in its scopes.
+func (u TokenUser) HasPermission(p models.Permission) bool {
+	if !u.Role.HasPermission(p) {
+		return false
+	}
+	if u.Scopes == nil {
+		return true
+	}
+	for _, scope := range u.Scopes {
+		if scope == string(p) {
+			return true
+		}
+	}
+	return false
 }
 
 func NewTokenClaims[T interface{ ~string | ~uint }](sub T) *TokenClaims {
@@ -36,10 +91,7 @@ func NewTokenClaims[T interface{ ~string | ~uint }](sub T) *TokenClaims {
 	}
 
 	now := time.Now()
-	exp, err := config.GetVariableAsTimeDuration("DEFAULT_TOKEN_EXPIRATION")
-	if err != nil {
-		exp = 12 * time.Hour // Default to 12 hours if not set
-	}
+	exp := AccessTokenExpiration()
 	return &TokenClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Issuer:    "https://後端.夢.台灣",
@@ -53,10 +105,39 @@ func NewTokenClaims[T interface{ ~string | ~uint }](sub T) *TokenClaims {
 	}
 }
 
+var deprecatedExpirationOnce sync.Once
+
+// AccessTokenExpiration returns how long an access token (and its cookie) stays valid.
+// Access tokens are short-lived; sessions are kept alive with refresh tokens.
+// DEFAULT_TOKEN_EXPIRATION, the token lifetime before refresh tokens, is still read when
+// ACCESS_TOKEN_EXPIRATION is not set, so existing deployments keep their lifetime.
+func AccessTokenExpiration() time.Duration {
+	exp, err := config.GetVariableAsTimeDuration("ACCESS_TOKEN_EXPIRATION")
+	if err == nil {
+		return exp
+	}
+	if exp, err := config.GetVariableAsTimeDuration("DEFAULT_TOKEN_EXPIRATION"); err == nil {
+		deprecatedExpirationOnce.Do(func() {
+			log.Println("[Config] DEFAULT_TOKEN_EXPIRATION is deprecated, set ACCESS_TOKEN_EXPIRATION and REFRESH_TOKEN_EXPIRATION instead")
+		})
+		return exp
+	}
+	return 15 * time.Minute // Default to 15 minutes if not set
+}
+
+// RefreshTokenExpiration returns how long a session can be refreshed without logging in again.
+func RefreshTokenExpiration() time.Duration {
+	exp, err := config.GetVariableAsTimeDuration("REFRESH_TOKEN_EXPIRATION")
+	if err != nil {
+		return 30 * 24 * time.Hour // Default to 30 days if not set
+	}
+	return exp
+}
+
 func (t *TokenPayload) ExtractUser() TokenUser {
 	return TokenUser{
 		ID:       t.UserID,
-		Role:     t.Role,
+		Role:     models.Role(t.Role),
 		Nickname: t.Nickname,
 	}
 }
@@ -9,7 +9,7 @@ import (
 	"personal_site/controllers/storage"
 )
 
-// ClearTmpStorage 每小時自動清理 storageRoot/tmp 下超過一小時未修改的資料夾
+// ClearTmpStorage 每小時自動清理 storageRoot/tmp 下超過 storage.TmpExpiration 未修改的上傳資料夾
 func ClearTmpStorage() {
 	go func() {
 		for {
@@ -32,42 +32,45 @@ func ClearTmpStorage() {
 					continue
 				}
 				folderPath := filepath.Join(tmpDir, entry.Name())
-				// 先處理第一層資料夾
-				info, err := os.Stat(folderPath)
-				if err != nil {
-					log.Println("[ClearTmpStorage] stat error:", err, folderPath)
-					continue
-				}
-				if now.Sub(info.ModTime()) > time.Hour {
-					if err := os.RemoveAll(folderPath); err != nil {
-						log.Println("[ClearTmpStorage] remove error:", err, folderPath)
-					} else {
-						log.Println("[ClearTmpStorage] removed:", folderPath)
-					}
-					continue
-				}
-				// 再處理第二層
+				// 先處理第二層（每個上傳一個資料夾）；使用者資料夾的修改時間不會隨上傳寫入而更新，
+				// 因此不能依它刪除整個使用者資料夾，否則會刪掉仍在續傳的上傳
 				subEntries, err := os.ReadDir(folderPath)
 				if err != nil {
 					log.Println("[ClearTmpStorage] read subdir error:", err, folderPath)
 					continue
 				}
+				remaining := 0
 				for _, subEntry := range subEntries {
 					if !subEntry.IsDir() {
+						remaining++
 						continue
 					}
 					subFolderPath := filepath.Join(folderPath, subEntry.Name())
 					subInfo, err := os.Stat(subFolderPath)
 					if err != nil {
 						log.Println("[ClearTmpStorage] stat error:", err, subFolderPath)
+						remaining++
 						continue
 					}
-					if now.Sub(subInfo.ModTime()) > time.Hour {
-						if err := os.RemoveAll(subFolderPath); err != nil {
-							log.Println("[ClearTmpStorage] remove error:", err, subFolderPath)
-						} else {
-							log.Println("[ClearTmpStorage] removed:", subFolderPath)
-						}
+					if now.Sub(subInfo.ModTime()) <= storage.TmpExpiration {
+						remaining++
+						continue
+					}
+					// 正在寫入的上傳會持有鎖，略過以免在寫入途中刪除
+					removed, err := storage.RemoveIdleUpload(subFolderPath)
+					if err != nil {
+						log.Println("[ClearTmpStorage] remove error:", err, subFolderPath)
+						remaining++
+					} else if !removed {
+						remaining++
+					} else {
+						log.Println("[ClearTmpStorage] removed:", subFolderPath)
+					}
+				}
+				// 再刪除已清空的使用者資料夾
+				if remaining == 0 {
+					if err := os.Remove(folderPath); err != nil {
+						log.Println("[ClearTmpStorage] remove error:", err, folderPath)
 					}
 				}
 			}
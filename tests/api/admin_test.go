@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminUsers(t *testing.T) {
+	t.Run("Non-admins are rejected", func(t *testing.T) {
+		setup(t)
+		_, token := createUser(t, "alice", models.RoleUser)
+
+		assert.Equal(t, 403, request(http.MethodGet, "/admin/users", token, "").Code)
+	})
+
+	t.Run("List and search with pagination", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		for i := 0; i < 5; i++ {
+			createUser(t, fmt.Sprintf("member%d", i), models.RoleUser)
+		}
+
+		w := request(http.MethodGet, "/admin/users?q=member&page=2&page_size=2", adminToken, "")
+		require.Equal(t, 200, w.Code)
+
+		var data struct {
+			Data  []map[string]any `json:"data"`
+			Total int              `json:"total"`
+			Page  int              `json:"page"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		assert.Equal(t, 5, data.Total)
+		assert.Equal(t, 2, data.Page)
+		require.Len(t, data.Data, 2)
+		assert.Equal(t, "member2", data.Data[0]["nickname"])
+		assert.NotContains(t, data.Data[0], "identifier", "Password hash must not be exposed")
+
+		w = request(http.MethodGet, "/admin/users?role=admin", adminToken, "")
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		assert.Equal(t, 1, data.Total)
+	})
+
+	t.Run("Change role and nickname", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, _ := createUser(t, "bob", models.RoleUser)
+
+		w := request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", user.ID), adminToken, `{"role": "admin", "nickname": "Bobby"}`)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var response struct {
+			Data struct {
+				Role     models.Role `json:"role"`
+				Nickname string      `json:"nickname"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, models.RoleAdmin, response.Data.Role, "The response shows the updated user")
+		assert.Equal(t, "Bobby", response.Data.Nickname)
+
+		var updated models.User
+		db.First(&updated, user.ID)
+		assert.Equal(t, models.RoleAdmin, updated.Role)
+		assert.Equal(t, "Bobby", updated.Nickname)
+
+		w = request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", user.ID), adminToken, `{"role": "superuser"}`)
+		assert.Equal(t, 400, w.Code)
+	})
+
+	t.Run("Disabled users are rejected", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, userToken := createUser(t, "carol", models.RoleUser)
+
+		w := request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", user.ID), adminToken, `{"disabled": true}`)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		// Existing sessions are revoked
+		assert.Equal(t, 401, request(http.MethodGet, "/auth/sessions", userToken, "").Code)
+
+		// A session started anyway (e.g. before disabling finished) is rejected by the middleware
+		var disabled models.User
+		db.First(&disabled, user.ID)
+		newToken, _, err := authController.StartSession(db, disabled, "", "")
+		require.NoError(t, err)
+		w = request(http.MethodGet, "/auth/sessions", newToken, "")
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), "Account disabled")
+	})
+
+	t.Run("Soft delete", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, userToken := createUser(t, "dave", models.RoleUser)
+
+		require.Equal(t, 200, request(http.MethodDelete, fmt.Sprintf("/admin/users/%d", user.ID), adminToken, "").Code)
+		assert.Equal(t, 401, request(http.MethodGet, "/auth/sessions", userToken, "").Code)
+
+		var count int64
+		db.Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(0), count, "User should be hidden")
+		db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(1), count, "Row should be kept")
+
+		w := request(http.MethodGet, fmt.Sprintf("/admin/users/%d", user.ID), adminToken, "")
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"deleted_at":"`)
+	})
+
+	t.Run("Force logout", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, userToken := createUser(t, "erin", models.RoleUser)
+
+		w := request(http.MethodPost, fmt.Sprintf("/admin/users/%d/logout", user.ID), adminToken, "")
+		require.Equal(t, 200, w.Code)
+		assert.Equal(t, 401, request(http.MethodGet, "/auth/sessions", userToken, "").Code)
+	})
+
+	t.Run("Admins can not lock themselves out", func(t *testing.T) {
+		setup(t)
+		admin, adminToken := createUser(t, "admin", models.RoleAdmin)
+
+		assert.Equal(t, 400, request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", admin.ID), adminToken, `{"role": "user"}`).Code)
+		assert.Equal(t, 400, request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", admin.ID), adminToken, `{"disabled": true}`).Code)
+		assert.Equal(t, 400, request(http.MethodDelete, fmt.Sprintf("/admin/users/%d", admin.ID), adminToken, "").Code)
+	})
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPITokens(t *testing.T) {
+	withCookie := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	withBearer := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	createToken := func(t *testing.T, cookie, body string) (string, uint) {
+		w := withCookie(http.MethodPost, "/auth/tokens", cookie, body)
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		var data struct {
+			Token string `json:"token"`
+			Data  struct {
+				ID     uint     `json:"id"`
+				Prefix string   `json:"prefix"`
+				Scopes []string `json:"scopes"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		require.True(t, strings.HasPrefix(data.Token, authController.APITokenPrefix))
+		assert.True(t, strings.HasPrefix(data.Token, data.Data.Prefix))
+		return data.Token, data.Data.ID
+	}
+
+	t.Run("Create, use and revoke a token", func(t *testing.T) {
+		setup(t)
+		user, cookie := createUser(t, "alice", models.RoleUser)
+		token, id := createToken(t, cookie, `{"name": "cron", "scopes": ["reurl:read", "reurl:write"]}`)
+
+		var stored models.APIToken
+		require.NoError(t, db.First(&stored, id).Error)
+		assert.NotContains(t, stored.TokenHash, token, "Only the hash should be stored")
+		assert.Equal(t, user.ID, stored.UserID)
+
+		w := withBearer(http.MethodPost, "/reurl", token, `{"key": "from-script", "target_url": "https://example.com"}`)
+		require.Equal(t, 201, w.Code, w.Body.String())
+		assert.Equal(t, 200, withBearer(http.MethodGet, "/reurl", token, "").Code)
+
+		w = withCookie(http.MethodGet, "/auth/tokens", cookie, "")
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"name":"cron"`)
+		assert.NotContains(t, w.Body.String(), token)
+
+		require.Equal(t, 200, withCookie(http.MethodDelete, fmt.Sprintf("/auth/tokens/%d", id), cookie, "").Code)
+		assert.Equal(t, 401, withBearer(http.MethodGet, "/reurl", token, "").Code)
+		assert.Equal(t, 404, withCookie(http.MethodDelete, fmt.Sprintf("/auth/tokens/%d", id), cookie, "").Code)
+	})
+
+	t.Run("Tokens are limited to their scopes", func(t *testing.T) {
+		setup(t)
+		_, cookie := createUser(t, "alice", models.RoleUser)
+		token, _ := createToken(t, cookie, `{"name": "read only", "scopes": ["reurl:read"]}`)
+
+		assert.Equal(t, 200, withBearer(http.MethodGet, "/reurl", token, "").Code)
+		w := withBearer(http.MethodPost, "/reurl", token, `{"target_url": "https://example.com"}`)
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), "reurl:write")
+	})
+
+	t.Run("Tokens can not manage the account", func(t *testing.T) {
+		setup(t)
+		_, cookie := createUser(t, "alice", models.RoleUser)
+		token, _ := createToken(t, cookie, `{"name": "script", "scopes": ["storage:read"]}`)
+
+		assert.Equal(t, 403, withBearer(http.MethodPost, "/auth/tokens", token, `{"name": "more", "scopes": ["storage:read"]}`).Code)
+		assert.Equal(t, 403, withBearer(http.MethodGet, "/auth/me", token, "").Code)
+		assert.Equal(t, 403, withBearer(http.MethodDelete, "/auth/sessions", token, "").Code)
+	})
+
+	t.Run("Scopes must be granted by the role", func(t *testing.T) {
+		setup(t)
+		_, cookie := createUser(t, "alice", models.RoleUser)
+
+		assert.Equal(t, 403, withCookie(http.MethodPost, "/auth/tokens", cookie, `{"name": "x", "scopes": ["users:manage"]}`).Code)
+		assert.Equal(t, 400, withCookie(http.MethodPost, "/auth/tokens", cookie, `{"name": "x", "scopes": ["everything"]}`).Code)
+		assert.Equal(t, 400, withCookie(http.MethodPost, "/auth/tokens", cookie, `{"name": "x", "scopes": []}`).Code)
+	})
+
+	t.Run("Role changes apply to existing tokens", func(t *testing.T) {
+		setup(t)
+		user, cookie := createUser(t, "alice", models.RoleUser)
+		token, _ := createToken(t, cookie, `{"name": "script", "scopes": ["reurl:write"]}`)
+
+		require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("role", models.RoleGuest).Error)
+		assert.Equal(t, 403, withBearer(http.MethodPost, "/reurl", token, `{"target_url": "https://example.com"}`).Code)
+	})
+
+	t.Run("Expired tokens and disabled users are rejected", func(t *testing.T) {
+		setup(t)
+		user, cookie := createUser(t, "alice", models.RoleUser)
+
+		expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		token, id := createToken(t, cookie, fmt.Sprintf(`{"name": "short", "scopes": ["reurl:read"], "expires_at": %q}`, expiresAt))
+		assert.Equal(t, 200, withBearer(http.MethodGet, "/reurl", token, "").Code)
+
+		require.NoError(t, db.Model(&models.APIToken{}).Where("id = ?", id).UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error)
+		assert.Equal(t, 401, withBearer(http.MethodGet, "/reurl", token, "").Code)
+
+		token, _ = createToken(t, cookie, `{"name": "other", "scopes": ["reurl:read"]}`)
+		require.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).UpdateColumn("disabled", true).Error)
+		assert.Equal(t, 403, withBearer(http.MethodGet, "/reurl", token, "").Code)
+	})
+
+	t.Run("Access tokens work as bearer tokens", func(t *testing.T) {
+		setup(t)
+		_, cookie := createUser(t, "alice", models.RoleUser)
+
+		assert.Equal(t, 200, withBearer(http.MethodGet, "/auth/me", cookie, "").Code)
+		assert.Equal(t, 401, withBearer(http.MethodGet, "/auth/me", "pst_unknown", "").Code)
+	})
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthEvents(t *testing.T) {
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("User-Agent", "audit-test")
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	type event struct {
+		UserID    uint   `json:"user_id"`
+		Type      string `json:"type"`
+		Success   bool   `json:"success"`
+		Email     string `json:"email"`
+		UserAgent string `json:"user_agent"`
+		Details   string `json:"details"`
+	}
+	list := func(t *testing.T, path, token string) []event {
+		w := request(http.MethodGet, path, token, "")
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var body struct {
+			Data []event `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body.Data
+	}
+
+	t.Run("Logins, password changes and logout are recorded", func(t *testing.T) {
+		setup(t)
+		hash, _ := bcrypt.GenerateFromPassword([]byte("blue-kettle-42"), bcrypt.MinCost)
+		user := models.User{Nickname: "frank", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "frank@example.com", Identifier: string(hash)}
+		require.NoError(t, db.Create(&user).Error)
+
+		assert.Equal(t, 401, request(http.MethodPost, "/auth/login", "", `{"email": "nobody@example.com", "password": "x"}`).Code)
+		assert.Equal(t, 401, request(http.MethodPost, "/auth/login", "", `{"email": "frank@example.com", "password": "wrong"}`).Code)
+		w := request(http.MethodPost, "/auth/login", "", `{"email": "frank@example.com", "password": "blue-kettle-42"}`)
+		require.Equal(t, 200, w.Code)
+		var token string
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "auth_token" {
+				token = cookie.Value
+			}
+		}
+
+		assert.Equal(t, 403, request(http.MethodPost, "/auth/change-password", token, `{"old_password": "wrong", "new_password": "green-teapot-7"}`).Code)
+		assert.Equal(t, 200, request(http.MethodPost, "/auth/change-password", token, `{"old_password": "blue-kettle-42", "new_password": "green-teapot-7"}`).Code)
+
+		events := list(t, "/auth/activity", token)
+		require.Len(t, events, 4, "The unknown email is not part of frank's activity")
+		assert.Equal(t, event{Type: "password_changed", Success: true, UserAgent: "audit-test"}, events[0])
+		assert.Equal(t, event{Type: "password_changed", Success: false, UserAgent: "audit-test", Details: "invalid_old_password"}, events[1])
+		assert.Equal(t, event{Type: "login", Success: true, UserAgent: "audit-test", Details: "password"}, events[2])
+		assert.Equal(t, event{Type: "login_failed", Success: false, UserAgent: "audit-test", Details: "invalid_password"}, events[3])
+
+		assert.Equal(t, 200, request(http.MethodPost, "/auth/logout", token, "").Code)
+		var logout models.AuthEvent
+		require.NoError(t, db.Last(&logout).Error)
+		assert.Equal(t, models.AuthEventLogout, logout.Type)
+		assert.Equal(t, user.ID, logout.UserID)
+
+		var unknown models.AuthEvent
+		require.NoError(t, db.Where("type = ? AND user_id = 0", models.AuthEventLoginFailed).First(&unknown).Error)
+		assert.Equal(t, "nobody@example.com", unknown.Email)
+		assert.Equal(t, "unknown_email", unknown.Details)
+	})
+
+	t.Run("Admins list and filter all events", func(t *testing.T) {
+		setup(t)
+		admin := models.User{Nickname: "admin", Role: models.RoleAdmin, Provider: models.AuthProviderPassword, Email: "admin@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&admin).Error)
+		adminToken, _, err := authController.StartSession(db, admin, "", "")
+		require.NoError(t, err)
+
+		assert.Equal(t, 200, request(http.MethodPost, "/auth/register", "", `{"email": "grace@example.com", "nickname": "grace", "password": "blue-kettle-42"}`).Code)
+
+		// A forged token is rejected by the middleware and recorded
+		assert.Equal(t, 401, request(http.MethodGet, "/auth/me", "not-a-jwt", "").Code)
+
+		events := list(t, "/admin/auth-events?type=account_created", adminToken)
+		require.Len(t, events, 1)
+		assert.Equal(t, "password", events[0].Details)
+		assert.NotZero(t, events[0].UserID)
+
+		events = list(t, "/admin/auth-events?type=token_rejected&success=false", adminToken)
+		require.Len(t, events, 1)
+		assert.Zero(t, events[0].UserID)
+		assert.Contains(t, events[0].Details, "failed to parse token")
+
+		assert.Equal(t, 400, request(http.MethodGet, "/admin/auth-events?user_id=abc", adminToken, "").Code)
+
+		user := models.User{Nickname: "henry", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "henry@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&user).Error)
+		userToken, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, 403, request(http.MethodGet, "/admin/auth-events", userToken, "").Code)
+	})
+}
@@ -6,11 +6,12 @@ import (
 	"net/http/httptest"
 	authController "personal_site/controllers/auth"
 	"personal_site/models"
-	"personal_site/schemas"
+	"personal_site/passwordhash"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,7 +22,7 @@ func TestAuth(t *testing.T) {
 		req_reg, _ := http.NewRequest(http.MethodPost, "/auth/register",
 			strings.NewReader(`{
 				"email":"test-register@example.com", 
-				"password":"password123",
+				"password":"blue-kettle-42",
 				"nickname":"testuser"
 			}`))
 
@@ -36,8 +37,8 @@ func TestAuth(t *testing.T) {
 		assert.Equal(t, "testuser", user.Nickname, "User nickname should match")
 		assert.Equal(t, models.RoleUser, user.Role, "User role should be 'user'")
 		assert.Equal(t, models.AuthProviderPassword, user.Provider, "User provider should be 'password'")
-		err2 := bcrypt.CompareHashAndPassword([]byte(user.Identifier), []byte("password123"))
-		assert.NoError(t, err2, "User password should match")
+		match, _ := passwordhash.Default().Verify("blue-kettle-42", user.Identifier)
+		assert.True(t, match, "User password should match")
 	})
 
 	t.Run("Login", func(t *testing.T) {
@@ -84,23 +85,54 @@ func TestAuth(t *testing.T) {
 		assert.NotEmpty(t, authCookie.Value, "Token should not be empty")
 	})
 
+	t.Run("Login upgrades bcrypt hashes to Argon2id", func(t *testing.T) {
+		setup(t)
+
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		user := models.User{
+			Nickname:   "testuser",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      "test-rehash@example.com",
+			Identifier: string(hashedPassword),
+		}
+		db.Create(&user)
+
+		login := func(password string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/login",
+				strings.NewReader(`{"email":"test-rehash@example.com", "password":"`+password+`"}`))
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		assert.Equal(t, 401, login("wrongpassword"))
+		db.First(&user, user.ID)
+		assert.Equal(t, string(hashedPassword), user.Identifier, "A failed login must not touch the hash")
+
+		assert.Equal(t, 200, login("password123"))
+		db.First(&user, user.ID)
+		assert.True(t, strings.HasPrefix(user.Identifier, "$argon2id$"), user.Identifier)
+		_, needsRehash := passwordhash.Default().Verify("password123", user.Identifier)
+		assert.False(t, needsRehash)
+
+		assert.Equal(t, 200, login("password123"), "The new hash is used from now on")
+	})
+
 	t.Run("Change Password", func(t *testing.T) {
 		setup(t)
 
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
-		db.Create(&models.User{
+		testUser := models.User{
 			Nickname:   "testuser",
 			Role:       models.RoleUser,
 			Provider:   models.AuthProviderPassword,
 			Email:      "test-change-password@example.com",
 			Identifier: string(hashedPassword),
-		})
+		}
+		db.Create(&testUser)
 
-		fakeToken, _ := authController.GenerateToken(schemas.TokenPayload{
-			UserID:   1,
-			Role:     "user",
-			Nickname: "testuser",
-		}, 1)
+		fakeToken, _, _ := authController.StartSession(db, testUser, "", "")
 
 		w_change := httptest.NewRecorder()
 		req_change, _ := http.NewRequest(http.MethodPost, "/auth/change-password",
@@ -125,27 +157,62 @@ func TestAuth(t *testing.T) {
 		assert.Equal(t, "testuser", user.Nickname, "User nickname should match")
 		assert.Equal(t, models.RoleUser, user.Role, "User role should be 'user'")
 		assert.Equal(t, models.AuthProviderPassword, user.Provider, "User provider should be 'password'")
-		err2 := bcrypt.CompareHashAndPassword([]byte(user.Identifier), []byte("newpassword123"))
-		assert.NoError(t, err2, "User password should match")
+		match, _ := passwordhash.Default().Verify("newpassword123", user.Identifier)
+		assert.True(t, match, "User password should match")
+	})
+
+	t.Run("Change Password logs out other sessions", func(t *testing.T) {
+		setup(t)
+
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		testUser := models.User{
+			Nickname:   "testuser",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      "test-change-password@example.com",
+			Identifier: string(hashedPassword),
+		}
+		db.Create(&testUser)
+
+		token, _, err := authController.StartSession(db, testUser, "", "")
+		require.NoError(t, err)
+		otherToken, otherRefreshToken, err := authController.StartSession(db, testUser, "", "")
+		require.NoError(t, err)
+
+		changePassword := func(token, oldPassword, newPassword string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/change-password",
+				strings.NewReader(`{"old_password":"`+oldPassword+`", "new_password":"`+newPassword+`"}`))
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		require.Equal(t, 200, changePassword(token, "password123", "newpassword123"))
+		assert.Equal(t, 200, changePassword(token, "newpassword123", "another-password-456"), "The session that changed the password stays")
+		assert.Equal(t, 401, changePassword(otherToken, "another-password-456", "newpassword123"), "Other sessions are revoked")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/refresh", nil)
+		req.AddCookie(&http.Cookie{Name: "refresh_token", Value: otherRefreshToken})
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code, "A stolen refresh token stops working")
 	})
 
 	t.Run("logout", func(t *testing.T) {
 		setup(t)
 
 		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
-		db.Create(&models.User{
+		testUser := models.User{
 			Nickname:   "testuser",
 			Role:       models.RoleUser,
 			Provider:   models.AuthProviderPassword,
 			Email:      "test-change-password@example.com",
 			Identifier: string(hashedPassword),
-		})
+		}
+		db.Create(&testUser)
 
-		fakeToken, _ := authController.GenerateToken(schemas.TokenPayload{
-			UserID:   1,
-			Role:     "user",
-			Nickname: "testuser",
-		}, 1)
+		fakeToken, _, _ := authController.StartSession(db, testUser, "", "")
 
 		w_logout := httptest.NewRecorder()
 		req_logout, _ := http.NewRequest(http.MethodPost, "/auth/logout", nil)
@@ -165,5 +232,75 @@ func TestAuth(t *testing.T) {
 				assert.True(t, cookie.MaxAge == -1, "auth_token cookie should be cleared after logout")
 			}
 		}
+
+		// Old token should be rejected after logout
+		w_after := httptest.NewRecorder()
+		req_after, _ := http.NewRequest(http.MethodPost, "/auth/change-password",
+			strings.NewReader(`{
+				"old_password":"password123",
+				"new_password":"newpassword123"
+			}`))
+		req_after.AddCookie(&http.Cookie{
+			Name:  "auth_token",
+			Value: fakeToken,
+		})
+
+		router.ServeHTTP(w_after, req_after)
+
+		assert.Equal(t, 401, w_after.Code, "Token should be revoked after logout")
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		setup(t)
+
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		testUser := models.User{
+			Nickname:   "testuser",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      "test-refresh@example.com",
+			Identifier: string(hashedPassword),
+		}
+		db.Create(&testUser)
+
+		_, refreshToken, err := authController.StartSession(db, testUser, "", "")
+		assert.NoError(t, err)
+
+		w_refresh := httptest.NewRecorder()
+		req_refresh, _ := http.NewRequest(http.MethodPost, "/auth/refresh", nil)
+		req_refresh.AddCookie(&http.Cookie{
+			Name:  "refresh_token",
+			Value: refreshToken,
+		})
+
+		router.ServeHTTP(w_refresh, req_refresh)
+
+		assert.Equal(t, 200, w_refresh.Code)
+
+		// Both cookies should be re-issued and the refresh token rotated
+		var authCookie, refreshCookie *http.Cookie
+		for _, cookie := range w_refresh.Result().Cookies() {
+			switch cookie.Name {
+			case "auth_token":
+				authCookie = cookie
+			case "refresh_token":
+				refreshCookie = cookie
+			}
+		}
+		assert.NotNil(t, authCookie, "auth_token cookie should be set")
+		assert.NotNil(t, refreshCookie, "refresh_token cookie should be set")
+		assert.NotEqual(t, refreshToken, refreshCookie.Value, "Refresh token should be rotated")
+
+		// The old refresh token cannot be used again
+		w_reuse := httptest.NewRecorder()
+		req_reuse, _ := http.NewRequest(http.MethodPost, "/auth/refresh", nil)
+		req_reuse.AddCookie(&http.Cookie{
+			Name:  "refresh_token",
+			Value: refreshToken,
+		})
+
+		router.ServeHTTP(w_reuse, req_reuse)
+
+		assert.Equal(t, 401, w_reuse.Code, "Rotated refresh token should be rejected")
 	})
 }
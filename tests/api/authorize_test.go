@@ -0,0 +1,82 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorization(t *testing.T) {
+	createReurl := func(t *testing.T, token, key string) uint {
+		w := request(http.MethodPost, "/reurl", token, fmt.Sprintf(`{"key": %q, "target_url": "https://example.com"}`, key))
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		var data struct {
+			Data struct {
+				ID uint `json:"ID"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		return data.Data.ID
+	}
+
+	t.Run("Owners manage their own mappings only", func(t *testing.T) {
+		setup(t)
+		_, aliceToken := createUser(t, "alice", models.RoleUser)
+		_, bobToken := createUser(t, "bob", models.RoleUser)
+		id := createReurl(t, aliceToken, "alice-link")
+
+		assert.Equal(t, 200, request(http.MethodGet, fmt.Sprintf("/reurl/%d", id), aliceToken, "").Code)
+		assert.Equal(t, 403, request(http.MethodGet, fmt.Sprintf("/reurl/%d", id), bobToken, "").Code)
+		assert.Equal(t, 403, request(http.MethodDelete, fmt.Sprintf("/reurl/%d", id), bobToken, "").Code)
+	})
+
+	t.Run("Admins manage all mappings", func(t *testing.T) {
+		setup(t)
+		_, aliceToken := createUser(t, "alice", models.RoleUser)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		id := createReurl(t, aliceToken, "alice-link")
+
+		w := request(http.MethodGet, "/reurl", adminToken, "")
+		require.Equal(t, 200, w.Code)
+		var data struct {
+			Data []map[string]any `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		assert.Len(t, data.Data, 1)
+
+		assert.Equal(t, 200, request(http.MethodDelete, fmt.Sprintf("/reurl/%d", id), adminToken, "").Code)
+	})
+
+	t.Run("Guests are read-only", func(t *testing.T) {
+		setup(t)
+		_, guestToken := createUser(t, "guest", models.RoleGuest)
+
+		assert.Equal(t, 200, request(http.MethodGet, "/reurl", guestToken, "").Code)
+
+		w := request(http.MethodPost, "/reurl", guestToken, `{"target_url": "https://example.com"}`)
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), "reurl:write")
+	})
+
+	t.Run("Unauthenticated requests are rejected", func(t *testing.T) {
+		setup(t)
+
+		assert.Equal(t, 401, request(http.MethodGet, "/reurl", "", "").Code)
+		assert.Equal(t, 401, request(http.MethodGet, "/admin/users", "", "").Code)
+	})
+
+	t.Run("Permission table", func(t *testing.T) {
+		assert.True(t, models.RoleAdmin.HasPermission(models.PermUsersManage))
+		assert.False(t, models.RoleUser.HasPermission(models.PermUsersManage))
+		assert.False(t, models.RoleUser.HasPermission(models.PermReurlManageAll))
+		assert.True(t, models.RoleAnonymous.HasPermission(models.PermStorageWrite))
+		assert.False(t, models.RoleAnonymous.HasPermission(models.PermReurlRead))
+		assert.False(t, models.Role("unknown").HasPermission(models.PermStorageRead))
+	})
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"personal_site/models"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	githubServer     *httptest.Server
+	githubServerOnce sync.Once
+	// githubEmails is what the fake /user/emails endpoint answers
+	githubEmails string
+)
+
+// fakeGitHubServer serves the GitHub token, user and emails endpoints.
+// It is shared by all tests because the GitHub OAuth config and endpoint variables are cached once read.
+func fakeGitHubServer() *httptest.Server {
+	githubServerOnce.Do(func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.Form.Get("code") != "good-code" || r.Form.Get("code_verifier") == "" {
+				w.WriteHeader(400)
+				w.Write([]byte(`{"error":"bad_verification_code"}`))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"github-access","token_type":"bearer"}`))
+		})
+		mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer github-access" {
+				w.WriteHeader(401)
+				return
+			}
+			w.Write([]byte(`{"id":4242,"login":"octo","name":"Octo Cat"}`))
+		})
+		mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer github-access" {
+				w.WriteHeader(401)
+				return
+			}
+			w.Write([]byte(githubEmails))
+		})
+		githubServer = httptest.NewServer(mux)
+	})
+	return githubServer
+}
+
+func TestGitHubLogin(t *testing.T) {
+	server := fakeGitHubServer()
+
+	setupGitHub := func(t *testing.T, emails string) {
+		setup(t)
+		t.Setenv("GITHUB_CLIENT_ID", "github-client")
+		t.Setenv("GITHUB_CLIENT_SECRET", "github-secret")
+		t.Setenv("GITHUB_AUTH_URL", server.URL+"/login/oauth/authorize")
+		t.Setenv("GITHUB_TOKEN_URL", server.URL+"/login/oauth/access_token")
+		t.Setenv("GITHUB_API_URL", server.URL)
+		githubEmails = emails
+	}
+
+	login := func(t *testing.T) *httptest.ResponseRecorder {
+		location, stateCookie := startOAuthLogin(t, "/auth/login-github")
+		return oauthCallback("/auth/login-github-callback", "good-code", location.Query().Get("state"), stateCookie)
+	}
+
+	t.Run("Unverified emails are never used", func(t *testing.T) {
+		setupGitHub(t, `[{"email":"octo@example.com","primary":true,"verified":false}]`)
+
+		w := login(t)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		var user models.User
+		require.NoError(t, db.First(&user, "provider = ? AND identifier = ?", models.AuthProviderGitHub, "4242").Error)
+		assert.Equal(t, "github_4242@users.noreply.github.local", user.Email)
+		assert.False(t, user.EmailVerified)
+	})
+
+	t.Run("Domain sign-ups need a verified email", func(t *testing.T) {
+		setupGitHub(t, `[{"email":"octo@example.com","primary":true,"verified":false}]`)
+		t.Setenv("REGISTRATION_MODE", "domain")
+		t.Setenv("REGISTRATION_EMAIL_DOMAINS", "example.com")
+
+		w := login(t)
+		require.Equal(t, 403, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "Registration not allowed")
+		var count int64
+		db.Model(&models.User{}).Count(&count)
+		assert.Equal(t, int64(0), count, "No user should be created")
+
+		// A verified address is used even when it is not the primary one
+		githubEmails = `[{"email":"octo@example.net","primary":true,"verified":false},{"email":"octo@example.com","primary":false,"verified":true}]`
+		w = login(t)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		var user models.User
+		require.NoError(t, db.First(&user, "provider = ? AND identifier = ?", models.AuthProviderGitHub, "4242").Error)
+		assert.Equal(t, "octo@example.com", user.Email)
+		assert.True(t, user.EmailVerified)
+	})
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"personal_site/models"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	googleServer     *httptest.Server
+	googleServerOnce sync.Once
+	// googleUserinfo is what the fake userinfo endpoint answers
+	googleUserinfo string
+)
+
+// fakeGoogleServer serves the Google token and userinfo endpoints.
+// It is shared by all tests because the Google OAuth config and endpoint variables are cached once read.
+func fakeGoogleServer() *httptest.Server {
+	googleServerOnce.Do(func() {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			if r.Form.Get("code") != "good-code" || r.Form.Get("client_secret") != "google-secret" || r.Form.Get("code_verifier") == "" {
+				w.WriteHeader(400)
+				w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"google-access","token_type":"Bearer","expires_in":3600}`))
+		})
+		mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer google-access" {
+				w.WriteHeader(401)
+				return
+			}
+			w.Write([]byte(googleUserinfo))
+		})
+		googleServer = httptest.NewServer(mux)
+	})
+	return googleServer
+}
+
+func TestGoogleLogin(t *testing.T) {
+	server := fakeGoogleServer()
+
+	setupGoogle := func(t *testing.T, userinfo string) {
+		setup(t)
+		t.Setenv("GOOGLE_CLIENT_ID", "google-client")
+		t.Setenv("GOOGLE_CLIENT_SECRET", "google-secret")
+		t.Setenv("GOOGLE_AUTH_URL", server.URL+"/authorize")
+		t.Setenv("GOOGLE_TOKEN_URL", server.URL+"/token")
+		t.Setenv("GOOGLE_USERINFO_URL", server.URL+"/userinfo")
+		googleUserinfo = userinfo
+	}
+
+	login := func(t *testing.T) *httptest.ResponseRecorder {
+		location, stateCookie := startOAuthLogin(t, "/auth/login-google")
+		return oauthCallback("/auth/login-google-callback", "good-code", location.Query().Get("state"), stateCookie)
+	}
+
+	t.Run("Callback creates user", func(t *testing.T) {
+		setupGoogle(t, `{"sub":"g-1","email":"gina@example.com","email_verified":true,"name":"Gina"}`)
+
+		w := login(t)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		var user models.User
+		require.NoError(t, db.First(&user, "provider = ? AND identifier = ?", models.AuthProviderGoogle, "g-1").Error)
+		assert.Equal(t, "gina@example.com", user.Email)
+		assert.True(t, user.EmailVerified)
+	})
+
+	t.Run("Domain sign-ups need a verified email", func(t *testing.T) {
+		setupGoogle(t, `{"sub":"g-1","email":"gina@example.com","email_verified":false,"name":"Gina"}`)
+		t.Setenv("REGISTRATION_MODE", "domain")
+		t.Setenv("REGISTRATION_EMAIL_DOMAINS", "example.com")
+
+		w := login(t)
+		require.Equal(t, 403, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "Registration not allowed")
+		var count int64
+		db.Model(&models.User{}).Count(&count)
+		assert.Equal(t, int64(0), count, "No user should be created")
+
+		googleUserinfo = `{"sub":"g-1","email":"gina@example.com","email_verified":true,"name":"Gina"}`
+		assert.Equal(t, 200, login(t).Code)
+	})
+}
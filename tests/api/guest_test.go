@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"personal_site/mailer"
+	"personal_site/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuests(t *testing.T) {
+	// writeFile puts a file into the storage of user
+	writeFile := func(t *testing.T, user models.User, name, content string) {
+		path := storagePath(t, user, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	loginToken := func(t *testing.T, email, password string) string {
+		w := request(http.MethodPost, "/auth/login", "", fmt.Sprintf(`{"email": %q, "password": %q}`, email, password))
+		require.Equal(t, 200, w.Code, w.Body.String())
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "auth_token" {
+				return cookie.Value
+			}
+		}
+		t.Fatal("auth_token cookie should be set")
+		return ""
+	}
+
+	t.Run("Admins create guests who only read shared folders", func(t *testing.T) {
+		setup(t)
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		owner, _ := createUser(t, "olivia", models.RoleUser)
+		writeFile(t, owner, "photos/beach.txt", "sand")
+		writeFile(t, owner, "diary.txt", "secret")
+
+		w := request(http.MethodPost, "/admin/guests", adminToken, fmt.Sprintf(
+			`{"email": "gus@example.com", "nickname": "gus", "grants": [{"resource": "storage", "owner_id": %d, "path": "photos"}]}`, owner.ID))
+		require.Equal(t, 201, w.Code, w.Body.String())
+		assert.Equal(t, 409, request(http.MethodPost, "/admin/guests", adminToken, `{"email": "gus@example.com", "nickname": "gus"}`).Code)
+
+		var guest models.User
+		require.NoError(t, db.First(&guest, "email = ?", "gus@example.com").Error)
+		assert.Equal(t, models.RoleGuest, guest.Role)
+		require.NotNil(t, guest.ExpiresAt, "Guests are time-limited")
+		assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), *guest.ExpiresAt, time.Minute)
+
+		// The guest chooses a password with the emailed link
+		resetBody := fmt.Sprintf(`{"token": %q, "new_password": "blue-kettle-42"}`, lastMailedToken(t, mailPath))
+		require.Equal(t, 200, request(http.MethodPost, "/auth/reset-password", "", resetBody).Code)
+		guestToken := loginToken(t, "gus@example.com", "blue-kettle-42")
+
+		w = request(http.MethodGet, "/storage/shared", guestToken, "")
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var shared struct {
+			Data []struct {
+				GrantID uint   `json:"grant_id"`
+				Owner   string `json:"owner"`
+				Path    string `json:"path"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &shared))
+		require.Len(t, shared.Data, 1)
+		assert.Equal(t, "olivia", shared.Data[0].Owner)
+		assert.Equal(t, "/photos", shared.Data[0].Path)
+		prefix := fmt.Sprintf("/storage/shared/%d", shared.Data[0].GrantID)
+
+		w = request(http.MethodGet, prefix+"/folder/", guestToken, "")
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "beach.txt")
+		w = request(http.MethodGet, prefix+"/file/beach.txt", guestToken, "")
+		require.Equal(t, 200, w.Code)
+		assert.Equal(t, "sand", w.Body.String())
+
+		assert.Equal(t, 404, request(http.MethodGet, prefix+"/file/../diary.txt", guestToken, "").Code, "Paths can not leave the shared folder")
+		assert.Equal(t, 403, request(http.MethodPost, "/storage/folder/new", guestToken, "").Code, "Guests can not write")
+
+		_, otherToken := createUser(t, "oscar", models.RoleUser)
+		assert.Equal(t, 404, request(http.MethodGet, prefix+"/file/beach.txt", otherToken, "").Code, "Grants only work for their user")
+		assert.Equal(t, 401, request(http.MethodGet, prefix+"/file/beach.txt", "", "").Code)
+	})
+
+	t.Run("Expired accounts can not log in or use their sessions", func(t *testing.T) {
+		setup(t)
+		guest, guestToken := createUser(t, "greta", models.RoleGuest)
+		require.Equal(t, 200, request(http.MethodGet, "/auth/me", guestToken, "").Code)
+
+		require.NoError(t, db.Model(&guest).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+		w := request(http.MethodGet, "/auth/me", guestToken, "")
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), "Account expired")
+	})
+
+	t.Run("Guest invites hand their grants to the account", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		owner, _ := createUser(t, "olivia", models.RoleUser)
+		shared := models.Reurl{Key: "shared-link", TargetURL: "https://example.com", OwnerID: owner.ID}
+		private := models.Reurl{Key: "private-link", TargetURL: "https://example.org", OwnerID: owner.ID}
+		require.NoError(t, db.Create(&shared).Error)
+		require.NoError(t, db.Create(&private).Error)
+
+		assert.Equal(t, 404, request(http.MethodPost, "/admin/invites", adminToken, `{"role": "guest", "grants": [{"resource": "reurl", "reurl_id": 999}]}`).Code)
+		assert.Equal(t, 400, request(http.MethodPost, "/admin/invites", adminToken, `{"role": "guest", "grants": [{"resource": "printer"}]}`).Code)
+
+		w := request(http.MethodPost, "/admin/invites", adminToken, fmt.Sprintf(
+			`{"email": "gail@example.com", "role": "guest", "grants": [{"resource": "reurl", "reurl_id": %d}]}`, shared.ID))
+		require.Equal(t, 201, w.Code, w.Body.String())
+		var invite struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &invite))
+
+		w = request(http.MethodPost, "/auth/register", "", fmt.Sprintf(
+			`{"email": "gail@example.com", "nickname": "gail", "password": "blue-kettle-42", "invite_code": %q}`, invite.Code))
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		var guest models.User
+		require.NoError(t, db.First(&guest, "email = ?", "gail@example.com").Error)
+		assert.Equal(t, models.RoleGuest, guest.Role)
+		assert.NotNil(t, guest.ExpiresAt)
+
+		guestToken := loginToken(t, "gail@example.com", "blue-kettle-42")
+		w = request(http.MethodGet, "/reurl", guestToken, "")
+		require.Equal(t, 200, w.Code)
+		var list struct {
+			Data []models.Reurl `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, "shared-link", list.Data[0].Key)
+
+		assert.Equal(t, 200, request(http.MethodGet, fmt.Sprintf("/reurl/%d", shared.ID), guestToken, "").Code)
+		assert.Equal(t, 403, request(http.MethodGet, fmt.Sprintf("/reurl/%d", private.ID), guestToken, "").Code)
+		assert.Equal(t, 403, request(http.MethodDelete, fmt.Sprintf("/reurl/%d", shared.ID), guestToken, "").Code)
+	})
+
+	t.Run("Admins manage grants and guest expiry", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		owner, _ := createUser(t, "olivia", models.RoleUser)
+		user, _ := createUser(t, "uma", models.RoleUser)
+		path := fmt.Sprintf("/admin/users/%d", user.ID)
+
+		w := request(http.MethodPost, path+"/grants", adminToken, fmt.Sprintf(`{"resource": "storage", "owner_id": %d, "path": "../../etc"}`, owner.ID))
+		require.Equal(t, 201, w.Code, w.Body.String())
+		var grant struct {
+			Data struct {
+				ID   uint   `json:"id"`
+				Path string `json:"path"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &grant))
+		assert.Equal(t, "/etc", grant.Data.Path, "Grant paths stay inside the owner's storage")
+
+		w = request(http.MethodGet, path+"/grants", adminToken, "")
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"id":%d`, grant.Data.ID))
+
+		grantPath := fmt.Sprintf("%s/grants/%d", path, grant.Data.ID)
+		assert.Equal(t, 200, request(http.MethodDelete, grantPath, adminToken, "").Code)
+		assert.Equal(t, 404, request(http.MethodDelete, grantPath, adminToken, "").Code)
+
+		// Turning a user into a guest gives them an expiry, turning them back removes it
+		require.Equal(t, 200, request(http.MethodPatch, path, adminToken, `{"role": "guest"}`).Code)
+		require.NoError(t, db.First(&user, user.ID).Error)
+		assert.NotNil(t, user.ExpiresAt)
+
+		assert.Equal(t, 400, request(http.MethodPatch, path, adminToken, `{"expires_at": "2000-01-01T00:00:00Z"}`).Code)
+		expiresAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+		require.Equal(t, 200, request(http.MethodPatch, path, adminToken, fmt.Sprintf(`{"expires_at": %q}`, expiresAt.Format(time.RFC3339))).Code)
+		require.NoError(t, db.First(&user, user.ID).Error)
+		require.NotNil(t, user.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*user.ExpiresAt))
+
+		require.Equal(t, 200, request(http.MethodPatch, path, adminToken, `{"role": "user"}`).Code)
+		var promoted models.User
+		require.NoError(t, db.First(&promoted, user.ID).Error)
+		assert.Nil(t, promoted.ExpiresAt)
+	})
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityLinking(t *testing.T) {
+	server := fakeLineServer()
+
+	setupLine := func(t *testing.T) {
+		setup(t)
+		t.Setenv("LINE_CHANNEL_ID", "line-channel")
+		t.Setenv("LINE_CHANNEL_SECRET", "line-secret")
+		t.Setenv("LINE_AUTH_URL", server.URL+"/oauth2/v2.1/authorize")
+		t.Setenv("LINE_TOKEN_URL", server.URL+"/oauth2/v2.1/token")
+		t.Setenv("LINE_PROFILE_URL", server.URL+"/v2/profile")
+		t.Setenv("LINE_VERIFY_URL", server.URL+"/oauth2/v2.1/verify")
+	}
+
+	createPasswordUser := func(t *testing.T, email string) (models.User, string) {
+		user := models.User{
+			Nickname:   "linker",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      email,
+			Identifier: "not-a-password",
+		}
+		require.NoError(t, db.Create(&user).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+		return user, token
+	}
+
+	// linkLine runs the link start + LINE callback for the user owning token
+	linkLine := func(t *testing.T, token string) *httptest.ResponseRecorder {
+		location, stateCookie := startOAuthLogin(t, "/auth/identities/link/line", &http.Cookie{Name: "auth_token", Value: token})
+		return oauthCallback("/auth/login-line-callback", "good-code", location.Query().Get("state"), stateCookie)
+	}
+
+	lineLogin := func(t *testing.T) uint {
+		location, stateCookie := startOAuthLogin(t, "/auth/login-line")
+		w := oauthCallback("/auth/login-line-callback", "good-code", location.Query().Get("state"), stateCookie)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		var data map[string]any
+		json.Unmarshal(w.Body.Bytes(), &data)
+		return uint(data["user_id"].(float64))
+	}
+
+	listIdentities := func(token string) []map[string]any {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/auth/identities", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		router.ServeHTTP(w, req)
+
+		var data struct {
+			Data []map[string]any `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &data)
+		return data.Data
+	}
+
+	unlink := func(token string, id any) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/auth/identities/%v", id), nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Linked provider logs into the existing user", func(t *testing.T) {
+		setupLine(t)
+		user, token := createPasswordUser(t, "link@example.com")
+
+		w := linkLine(t, token)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		identities := listIdentities(token)
+		require.Len(t, identities, 1)
+		assert.Equal(t, string(models.AuthProviderLine), identities[0]["provider"])
+		assert.Equal(t, false, identities[0]["primary"])
+
+		assert.Equal(t, user.ID, lineLogin(t), "LINE login should resolve to the linked user")
+
+		var count int64
+		db.Model(&models.User{}).Count(&count)
+		assert.Equal(t, int64(1), count, "No duplicate user should be created")
+	})
+
+	t.Run("Identity of another account can not be linked", func(t *testing.T) {
+		setupLine(t)
+		_, firstToken := createPasswordUser(t, "first@example.com")
+		_, secondToken := createPasswordUser(t, "second@example.com")
+
+		require.Equal(t, 200, linkLine(t, firstToken).Code)
+		assert.Equal(t, 409, linkLine(t, secondToken).Code)
+	})
+
+	t.Run("Unlink identity", func(t *testing.T) {
+		setupLine(t)
+		user, token := createPasswordUser(t, "unlink@example.com")
+		require.Equal(t, 200, linkLine(t, token).Code)
+
+		identities := listIdentities(token)
+		require.Len(t, identities, 1)
+		assert.Equal(t, 200, unlink(token, identities[0]["id"]))
+		assert.Empty(t, listIdentities(token))
+
+		assert.NotEqual(t, user.ID, lineLogin(t), "Unlinked provider should no longer log into the user")
+	})
+
+	t.Run("Primary identity can not be unlinked", func(t *testing.T) {
+		setupLine(t)
+		userID := lineLogin(t)
+
+		var user models.User
+		require.NoError(t, db.First(&user, userID).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+
+		identities := listIdentities(token)
+		require.Len(t, identities, 1)
+		assert.Equal(t, true, identities[0]["primary"])
+		assert.Equal(t, 409, unlink(token, identities[0]["id"]))
+	})
+
+	t.Run("Link unknown provider", func(t *testing.T) {
+		setupLine(t)
+		_, token := createPasswordUser(t, "unknown@example.com")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/auth/identities/link/myspace", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 404, w.Code)
+	})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvites(t *testing.T) {
+	setupAdmin := func(t *testing.T) string {
+		setup(t)
+		admin := models.User{Nickname: "admin", Role: models.RoleAdmin, Provider: models.AuthProviderPassword, Email: "admin@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&admin).Error)
+		token, _, err := authController.StartSession(db, admin, "", "")
+		require.NoError(t, err)
+		return token
+	}
+
+	// createInvite returns the code and id of a new invite
+	createInvite := func(t *testing.T, adminToken, body string) (string, uint) {
+		w := request(http.MethodPost, "/admin/invites", adminToken, body)
+		require.Equal(t, 201, w.Code, w.Body.String())
+		var data struct {
+			Code string `json:"code"`
+			Data struct {
+				ID uint `json:"id"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		require.NotEmpty(t, data.Code)
+		return data.Code, data.Data.ID
+	}
+
+	register := func(email, inviteCode string) *httptest.ResponseRecorder {
+		return request(http.MethodPost, "/auth/register", "", fmt.Sprintf(
+			`{"email": "%s", "nickname": "newbie", "password": "blue-kettle-42", "invite_code": "%s"}`, email, inviteCode))
+	}
+
+	refused := func(t *testing.T, w *httptest.ResponseRecorder) {
+		require.Equal(t, 403, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "Registration not allowed")
+	}
+
+	t.Run("Invites are single use and give their role", func(t *testing.T) {
+		adminToken := setupAdmin(t)
+		code, id := createInvite(t, adminToken, `{"email": "ivan@example.com", "role": "guest"}`)
+
+		refused(t, register("someone-else@example.com", code))
+		refused(t, register("ivan@example.com", "not-a-code"))
+		require.Equal(t, 200, register("ivan@example.com", code).Code)
+
+		var user models.User
+		require.NoError(t, db.First(&user, "email = ?", "ivan@example.com").Error)
+		assert.Equal(t, models.RoleGuest, user.Role)
+
+		var invite models.Invite
+		require.NoError(t, db.First(&invite, id).Error)
+		assert.NotNil(t, invite.UsedAt)
+		assert.Equal(t, user.ID, invite.UsedBy)
+
+		refused(t, register("ivan2@example.com", code))
+
+		w := request(http.MethodGet, "/admin/invites?unused=true", adminToken, "")
+		require.Equal(t, 200, w.Code)
+		assert.JSONEq(t, `{"data": []}`, w.Body.String())
+	})
+
+	t.Run("Admins list and delete invites", func(t *testing.T) {
+		adminToken := setupAdmin(t)
+		_, id := createInvite(t, adminToken, `{}`)
+
+		assert.Equal(t, 400, request(http.MethodPost, "/admin/invites", adminToken, `{"role": "root"}`).Code)
+		assert.Equal(t, 400, request(http.MethodPost, "/admin/invites", adminToken, `{"expires_at": "2000-01-01T00:00:00Z"}`).Code)
+
+		w := request(http.MethodGet, "/admin/invites", adminToken, "")
+		require.Equal(t, 200, w.Code)
+		var data struct {
+			Data []map[string]any `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		require.Len(t, data.Data, 1)
+		assert.Equal(t, "user", data.Data[0]["role"])
+		assert.NotContains(t, data.Data[0], "code_hash")
+
+		path := fmt.Sprintf("/admin/invites/%d", id)
+		assert.Equal(t, 200, request(http.MethodDelete, path, adminToken, "").Code)
+		assert.Equal(t, 404, request(http.MethodDelete, path, adminToken, "").Code)
+
+		user := models.User{Nickname: "judy", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "judy@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&user).Error)
+		userToken, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, 403, request(http.MethodPost, "/admin/invites", userToken, `{}`).Code)
+	})
+
+	t.Run("OAuth sign-ups redeem the invite of the login", func(t *testing.T) {
+		server := fakeLineServer()
+		adminToken := setupAdmin(t)
+		t.Setenv("LINE_CHANNEL_ID", "line-channel")
+		t.Setenv("LINE_CHANNEL_SECRET", "line-secret")
+		t.Setenv("LINE_AUTH_URL", server.URL+"/oauth2/v2.1/authorize")
+		t.Setenv("LINE_TOKEN_URL", server.URL+"/oauth2/v2.1/token")
+		t.Setenv("LINE_PROFILE_URL", server.URL+"/v2/profile")
+		t.Setenv("LINE_VERIFY_URL", server.URL+"/oauth2/v2.1/verify")
+
+		code, id := createInvite(t, adminToken, `{"email": "line-user@example.com", "role": "guest"}`)
+
+		location, stateCookie := startOAuthLogin(t, "/auth/login-line?invite="+code)
+		assert.NotContains(t, location.String(), code, "The invite code stays out of the provider URL")
+		w := oauthCallback("/auth/login-line-callback", "good-code", location.Query().Get("state"), stateCookie)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		var user models.User
+		require.NoError(t, db.First(&user, "provider = ? AND identifier = ?", models.AuthProviderLine, "U1234567890").Error)
+		assert.Equal(t, models.RoleGuest, user.Role)
+		var invite models.Invite
+		require.NoError(t, db.First(&invite, id).Error)
+		assert.Equal(t, user.ID, invite.UsedBy)
+	})
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"personal_site/models"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	lineServer     *httptest.Server
+	lineServerOnce sync.Once
+)
+
+// fakeLineServer serves the LINE token, profile and ID token verify endpoints.
+// It is shared by all tests because the LINE OAuth config and endpoint variables are cached once read.
+func fakeLineServer() *httptest.Server {
+	lineServerOnce.Do(func() {
+		lineServer = newFakeLineServer()
+	})
+	return lineServer
+}
+
+func newFakeLineServer() *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v2.1/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != "good-code" || r.Form.Get("client_secret") != "line-secret" || r.Form.Get("code_verifier") == "" {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"line-access","token_type":"Bearer","expires_in":3600,"id_token":"line-id-token"}`))
+	})
+	mux.HandleFunc("/v2/profile", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer line-access" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{"userId":"U1234567890","displayName":"LINE User"}`))
+	})
+	mux.HandleFunc("/oauth2/v2.1/verify", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("id_token") != "line-id-token" || r.Form.Get("client_id") != "line-channel" {
+			w.WriteHeader(400)
+			return
+		}
+		w.Write([]byte(`{"sub":"U1234567890","name":"LINE User","email":"line-user@example.com"}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestLineLogin(t *testing.T) {
+	server := fakeLineServer()
+
+	setupLine := func(t *testing.T) {
+		setup(t)
+		t.Setenv("LINE_CHANNEL_ID", "line-channel")
+		t.Setenv("LINE_CHANNEL_SECRET", "line-secret")
+		t.Setenv("LINE_AUTH_URL", server.URL+"/oauth2/v2.1/authorize")
+		t.Setenv("LINE_TOKEN_URL", server.URL+"/oauth2/v2.1/token")
+		t.Setenv("LINE_PROFILE_URL", server.URL+"/v2/profile")
+		t.Setenv("LINE_VERIFY_URL", server.URL+"/oauth2/v2.1/verify")
+	}
+
+	t.Run("Start redirects to LINE", func(t *testing.T) {
+		setupLine(t)
+
+		location, _ := startOAuthLogin(t, "/auth/login-line")
+		assert.True(t, strings.HasPrefix(location.String(), server.URL+"/oauth2/v2.1/authorize"))
+		assert.Equal(t, "line-channel", location.Query().Get("client_id"))
+		assert.NotEmpty(t, location.Query().Get("state"))
+		assert.NotEmpty(t, location.Query().Get("code_challenge"), "PKCE challenge should be sent")
+		assert.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	})
+
+	t.Run("Callback creates user and sets cookie", func(t *testing.T) {
+		setupLine(t)
+
+		location, stateCookie := startOAuthLogin(t, "/auth/login-line")
+		w := oauthCallback("/auth/login-line-callback", "good-code", location.Query().Get("state"), stateCookie)
+
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var data map[string]any
+		json.Unmarshal(w.Body.Bytes(), &data)
+		assert.Equal(t, "LINE User", data["nickname"])
+
+		var user models.User
+		err := db.First(&user, "provider = ? AND identifier = ?", models.AuthProviderLine, "U1234567890").Error
+		require.NoError(t, err, "User should be created")
+		assert.Equal(t, "line-user@example.com", user.Email)
+
+		var authCookie *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "auth_token" {
+				authCookie = cookie
+			}
+		}
+		assert.NotNil(t, authCookie, "auth_token cookie should be set")
+	})
+
+	t.Run("Callback with bad code", func(t *testing.T) {
+		setupLine(t)
+
+		location, stateCookie := startOAuthLogin(t, "/auth/login-line")
+		w := oauthCallback("/auth/login-line-callback", "bad-code", location.Query().Get("state"), stateCookie)
+
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("Callback without state", func(t *testing.T) {
+		setupLine(t)
+
+		_, stateCookie := startOAuthLogin(t, "/auth/login-line")
+		w := oauthCallback("/auth/login-line-callback", "good-code", "", stateCookie)
+
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), "Missing OAuth state")
+	})
+
+	t.Run("Callback without state cookie", func(t *testing.T) {
+		setupLine(t)
+
+		location, _ := startOAuthLogin(t, "/auth/login-line")
+		w := oauthCallback("/auth/login-line-callback", "good-code", location.Query().Get("state"), nil)
+
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), "OAuth state cookie missing")
+	})
+
+	t.Run("Callback with forged state", func(t *testing.T) {
+		setupLine(t)
+
+		// An attacker's own login state does not match the victim's cookie
+		attackerLocation, _ := startOAuthLogin(t, "/auth/login-line")
+		_, victimCookie := startOAuthLogin(t, "/auth/login-line")
+		w := oauthCallback("/auth/login-line-callback", "good-code", attackerLocation.Query().Get("state"), victimCookie)
+
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), "OAuth state mismatch")
+
+		var count int64
+		db.Model(&models.User{}).Count(&count)
+		assert.Equal(t, int64(0), count, "No user should be created")
+	})
+
+	t.Run("Redirect target outside the allow-list is rejected", func(t *testing.T) {
+		setupLine(t)
+		t.Setenv("LOGIN_REDIRECT_ALLOWED_ORIGINS", "https://app.example.com")
+
+		for _, target := range []string{"https://evil.com/", "//evil.com", "https://app.example.com.evil.com/"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/auth/login-line?redirect="+url.QueryEscape(target), nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, 400, w.Code, target)
+			assert.Contains(t, w.Body.String(), "Redirect target not allowed")
+		}
+	})
+
+	t.Run("Callback redirects to allowed targets", func(t *testing.T) {
+		setupLine(t)
+		t.Setenv("LOGIN_REDIRECT_ALLOWED_ORIGINS", "https://app.example.com")
+
+		for _, target := range []string{"/after-login", "https://app.example.com/after-login"} {
+			location, stateCookie := startOAuthLogin(t, "/auth/login-line?redirect="+url.QueryEscape(target))
+			w := oauthCallback("/auth/login-line-callback", "good-code", location.Query().Get("state"), stateCookie)
+
+			require.Equal(t, 302, w.Code, w.Body.String())
+			back, err := url.Parse(w.Header().Get("Location"))
+			require.NoError(t, err)
+			assert.Equal(t, target, strings.SplitN(back.String(), "?", 2)[0])
+			assert.Equal(t, "success", back.Query().Get("login"))
+		}
+	})
+
+	t.Run("Callback rejects redirect query outside the allow-list", func(t *testing.T) {
+		setupLine(t)
+
+		location, stateCookie := startOAuthLogin(t, "/auth/login-line")
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/auth/login-line-callback?code=good-code&state="+url.QueryEscape(location.Query().Get("state"))+"&redirect="+url.QueryEscape("https://evil.com/"), nil)
+		req.AddCookie(stateCookie)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 400, w.Code)
+		assert.Contains(t, w.Body.String(), "Redirect target not allowed")
+	})
+}
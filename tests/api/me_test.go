@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	authController "personal_site/controllers/auth"
+	storageController "personal_site/controllers/storage"
+	"personal_site/mailer"
+	"personal_site/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestMe(t *testing.T) {
+	createProfileUser := func(t *testing.T, email string, provider models.AuthProvider) (models.User, string) {
+		hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		require.NoError(t, err)
+		user := models.User{
+			Nickname:      "me",
+			Role:          models.RoleUser,
+			Provider:      provider,
+			Email:         email,
+			Identifier:    string(hash),
+			EmailVerified: true,
+		}
+		require.NoError(t, db.Create(&user).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+		return user, token
+	}
+
+	t.Run("Get profile without password hash", func(t *testing.T) {
+		setup(t)
+		user, token := createProfileUser(t, "me@example.com", models.AuthProviderPassword)
+
+		w := request(http.MethodGet, "/auth/me", token, "")
+		require.Equal(t, 200, w.Code)
+
+		var data struct {
+			Data map[string]any `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		assert.Equal(t, float64(user.ID), data.Data["ID"])
+		assert.Equal(t, "me@example.com", data.Data["Email"])
+		assert.NotContains(t, data.Data, "Identifier")
+		assert.NotContains(t, w.Body.String(), user.Identifier)
+	})
+
+	t.Run("Change nickname without re-authentication", func(t *testing.T) {
+		setup(t)
+		user, token := createProfileUser(t, "me@example.com", models.AuthProviderPassword)
+
+		assert.Equal(t, 400, request(http.MethodPatch, "/auth/me", token, `{"nickname": "  "}`).Code)
+		require.Equal(t, 200, request(http.MethodPatch, "/auth/me", token, `{"nickname": "New Name"}`).Code)
+
+		db.First(&user, user.ID)
+		assert.Equal(t, "New Name", user.Nickname)
+	})
+
+	t.Run("Change email requires password and verification", func(t *testing.T) {
+		setup(t)
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+
+		user, token := createProfileUser(t, "me@example.com", models.AuthProviderPassword)
+		createProfileUser(t, "taken@example.com", models.AuthProviderPassword)
+
+		w := request(http.MethodPatch, "/auth/me", token, `{"email": "new@example.com"}`)
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), "reauth_required")
+
+		assert.Equal(t, 403, request(http.MethodPatch, "/auth/me", token, `{"email": "new@example.com", "password": "wrongpass"}`).Code)
+		assert.Equal(t, 409, request(http.MethodPatch, "/auth/me", token, `{"email": "taken@example.com", "password": "password123"}`).Code)
+		require.Equal(t, 200, request(http.MethodPatch, "/auth/me", token, `{"email": "new@example.com", "password": "password123"}`).Code)
+
+		db.First(&user, user.ID)
+		assert.Equal(t, "new@example.com", user.Email)
+		assert.False(t, user.EmailVerified, "New email has to be verified again")
+		mail, err := os.ReadFile(mailPath)
+		require.NoError(t, err)
+		assert.Contains(t, string(mail), "new@example.com")
+	})
+
+	t.Run("Accounts without password need a recent login", func(t *testing.T) {
+		setup(t)
+		user, token := createProfileUser(t, "oauth@example.com", models.AuthProviderGitHub)
+
+		require.Equal(t, 200, request(http.MethodPatch, "/auth/me", token, `{"email": "other@example.com"}`).Code)
+
+		db.Model(&models.Session{}).Where("user_id = ?", user.ID).UpdateColumn("created_at", time.Now().Add(-time.Hour))
+		w := request(http.MethodPatch, "/auth/me", token, `{"email": "third@example.com"}`)
+		assert.Equal(t, 403, w.Code)
+		assert.Contains(t, w.Body.String(), "reauth_required")
+	})
+
+	t.Run("Delete account with reurls and storage", func(t *testing.T) {
+		setup(t)
+		user, token := createProfileUser(t, "me@example.com", models.AuthProviderPassword)
+		require.NoError(t, db.Create(&models.Reurl{Key: "mine", TargetURL: "https://example.com", OwnerID: user.ID}).Error)
+		require.NoError(t, db.Create(&models.UserIdentity{UserID: user.ID, Provider: models.AuthProviderGitHub, Subject: "12345"}).Error)
+		require.NoError(t, db.Create(&models.AuthEvent{UserID: user.ID, Type: models.AuthEventLogin, Success: true}).Error)
+
+		storageRoot, err := storageController.GetStorageRoot()
+		require.NoError(t, err)
+		userDir := filepath.Join(storageRoot, "data", fmt.Sprintf("%d", user.ID))
+		require.NoError(t, os.MkdirAll(filepath.Join(userDir, "me"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(userDir, "me", "file.txt"), []byte("data"), 0o644))
+
+		assert.Equal(t, 403, request(http.MethodDelete, "/auth/me", token, `{"password": "wrongpass"}`).Code)
+		require.Equal(t, 200, request(http.MethodDelete, "/auth/me", token, `{"password": "password123"}`).Code)
+
+		var count int64
+		db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+		assert.Zero(t, count, "User should be deleted")
+		db.Unscoped().Model(&models.Reurl{}).Where("owner_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count, "Reurls should be deleted")
+		db.Unscoped().Model(&models.UserIdentity{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count, "Identities should be deleted")
+		db.Model(&models.AuthEvent{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count, "Audit log entries should not point to the ID anymore")
+		db.Model(&models.AuthEvent{}).Where("user_id = 0 AND email = ? AND type = ?", "me@example.com", models.AuthEventLogin).Count(&count)
+		assert.Equal(t, int64(1), count, "Audit log entries should be kept")
+		_, err = os.Stat(userDir)
+		assert.True(t, os.IsNotExist(err), "Storage tree should be deleted")
+
+		assert.Equal(t, 401, request(http.MethodGet, "/auth/me", token, "").Code)
+	})
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedUploadMerge(t *testing.T) {
+	type jobResponse struct {
+		Job struct {
+			Status models.MergeJobStatus `json:"status"`
+			SHA256 string                `json:"sha256"`
+			Size   int64                 `json:"size"`
+			Error  string                `json:"error"`
+		} `json:"job"`
+	}
+
+	t.Run("The last chunk merges synchronously and reports the job", func(t *testing.T) {
+		setup(t)
+		user, token := createUser(t, "merger", models.RoleUser)
+		sum := sha256.Sum256([]byte("hello world"))
+		fields := map[string]string{"file_id": "upload-1", "total_chunks": "2", "sha256": hex.EncodeToString(sum[:])}
+
+		// Chunks may arrive out of order, the last index is only merged once all chunks are there
+		w := uploadChunk("/storage/file/docs/hello.txt", token, fields, 1, " world")
+		require.Equal(t, 409, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"missing":[0]`)
+
+		w = uploadChunk("/storage/file/docs/hello.txt", token, fields, 0, "hello")
+		require.Equal(t, 201, w.Code, w.Body.String())
+		w = uploadChunk("/storage/file/docs/hello.txt", token, fields, 1, " world")
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		var response jobResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, models.MergeJobCompleted, response.Job.Status)
+		assert.Equal(t, hex.EncodeToString(sum[:]), response.Job.SHA256)
+		assert.Equal(t, int64(11), response.Job.Size)
+
+		content, err := os.ReadFile(storagePath(t, user, "docs/hello.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(content))
+
+		w = request(http.MethodGet, "/storage/merge-jobs/upload-1", token, "")
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"completed"`)
+	})
+
+	t.Run("A checksum mismatch fails the job and keeps the old file", func(t *testing.T) {
+		setup(t)
+		user, token := createUser(t, "merger", models.RoleUser)
+		path := storagePath(t, user, "notes.txt")
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("old"), 0o644))
+
+		sum := sha256.Sum256([]byte("something else"))
+		w := uploadChunk("/storage/file/notes.txt", token, map[string]string{"file_id": "upload-2", "total_chunks": "1", "sha256": hex.EncodeToString(sum[:])}, 0, "new")
+		require.Equal(t, 422, w.Code, w.Body.String())
+		var response jobResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, models.MergeJobFailed, response.Job.Status)
+		assert.NotEmpty(t, response.Job.Error)
+
+		content, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content), "Failed merges never replace the file")
+		entries, err := os.ReadDir(filepath.Dir(path))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "No temporary merge file is left behind")
+	})
+
+	t.Run("Invalid chunk requests", func(t *testing.T) {
+		setup(t)
+		_, token := createUser(t, "merger", models.RoleUser)
+
+		assert.Equal(t, 400, uploadChunk("/storage/file/a.txt", token, map[string]string{"file_id": "../escape", "total_chunks": "1"}, 0, "x").Code)
+		assert.Equal(t, 400, uploadChunk("/storage/file/a.txt", token, map[string]string{"file_id": "upload-3", "total_chunks": "1"}, 1, "x").Code)
+		assert.Equal(t, 400, uploadChunk("/storage/file/a.txt", token, map[string]string{"file_id": "upload-3", "total_chunks": "1", "sha256": "abc"}, 0, "x").Code)
+
+		assert.Equal(t, 404, request(http.MethodGet, "/storage/merge-jobs/upload-3", token, "").Code)
+	})
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// totpNow computes the current 6 digit TOTP code for a base32 secret
+func totpNow(secret string) string {
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestTOTP(t *testing.T) {
+	t.Run("Enroll and login with second factor", func(t *testing.T) {
+		setup(t)
+
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		testUser := models.User{
+			Nickname:   "testuser",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      "test-totp@example.com",
+			Identifier: string(hashedPassword),
+		}
+		db.Create(&testUser)
+		fakeToken, _, _ := authController.StartSession(db, testUser, "", "")
+
+		// setup
+		w_setup := httptest.NewRecorder()
+		req_setup, _ := http.NewRequest(http.MethodPost, "/auth/mfa/totp/setup", nil)
+		req_setup.AddCookie(&http.Cookie{Name: "auth_token", Value: fakeToken})
+		router.ServeHTTP(w_setup, req_setup)
+		require.Equal(t, 200, w_setup.Code)
+
+		var setupData map[string]string
+		json.Unmarshal(w_setup.Body.Bytes(), &setupData)
+		secret := setupData["secret"]
+		assert.NotEmpty(t, secret)
+		assert.True(t, strings.HasPrefix(setupData["provisioning_uri"], "otpauth://totp/"))
+
+		// enable
+		code := totpNow(secret)
+		w_enable := httptest.NewRecorder()
+		req_enable, _ := http.NewRequest(http.MethodPost, "/auth/mfa/totp/enable",
+			strings.NewReader(fmt.Sprintf(`{"code":"%s"}`, code)))
+		req_enable.AddCookie(&http.Cookie{Name: "auth_token", Value: fakeToken})
+		router.ServeHTTP(w_enable, req_enable)
+		require.Equal(t, 200, w_enable.Code)
+
+		var enableData struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		json.Unmarshal(w_enable.Body.Bytes(), &enableData)
+		require.Len(t, enableData.RecoveryCodes, 10)
+
+		// login now requires a second step
+		w_login := httptest.NewRecorder()
+		req_login, _ := http.NewRequest(http.MethodPost, "/auth/login",
+			strings.NewReader(`{"email":"test-totp@example.com","password":"password123"}`))
+		router.ServeHTTP(w_login, req_login)
+		assert.Equal(t, 202, w_login.Code)
+		assert.Empty(t, w_login.Result().Cookies(), "No cookie should be set before the second factor")
+
+		var loginData map[string]any
+		json.Unmarshal(w_login.Body.Bytes(), &loginData)
+		assert.Equal(t, true, loginData["mfa_required"])
+		mfaToken, _ := loginData["mfa_token"].(string)
+		require.NotEmpty(t, mfaToken)
+
+		loginMFA := func(code string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa",
+				strings.NewReader(fmt.Sprintf(`{"mfa_token":"%s","code":"%s"}`, mfaToken, code)))
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		// the code used for enabling can not be replayed
+		assert.Equal(t, 401, loginMFA(code).Code, "Used TOTP code should be rejected")
+
+		// a code from a later time step is accepted (pretend the enabling code was from an earlier step)
+		db.Model(&models.User{}).Where("id = ?", testUser.ID).UpdateColumn("totp_last_used_step", 0)
+		assert.Equal(t, 200, loginMFA(totpNow(secret)).Code, "Fresh TOTP code should be accepted")
+
+		// recovery codes work once
+		w_mfa := loginMFA(enableData.RecoveryCodes[0])
+		assert.Equal(t, 200, w_mfa.Code)
+		var authCookie *http.Cookie
+		for _, cookie := range w_mfa.Result().Cookies() {
+			if cookie.Name == "auth_token" {
+				authCookie = cookie
+			}
+		}
+		assert.NotNil(t, authCookie, "auth_token cookie should be set")
+		assert.Equal(t, 401, loginMFA(enableData.RecoveryCodes[0]).Code, "Used recovery code should be rejected")
+	})
+
+	t.Run("Access token is not an MFA token", func(t *testing.T) {
+		setup(t)
+
+		testUser := models.User{
+			Nickname:    "testuser",
+			Role:        models.RoleUser,
+			Provider:    models.AuthProviderPassword,
+			Email:       "test-totp-token@example.com",
+			Identifier:  "not-a-password",
+			TOTPEnabled: true,
+		}
+		db.Create(&testUser)
+		fakeToken, _, _ := authController.StartSession(db, testUser, "", "")
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login/mfa",
+			strings.NewReader(fmt.Sprintf(`{"mfa_token":"%s","code":"123456"}`, fakeToken)))
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 401, w.Code)
+	})
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"personal_site/models"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOIDCServer is a minimal OpenID provider with discovery, JWKS and a token endpoint
+type fakeOIDCServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	nonce     string
+	challenge string // PKCE code_challenge from the authorization request
+
+	emailVerified bool
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	f := &fakeOIDCServer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		verifierHash := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != "good-code" || r.Form.Get("client_secret") != "oidc-secret" ||
+			base64.RawURLEncoding.EncodeToString(verifierHash[:]) != f.challenge {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "oidc-access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     f.idToken(t, "oidc-client", f.nonce),
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOIDCServer) idToken(t *testing.T, audience, nonce string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                f.URL,
+		"sub":                "kc-user-1",
+		"aud":                audience,
+		"exp":                time.Now().Add(5 * time.Minute).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              nonce,
+		"email":              "oidc-user@example.com",
+		"email_verified":     f.emailVerified,
+		"preferred_username": "oidc-user",
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(f.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestOIDCLogin(t *testing.T) {
+	server := newFakeOIDCServer(t)
+
+	setupOIDC := func(t *testing.T) {
+		t.Setenv("OIDC_PROVIDERS", "keycloak")
+		t.Setenv("OIDC_KEYCLOAK_ISSUER", server.URL)
+		t.Setenv("OIDC_KEYCLOAK_CLIENT_ID", "oidc-client")
+		t.Setenv("OIDC_KEYCLOAK_CLIENT_SECRET", "oidc-secret")
+		setup(t)
+	}
+
+	startLogin := func(t *testing.T) (string, *http.Cookie) {
+		location, stateCookie := startOAuthLogin(t, "/auth/login-keycloak")
+		assert.True(t, strings.HasPrefix(location.String(), server.URL+"/authorize"))
+		assert.Equal(t, "oidc-client", location.Query().Get("client_id"))
+		assert.Contains(t, location.Query().Get("scope"), "openid")
+		require.NotEmpty(t, location.Query().Get("nonce"))
+		server.nonce = location.Query().Get("nonce")
+		server.challenge = location.Query().Get("code_challenge")
+		return location.Query().Get("state"), stateCookie
+	}
+
+	t.Run("Callback creates user and sets cookie", func(t *testing.T) {
+		setupOIDC(t)
+		state, stateCookie := startLogin(t)
+
+		w := oauthCallback("/auth/login-keycloak-callback", "good-code", state, stateCookie)
+
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var data map[string]any
+		json.Unmarshal(w.Body.Bytes(), &data)
+		assert.Equal(t, "oidc-user", data["nickname"])
+
+		var user models.User
+		err := db.First(&user, "provider = ? AND identifier = ?", models.OIDCAuthProvider("keycloak"), "kc-user-1").Error
+		require.NoError(t, err, "User should be created")
+		assert.Equal(t, "oidc-user@example.com", user.Email)
+
+		var authCookie *http.Cookie
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "auth_token" {
+				authCookie = cookie
+			}
+		}
+		assert.NotNil(t, authCookie, "auth_token cookie should be set")
+	})
+
+	t.Run("Domain sign-ups need a verified email", func(t *testing.T) {
+		setupOIDC(t)
+		t.Setenv("REGISTRATION_MODE", "domain")
+		t.Setenv("REGISTRATION_EMAIL_DOMAINS", "example.com")
+		defer func() { server.emailVerified = false }()
+
+		state, stateCookie := startLogin(t)
+		w := oauthCallback("/auth/login-keycloak-callback", "good-code", state, stateCookie)
+		require.Equal(t, 403, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "Registration not allowed")
+		var count int64
+		db.Model(&models.User{}).Count(&count)
+		assert.Equal(t, int64(0), count, "No user should be created")
+
+		server.emailVerified = true
+		state, stateCookie = startLogin(t)
+		w = oauthCallback("/auth/login-keycloak-callback", "good-code", state, stateCookie)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var user models.User
+		require.NoError(t, db.First(&user, "provider = ? AND identifier = ?", models.OIDCAuthProvider("keycloak"), "kc-user-1").Error)
+		assert.True(t, user.EmailVerified)
+	})
+
+	t.Run("Nonce mismatch is rejected", func(t *testing.T) {
+		setupOIDC(t)
+		state, stateCookie := startLogin(t)
+		server.nonce = "someone-elses-nonce"
+
+		w := oauthCallback("/auth/login-keycloak-callback", "good-code", state, stateCookie)
+
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("Callback with bad code", func(t *testing.T) {
+		setupOIDC(t)
+		state, stateCookie := startLogin(t)
+
+		w := oauthCallback("/auth/login-keycloak-callback", "bad-code", state, stateCookie)
+
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("Unconfigured provider has no route", func(t *testing.T) {
+		setupOIDC(t)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/auth/login-authentik", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, 404, w.Code)
+	})
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	authController "personal_site/controllers/auth"
+	"personal_site/mailer"
+	"personal_site/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	post := func(path, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// rejected checks for the error every password flow answers a weak password with
+	rejected := func(t *testing.T, w *httptest.ResponseRecorder, details string) {
+		require.Equal(t, 400, w.Code, w.Body.String())
+		var body struct {
+			Error   string `json:"error"`
+			Details string `json:"details"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "Password does not meet the requirements", body.Error)
+		assert.Contains(t, body.Details, details)
+	}
+
+	t.Run("Register", func(t *testing.T) {
+		setup(t)
+		register := func(password string) *httptest.ResponseRecorder {
+			return post("/auth/register", "", fmt.Sprintf(`{"email": "carol@example.com", "nickname": "carol", "password": "%s"}`, password))
+		}
+
+		rejected(t, register("short"), "at least 8 characters")
+		rejected(t, register("password123"), "breached")
+		rejected(t, register("i-am-carol-99"), "email or nickname")
+		assert.Equal(t, 200, register("blue-kettle-42").Code)
+	})
+
+	t.Run("Change password", func(t *testing.T) {
+		setup(t)
+		hash, _ := bcrypt.GenerateFromPassword([]byte("blue-kettle-42"), bcrypt.MinCost)
+		user := models.User{Nickname: "dave", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "dave@example.com", Identifier: string(hash)}
+		require.NoError(t, db.Create(&user).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+
+		rejected(t, post("/auth/change-password", token, `{"old_password": "blue-kettle-42", "new_password": "qwerty123"}`), "breached")
+		assert.Equal(t, 200, post("/auth/change-password", token, `{"old_password": "blue-kettle-42", "new_password": "green-teapot-7"}`).Code)
+	})
+
+	t.Run("Reset password", func(t *testing.T) {
+		setup(t)
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+
+		user := models.User{Nickname: "erin", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "erin@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&user).Error)
+		require.Equal(t, 200, post("/auth/forgot-password", "", `{"email": "erin@example.com"}`).Code)
+		token := lastMailedToken(t, mailPath)
+
+		rejected(t, post("/auth/reset-password", "", fmt.Sprintf(`{"token": "%s", "new_password": "erin-2024-x"}`, token)), "email or nickname")
+
+		// The rejected attempt did not use up the token
+		w := post("/auth/reset-password", "", fmt.Sprintf(`{"token": "%s", "new_password": "green-teapot-7"}`, token))
+		assert.Equal(t, 200, w.Code, w.Body.String())
+	})
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	authController "personal_site/controllers/auth"
+	"personal_site/mailer"
+	"personal_site/models"
+	"personal_site/passwordhash"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var mailTokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_\-\.]+)`)
+
+// lastMailedToken returns the token from the last email written by the file mailer
+func lastMailedToken(t *testing.T, path string) string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err, "An email should be written")
+	matches := mailTokenPattern.FindAllStringSubmatch(string(content), -1)
+	require.NotEmpty(t, matches, "Email should contain a token")
+	return matches[len(matches)-1][1]
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Run("Verify email after register", func(t *testing.T) {
+		setup(t)
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+
+		w_reg := httptest.NewRecorder()
+		req_reg, _ := http.NewRequest(http.MethodPost, "/auth/register",
+			strings.NewReader(`{
+				"email":"test-verify@example.com",
+				"password":"blue-kettle-42",
+				"nickname":"testuser"
+			}`))
+		router.ServeHTTP(w_reg, req_reg)
+		require.Equal(t, 200, w_reg.Code)
+
+		var user models.User
+		db.First(&user, "email = ?", "test-verify@example.com")
+		assert.False(t, user.EmailVerified, "Email should not be verified yet")
+
+		token := lastMailedToken(t, mailPath)
+		verify := func() int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/verify-email",
+				strings.NewReader(fmt.Sprintf(`{"token":"%s"}`, token)))
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		assert.Equal(t, 200, verify())
+		db.First(&user, user.ID)
+		assert.True(t, user.EmailVerified, "Email should be verified")
+
+		assert.Equal(t, 400, verify(), "Token should only work once")
+	})
+
+	t.Run("Domain sign-ups log in once their email is verified", func(t *testing.T) {
+		setup(t)
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+		// Made before domain mode was switched on, it keeps working without a verified email
+		hash, err := passwordhash.Default().Hash("blue-kettle-42")
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&models.User{Nickname: "erin", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "erin@example.com", Identifier: hash}).Error)
+		t.Setenv("REGISTRATION_MODE", "domain")
+		t.Setenv("REGISTRATION_EMAIL_DOMAINS", "example.com")
+
+		w := request(http.MethodPost, "/auth/register", "", `{"email":"dana@example.com","password":"blue-kettle-42","nickname":"dana"}`)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"email_verification_required":true`)
+		signupToken := lastMailedToken(t, mailPath)
+
+		login := func(email string) *httptest.ResponseRecorder {
+			return request(http.MethodPost, "/auth/login", "", fmt.Sprintf(`{"email":"%s","password":"blue-kettle-42"}`, email))
+		}
+		assert.Equal(t, 200, login("erin@example.com").Code)
+
+		w = login("dana@example.com")
+		require.Equal(t, 403, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "Email not verified")
+		assert.Empty(t, w.Result().Cookies(), "No session is started")
+		assert.Equal(t, signupToken, lastMailedToken(t, mailPath), "The link of the sign-up was sent moments ago, no new one is mailed")
+
+		// Once the last link is older than the resend interval, a refused login mails a new one
+		require.NoError(t, db.Model(&models.OneTimeToken{}).Where("purpose = ?", "verify_email").
+			UpdateColumn("created_at", time.Now().Add(-time.Hour)).Error)
+		assert.Equal(t, 403, login("dana@example.com").Code)
+		token := lastMailedToken(t, mailPath)
+		require.NotEqual(t, signupToken, token)
+		assert.Equal(t, 400, request(http.MethodPost, "/auth/verify-email", "", fmt.Sprintf(`{"token":"%s"}`, signupToken)).Code, "The new link replaces the old one")
+
+		require.Equal(t, 200, request(http.MethodPost, "/auth/verify-email", "", fmt.Sprintf(`{"token":"%s"}`, token)).Code)
+		assert.Equal(t, 200, login("dana@example.com").Code)
+	})
+
+	t.Run("A password reset verifies the email", func(t *testing.T) {
+		setup(t)
+		t.Setenv("REGISTRATION_MODE", "domain")
+		t.Setenv("REGISTRATION_EMAIL_DOMAINS", "example.com")
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+
+		require.Equal(t, 200, request(http.MethodPost, "/auth/register", "", `{"email":"fay@example.com","password":"blue-kettle-42","nickname":"fay"}`).Code)
+		require.Equal(t, 200, request(http.MethodPost, "/auth/forgot-password", "", `{"email":"fay@example.com"}`).Code)
+		token := lastMailedToken(t, mailPath)
+		require.Equal(t, 200, request(http.MethodPost, "/auth/reset-password", "", fmt.Sprintf(`{"token":"%s","new_password":"green-teapot-7"}`, token)).Code)
+
+		var user models.User
+		require.NoError(t, db.First(&user, "email = ?", "fay@example.com").Error)
+		assert.True(t, user.EmailVerified)
+		assert.Equal(t, 200, request(http.MethodPost, "/auth/login", "", `{"email":"fay@example.com","password":"green-teapot-7"}`).Code)
+	})
+
+	t.Run("Forgot and reset password", func(t *testing.T) {
+		setup(t)
+		mailPath := filepath.Join(t.TempDir(), "mail.log")
+		mailer.SetDefault(&mailer.FileMailer{Path: mailPath})
+		defer mailer.SetDefault(nil)
+
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+		testUser := models.User{
+			Nickname:   "testuser",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      "test-reset@example.com",
+			Identifier: string(hashedPassword),
+		}
+		db.Create(&testUser)
+		oldSession, _, _ := authController.StartSession(db, testUser, "", "")
+
+		// Unknown emails get the same answer and no email
+		w_unknown := httptest.NewRecorder()
+		req_unknown, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password",
+			strings.NewReader(`{"email":"nobody@example.com"}`))
+		router.ServeHTTP(w_unknown, req_unknown)
+		assert.Equal(t, 200, w_unknown.Code)
+		_, err := os.Stat(mailPath)
+		assert.True(t, os.IsNotExist(err), "No email should be sent for unknown accounts")
+
+		w_forgot := httptest.NewRecorder()
+		req_forgot, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password",
+			strings.NewReader(`{"email":"test-reset@example.com"}`))
+		router.ServeHTTP(w_forgot, req_forgot)
+		assert.Equal(t, 200, w_forgot.Code)
+
+		token := lastMailedToken(t, mailPath)
+
+		w_reset := httptest.NewRecorder()
+		req_reset, _ := http.NewRequest(http.MethodPost, "/auth/reset-password",
+			strings.NewReader(fmt.Sprintf(`{"token":"%s","new_password":"newpassword123"}`, token)))
+		router.ServeHTTP(w_reset, req_reset)
+		assert.Equal(t, 200, w_reset.Code)
+
+		var user models.User
+		db.First(&user, testUser.ID)
+		match, _ := passwordhash.Default().Verify("newpassword123", user.Identifier)
+		assert.True(t, match)
+
+		// Existing sessions are logged out
+		w_old := httptest.NewRecorder()
+		req_old, _ := http.NewRequest(http.MethodGet, "/auth/sessions", nil)
+		req_old.AddCookie(&http.Cookie{Name: "auth_token", Value: oldSession})
+		router.ServeHTTP(w_old, req_old)
+		assert.Equal(t, 401, w_old.Code)
+
+		// Token can not be reused
+		w_again := httptest.NewRecorder()
+		req_again, _ := http.NewRequest(http.MethodPost, "/auth/reset-password",
+			strings.NewReader(fmt.Sprintf(`{"token":"%s","new_password":"anotherpassword"}`, token)))
+		router.ServeHTTP(w_again, req_again)
+		assert.Equal(t, 400, w_again.Code)
+	})
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	storageController "personal_site/controllers/storage"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageQuota(t *testing.T) {
+	uploadFile := func(path, token, fileID, content string) *httptest.ResponseRecorder {
+		return uploadChunk(path, token, map[string]string{"file_id": fileID, "total_chunks": "1"}, 0, content)
+	}
+
+	type usage struct {
+		Data struct {
+			Used    int64  `json:"used"`
+			Pending int64  `json:"pending"`
+			Limit   *int64 `json:"limit"`
+		} `json:"data"`
+	}
+	getUsage := func(t *testing.T, token string) usage {
+		w := request(http.MethodGet, "/storage/usage", token, "")
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var result usage
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		return result
+	}
+
+	t.Run("Uploads beyond the quota are refused before they are written", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, token := createUser(t, "quinn", models.RoleUser)
+
+		assert.Equal(t, int64(10<<30), *getUsage(t, token).Data.Limit, "Users get the quota of their role")
+
+		userPath := fmt.Sprintf("/admin/users/%d", user.ID)
+		assert.Equal(t, 400, request(http.MethodPatch, userPath, adminToken, `{"storage_quota": "lots"}`).Code)
+		require.Equal(t, 200, request(http.MethodPatch, userPath, adminToken, `{"storage_quota": "10B"}`).Code)
+
+		require.Equal(t, 201, uploadFile("/storage/file/a.txt", token, "upload-a", "123456").Code)
+		current := getUsage(t, token)
+		assert.Equal(t, int64(6), current.Data.Used)
+		assert.Equal(t, int64(10), *current.Data.Limit)
+
+		w := uploadFile("/storage/file/b.txt", token, "upload-b", "123456")
+		require.Equal(t, 413, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "Storage quota exceeded")
+		root, err := storageController.GetStorageRoot()
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(root, "tmp", fmt.Sprint(user.ID), "upload-b"))
+		assert.True(t, os.IsNotExist(err), "No chunk is written")
+
+		// Resumable uploads reserve their whole length when they are created
+		headers := map[string]string{"Tus-Resumable": "1.0.0", "Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("c.txt"))}
+		headers["Upload-Length"] = "5"
+		assert.Equal(t, 413, requestWithHeaders(http.MethodPost, "/storage/uploads", token, headers, "").Code)
+		headers["Upload-Length"] = "4"
+		require.Equal(t, 201, requestWithHeaders(http.MethodPost, "/storage/uploads", token, headers, "").Code)
+		assert.Equal(t, int64(4), getUsage(t, token).Data.Pending)
+		assert.Equal(t, 413, uploadFile("/storage/file/d.txt", token, "upload-d", "1").Code)
+
+		// Deleting files frees the space right away
+		require.Equal(t, 200, request(http.MethodDelete, "/storage/file/a.txt", token, "").Code)
+		assert.Equal(t, int64(0), getUsage(t, token).Data.Used)
+		assert.Equal(t, 201, uploadFile("/storage/file/d.txt", token, "upload-d", "123456").Code)
+	})
+
+	t.Run("Admins set and reset quotas of single users", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, token := createUser(t, "uma", models.RoleUser)
+		userPath := fmt.Sprintf("/admin/users/%d", user.ID)
+
+		assert.Nil(t, getUsage(t, adminToken).Data.Limit, "Admins are unlimited by default")
+
+		require.Equal(t, 200, request(http.MethodPatch, userPath, adminToken, `{"storage_quota": "unlimited"}`).Code)
+		assert.Nil(t, getUsage(t, token).Data.Limit)
+		w := request(http.MethodGet, userPath, adminToken, "")
+		assert.Contains(t, w.Body.String(), `"storage_quota":-1`)
+
+		w = request(http.MethodPatch, userPath, adminToken, `{"storage_quota": "2 GB"}`)
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"storage_quota":2147483648`, "The response shows the new quota")
+		assert.Equal(t, int64(2<<30), *getUsage(t, token).Data.Limit)
+
+		w = request(http.MethodPatch, userPath, adminToken, `{"storage_quota": "default"}`)
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), `"storage_quota":null`)
+		assert.Equal(t, int64(10<<30), *getUsage(t, token).Data.Limit)
+	})
+}
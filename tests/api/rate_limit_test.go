@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginRateLimitAndLockout(t *testing.T) {
+	createUser := func(t *testing.T, email string, role models.Role) models.User {
+		hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		user := models.User{
+			Nickname:   "user",
+			Role:       role,
+			Provider:   models.AuthProviderPassword,
+			Email:      email,
+			Identifier: string(hashedPassword),
+		}
+		require.NoError(t, db.Create(&user).Error)
+		return user
+	}
+
+	login := func(email, password, ip string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password)
+		req, _ := http.NewRequest(http.MethodPost, "/auth/login", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ip != "" {
+			req.RemoteAddr = ip + ":12345"
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Account locks after repeated failures", func(t *testing.T) {
+		setup(t)
+		createUser(t, "lock@example.com", models.RoleUser)
+
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, 401, login("lock@example.com", "wrongpassword", "").Code)
+		}
+
+		// Even the correct password is refused while locked
+		w := login("lock@example.com", "password123", "")
+		assert.Equal(t, 429, w.Code)
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		require.NoError(t, err)
+		assert.Greater(t, retryAfter, 0)
+
+		var user models.User
+		db.First(&user, "email = ?", "lock@example.com")
+		assert.NotNil(t, user.LockedUntil)
+	})
+
+	t.Run("Successful login resets the failure count", func(t *testing.T) {
+		setup(t)
+		createUser(t, "reset@example.com", models.RoleUser)
+
+		for i := 0; i < 4; i++ {
+			login("reset@example.com", "wrongpassword", "")
+		}
+		assert.Equal(t, 200, login("reset@example.com", "password123", "").Code)
+
+		var user models.User
+		db.First(&user, "email = ?", "reset@example.com")
+		assert.Equal(t, 0, user.FailedLoginCount)
+		assert.Equal(t, 401, login("reset@example.com", "wrongpassword", "").Code, "Count should start over")
+	})
+
+	t.Run("Requests from one IP are limited", func(t *testing.T) {
+		setup(t)
+
+		for i := 0; i < 20; i++ {
+			w := login(fmt.Sprintf("nobody%d@example.com", i), "wrongpassword", "203.0.113.9")
+			require.Equal(t, 401, w.Code)
+		}
+
+		w := login("nobody-else@example.com", "wrongpassword", "203.0.113.9")
+		assert.Equal(t, 429, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		assert.Equal(t, 401, login("nobody-else@example.com", "wrongpassword", "203.0.113.10").Code, "Other IPs are not affected")
+	})
+
+	t.Run("Requests for one email are limited across IPs", func(t *testing.T) {
+		setup(t)
+
+		for i := 0; i < 10; i++ {
+			w := login("target@example.com", "wrongpassword", fmt.Sprintf("198.51.100.%d", i))
+			require.Equal(t, 401, w.Code)
+		}
+		assert.Equal(t, 429, login("target@example.com", "wrongpassword", "198.51.100.99").Code)
+	})
+
+	t.Run("Password reset requests are limited", func(t *testing.T) {
+		setup(t)
+		createUser(t, "target@example.com", models.RoleUser)
+		forgotPassword := func(email, ip string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/auth/forgot-password", bytes.NewBufferString(fmt.Sprintf(`{"email": "%s"}`, email)))
+			req.Header.Set("Content-Type", "application/json")
+			req.RemoteAddr = ip + ":12345"
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		for i := 0; i < 10; i++ {
+			require.Equal(t, 200, forgotPassword("target@example.com", fmt.Sprintf("198.51.100.%d", i)).Code)
+		}
+		w := forgotPassword("target@example.com", "198.51.100.99")
+		assert.Equal(t, 429, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+		for i := 0; i < 20; i++ {
+			require.Equal(t, 200, forgotPassword(fmt.Sprintf("nobody%d@example.com", i), "203.0.113.9").Code)
+		}
+		assert.Equal(t, 429, forgotPassword("nobody-else@example.com", "203.0.113.9").Code)
+	})
+
+	t.Run("Admin unlocks an account", func(t *testing.T) {
+		setup(t)
+		user := createUser(t, "locked@example.com", models.RoleUser)
+		admin := createUser(t, "admin@example.com", models.RoleAdmin)
+		for i := 0; i < 5; i++ {
+			login("locked@example.com", "wrongpassword", "")
+		}
+		require.Equal(t, 429, login("locked@example.com", "password123", "").Code)
+
+		unlock := func(token string) int {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("/admin/users/%d/unlock", user.ID), nil)
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+			router.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		userToken, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, 403, unlock(userToken), "Only admins can unlock")
+
+		adminToken, _, err := authController.StartSession(db, admin, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, 200, unlock(adminToken))
+
+		assert.Equal(t, 200, login("locked@example.com", "password123", "").Code)
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	createUserWithSessions := func(t *testing.T) (string, string) {
+		testUser := models.User{
+			Nickname:   "testuser",
+			Role:       models.RoleUser,
+			Provider:   models.AuthProviderPassword,
+			Email:      "test-sessions@example.com",
+			Identifier: "not-a-password",
+		}
+		db.Create(&testUser)
+
+		laptopToken, _, err := authController.StartSession(db, testUser, "laptop", "10.0.0.1")
+		require.NoError(t, err)
+		phoneToken, _, err := authController.StartSession(db, testUser, "phone", "10.0.0.2")
+		require.NoError(t, err)
+		return laptopToken, phoneToken
+	}
+
+	listSessions := func(token string) (int, []map[string]any) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/auth/sessions", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		router.ServeHTTP(w, req)
+
+		var data struct {
+			Data []map[string]any `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &data)
+		return w.Code, data.Data
+	}
+
+	t.Run("List sessions", func(t *testing.T) {
+		setup(t)
+		laptopToken, _ := createUserWithSessions(t)
+
+		code, sessions := listSessions(laptopToken)
+		assert.Equal(t, 200, code)
+		require.Len(t, sessions, 2)
+
+		current := 0
+		for _, s := range sessions {
+			if s["current"] == true {
+				current++
+				assert.Equal(t, "laptop", s["user_agent"], "Current session should be the caller's")
+				assert.Equal(t, "10.0.0.1", s["ip"])
+			}
+		}
+		assert.Equal(t, 1, current, "Exactly one session should be marked current")
+	})
+
+	t.Run("Revoke one session", func(t *testing.T) {
+		setup(t)
+		laptopToken, phoneToken := createUserWithSessions(t)
+
+		var phone models.Session
+		db.Where("user_agent = ?", "phone").First(&phone)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodDelete, fmt.Sprintf("/auth/sessions/%d", phone.ID), nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: laptopToken})
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		code, _ := listSessions(phoneToken)
+		assert.Equal(t, 401, code, "Revoked session should be rejected")
+
+		code, sessions := listSessions(laptopToken)
+		assert.Equal(t, 200, code)
+		assert.Len(t, sessions, 1)
+	})
+
+	t.Run("Revoke all sessions", func(t *testing.T) {
+		setup(t)
+		laptopToken, phoneToken := createUserWithSessions(t)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodDelete, "/auth/sessions?except_current=true", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: laptopToken})
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		code, _ := listSessions(phoneToken)
+		assert.Equal(t, 401, code, "Other sessions should be revoked")
+		code, _ = listSessions(laptopToken)
+		assert.Equal(t, 200, code, "Current session should be kept")
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodDelete, "/auth/sessions", nil)
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: laptopToken})
+		router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		code, _ = listSessions(laptopToken)
+		assert.Equal(t, 401, code, "All sessions should be revoked")
+	})
+}
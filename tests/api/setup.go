@@ -1,11 +1,25 @@
 package api
 
 import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	authController "personal_site/controllers/auth"
+	storageController "personal_site/controllers/storage"
 	"personal_site/database"
+	"personal_site/models"
+	"personal_site/ratelimit"
 	"personal_site/routers"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
@@ -15,8 +29,11 @@ var db *gorm.DB
 func setup(t *testing.T) {
 	t.Setenv("DATABASE_DSN", ":memory:")
 	t.Setenv("JWT_SECRET_KEY", "testsecretkey")
-	t.Setenv("DEFAULT_TOKEN_EXPIRATION", "12h")
+	t.Setenv("ACCESS_TOKEN_EXPIRATION", "15m")
+	t.Setenv("REFRESH_TOKEN_EXPIRATION", "720h")
 	t.Setenv("YT_DATA_API_TOKEN", "YT_DATA_API_TOKEN")
+	// Every test gets an empty storage, removed when it ends
+	t.Setenv("STORAGE_ROOT", t.TempDir())
 
 	var err error
 	db, err = database.InitDB()
@@ -24,6 +41,93 @@ func setup(t *testing.T) {
 		panic(err)
 	}
 
+	// Fresh rate limit buckets, every test request comes from the same IP
+	ratelimit.SetDefault(ratelimit.NewMemoryStore())
+
 	router = gin.Default()
 	routers.RegisterRouters(router, db)
 }
+
+// createUser creates a password user with the role and starts a session, returning the user and its access token
+func createUser(t *testing.T, nickname string, role models.Role) (models.User, string) {
+	user := models.User{Nickname: nickname, Role: role, Provider: models.AuthProviderPassword, Email: nickname + "@example.com", Identifier: "not-a-password"}
+	require.NoError(t, db.Create(&user).Error)
+	token, _, err := authController.StartSession(db, user, "", "")
+	require.NoError(t, err)
+	return user, token
+}
+
+// request sends a request with the auth_token cookie, or without when token is empty
+func request(method, path, token, body string) *httptest.ResponseRecorder {
+	return requestWithHeaders(method, path, token, nil, body)
+}
+
+func requestWithHeaders(method, path, token string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	if token != "" {
+		req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// uploadChunk posts one chunk of a chunked upload to POST /storage/file/*file_path. fields holds the form
+// fields besides chunk_index and chunk_data, e.g. file_id and total_chunks.
+func uploadChunk(path, token string, fields map[string]string, index int, data string) *httptest.ResponseRecorder {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, value := range fields {
+		_ = writer.WriteField(key, value)
+	}
+	_ = writer.WriteField("chunk_index", strconv.Itoa(index))
+	part, _ := writer.CreateFormFile("chunk_data", "blob")
+	_, _ = part.Write([]byte(data))
+	_ = writer.Close()
+	return requestWithHeaders(http.MethodPost, path, token, map[string]string{"Content-Type": writer.FormDataContentType()}, body.String())
+}
+
+// storagePath returns where a file of the user's storage lives on disk
+func storagePath(t *testing.T, user models.User, path string) string {
+	root, err := storageController.GetStorageRoot()
+	require.NoError(t, err)
+	return filepath.Join(root, "data", fmt.Sprint(user.ID), user.StorageDir, filepath.FromSlash(path))
+}
+
+// startOAuthLogin calls an OAuth login start endpoint and returns the provider URL it redirects to
+// together with the oauth_state cookie the callback needs
+func startOAuthLogin(t *testing.T, path string, cookies ...*http.Cookie) (*url.URL, *http.Cookie) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	router.ServeHTTP(w, req)
+	require.Equal(t, 302, w.Code, w.Body.String())
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+
+	var stateCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "oauth_state" {
+			stateCookie = cookie
+		}
+	}
+	require.NotNil(t, stateCookie, "oauth_state cookie should be set")
+	return location, stateCookie
+}
+
+// oauthCallback calls an OAuth callback endpoint with the given code, state and oauth_state cookie
+func oauthCallback(path, code, state string, stateCookie *http.Cookie) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, path+"?code="+url.QueryEscape(code)+"&state="+url.QueryEscape(state), nil)
+	if stateCookie != nil {
+		req.AddCookie(stateCookie)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
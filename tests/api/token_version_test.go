@@ -0,0 +1,77 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"personal_site/schemas"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenVersion(t *testing.T) {
+	authCookie := func(w *httptest.ResponseRecorder) *http.Cookie {
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "auth_token" {
+				return cookie
+			}
+		}
+		return nil
+	}
+
+	t.Run("Role change applies immediately and re-issues the token", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		user, token := createUser(t, "bob", models.RoleUser)
+
+		assert.Equal(t, 403, request(http.MethodGet, "/admin/users", token, "").Code)
+		require.Equal(t, 200, request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", user.ID), adminToken, `{"role": "admin"}`).Code)
+
+		w := request(http.MethodGet, "/admin/users", token, "")
+		require.Equal(t, 200, w.Code, "The old token should already act with the new role")
+
+		cookie := authCookie(w)
+		require.NotNil(t, cookie, "A token with stale claims should be re-issued")
+		parsed, err := authController.ValidateToken(cookie.Value)
+		require.NoError(t, err)
+		claims := parsed.Claims.(*schemas.TokenClaims)
+		assert.Equal(t, "admin", claims.Payload.Role)
+
+		w = request(http.MethodGet, "/admin/users", cookie.Value, "")
+		require.Equal(t, 200, w.Code)
+		assert.Nil(t, authCookie(w), "A current token is not re-issued")
+	})
+
+	t.Run("Demoted users lose access with their old token", func(t *testing.T) {
+		setup(t)
+		_, adminToken := createUser(t, "admin", models.RoleAdmin)
+		other, otherToken := createUser(t, "other", models.RoleAdmin)
+
+		require.Equal(t, 200, request(http.MethodPatch, fmt.Sprintf("/admin/users/%d", other.ID), adminToken, `{"role": "user"}`).Code)
+		assert.Equal(t, 403, request(http.MethodGet, "/admin/users", otherToken, "").Code)
+	})
+
+	t.Run("Renaming keeps the storage root", func(t *testing.T) {
+		setup(t)
+		user, token := createUser(t, "carol", models.RoleUser)
+		assert.Equal(t, "carol", user.StorageDir)
+
+		require.Equal(t, 200, request(http.MethodPost, "/storage/folder/photos", token, "").Code)
+		assert.DirExists(t, storagePath(t, user, "photos"))
+
+		w := request(http.MethodPatch, "/auth/me", token, `{"nickname": "Caroline"}`)
+		require.Equal(t, 200, w.Code)
+
+		db.First(&user, user.ID)
+		assert.Equal(t, "Caroline", user.Nickname)
+		assert.Equal(t, "carol", user.StorageDir, "Storage root should not follow the nickname")
+
+		w = request(http.MethodGet, "/storage/folder/", token, "")
+		require.Equal(t, 200, w.Code)
+		assert.Contains(t, w.Body.String(), "photos")
+	})
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	storageController "personal_site/controllers/storage"
+	"personal_site/models"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumableUpload(t *testing.T) {
+	var token string
+	// tusRequest sends a request of the tus protocol, headers may override Tus-Resumable
+	tusRequest := func(method, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+		all := map[string]string{"Tus-Resumable": "1.0.0"}
+		for key, value := range headers {
+			all[key] = value
+		}
+		return requestWithHeaders(method, path, token, all, body)
+	}
+	patch := func(location, offset, body string) *httptest.ResponseRecorder {
+		return tusRequest(http.MethodPatch, location, map[string]string{"Upload-Offset": offset, "Content-Type": "application/offset+octet-stream"}, body)
+	}
+
+	setupUser := func(t *testing.T) models.User {
+		setup(t)
+		user, userToken := createUser(t, "uploader", models.RoleUser)
+		token = userToken
+		return user
+	}
+
+	storedFile := func(t *testing.T, user models.User, path string) string {
+		content, err := os.ReadFile(storagePath(t, user, path))
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	metadata := "path " + base64.StdEncoding.EncodeToString([]byte("docs/notes.txt"))
+
+	t.Run("Uploads resume from the offset the server reports", func(t *testing.T) {
+		user := setupUser(t)
+
+		w := tusRequest(http.MethodOptions, "/storage/uploads", nil, "")
+		require.Equal(t, 204, w.Code)
+		assert.Contains(t, w.Header().Get("Tus-Extension"), "creation")
+
+		w = tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Upload-Length": "11", "Upload-Metadata": metadata}, "")
+		require.Equal(t, 201, w.Code, w.Body.String())
+		location := w.Header().Get("Location")
+		require.True(t, strings.HasPrefix(location, "/storage/uploads/"), location)
+		assert.NotEmpty(t, w.Header().Get("Upload-Expires"))
+
+		w = patch(location, "0", "hello")
+		require.Equal(t, 204, w.Code, w.Body.String())
+		assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+
+		// After a dropped connection the client asks where to continue
+		w = tusRequest(http.MethodHead, location, nil, "")
+		require.Equal(t, 200, w.Code)
+		assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+		assert.Equal(t, "11", w.Header().Get("Upload-Length"))
+		assert.Equal(t, metadata, w.Header().Get("Upload-Metadata"))
+		assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+
+		w = patch(location, "0", "hello")
+		assert.Equal(t, 409, w.Code, "A stale offset is refused")
+		assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+
+		assert.Equal(t, 413, patch(location, "5", " world and more").Code)
+		require.Equal(t, 204, patch(location, "5", " world").Code)
+
+		assert.Equal(t, "hello world", storedFile(t, user, "docs/notes.txt"))
+		assert.Equal(t, 404, tusRequest(http.MethodHead, location, nil, "").Code, "Finished uploads are gone")
+	})
+
+	t.Run("Protocol errors", func(t *testing.T) {
+		setupUser(t)
+
+		w := tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Tus-Resumable": "0.2.2", "Upload-Length": "3", "Upload-Metadata": metadata}, "")
+		assert.Equal(t, 412, w.Code)
+		assert.Equal(t, "1.0.0", w.Header().Get("Tus-Version"))
+		assert.Equal(t, 400, tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Upload-Metadata": metadata}, "").Code)
+		assert.Equal(t, 400, tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Upload-Length": "3"}, "").Code)
+
+		w = tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Upload-Length": "3", "Upload-Metadata": metadata}, "")
+		require.Equal(t, 201, w.Code)
+		location := w.Header().Get("Location")
+		assert.Equal(t, 415, tusRequest(http.MethodPatch, location, map[string]string{"Upload-Offset": "0"}, "abc").Code)
+
+		require.Equal(t, 204, tusRequest(http.MethodDelete, location, nil, "").Code)
+		assert.Equal(t, 404, patch(location, "0", "abc").Code)
+		assert.Equal(t, 404, tusRequest(http.MethodHead, "/storage/uploads/not-an-id", nil, "").Code)
+	})
+
+	t.Run("Uploads being written are not cleared", func(t *testing.T) {
+		user := setupUser(t)
+
+		w := tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Upload-Length": "11", "Upload-Metadata": metadata}, "")
+		require.Equal(t, 201, w.Code, w.Body.String())
+		location := w.Header().Get("Location")
+		root, err := storageController.GetStorageRoot()
+		require.NoError(t, err)
+		tmpDir := filepath.Join(root, "tmp", fmt.Sprint(user.ID), path.Base(location))
+
+		// The body is streamed so the PATCH is still writing while the cleaner runs
+		body, bodyWriter := io.Pipe()
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPatch, location, body)
+			req.Header.Set("Tus-Resumable", "1.0.0")
+			req.Header.Set("Upload-Offset", "0")
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+			router.ServeHTTP(w, req)
+			done <- w
+		}()
+		_, err = bodyWriter.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		removed, err := storageController.RemoveIdleUpload(tmpDir)
+		require.NoError(t, err)
+		assert.False(t, removed)
+		assert.DirExists(t, tmpDir)
+
+		require.NoError(t, bodyWriter.Close())
+		w = <-done
+		require.Equal(t, 204, w.Code, w.Body.String())
+		assert.Equal(t, "5", w.Header().Get("Upload-Offset"))
+
+		removed, err = storageController.RemoveIdleUpload(tmpDir)
+		require.NoError(t, err)
+		assert.True(t, removed)
+		assert.NoDirExists(t, tmpDir)
+		assert.Equal(t, 404, tusRequest(http.MethodHead, location, nil, "").Code)
+	})
+
+	t.Run("Empty files are complete on creation", func(t *testing.T) {
+		user := setupUser(t)
+
+		w := tusRequest(http.MethodPost, "/storage/uploads", map[string]string{"Upload-Length": "0", "Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("../empty.txt"))}, "")
+		require.Equal(t, 201, w.Code, w.Body.String())
+		assert.Equal(t, "", storedFile(t, user, "empty.txt"), "The destination stays inside the user's storage")
+	})
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	authController "personal_site/controllers/auth"
+	"personal_site/models"
+	"personal_site/webauthn/webauthntest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasskeys(t *testing.T) {
+	const origin = "https://example.com"
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	request := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		payload, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// begin calls a */begin endpoint and returns the publicKey options
+	begin := func(t *testing.T, path, token string, body any) map[string]any {
+		w := request(http.MethodPost, path, token, body)
+		require.Equal(t, 200, w.Code, w.Body.String())
+		var data struct {
+			PublicKey map[string]any `json:"publicKey"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &data))
+		return data.PublicKey
+	}
+
+	registration := func(a *webauthntest.Authenticator, challenge string) map[string]any {
+		clientData, attestation := a.Register("example.com", origin, challenge)
+		return map[string]any{
+			"name": "My key",
+			"credential": map[string]any{
+				"id":   b64(a.CredentialID),
+				"type": "public-key",
+				"response": map[string]any{
+					"clientDataJSON":    b64(clientData),
+					"attestationObject": b64(attestation),
+				},
+			},
+		}
+	}
+
+	assertion := func(a *webauthntest.Authenticator, challenge string) map[string]any {
+		clientData, authData, sig := a.Assert("example.com", origin, challenge)
+		return map[string]any{
+			"credential": map[string]any{
+				"id":   b64(a.CredentialID),
+				"type": "public-key",
+				"response": map[string]any{
+					"clientDataJSON":    b64(clientData),
+					"authenticatorData": b64(authData),
+					"signature":         b64(sig),
+				},
+			},
+		}
+	}
+
+	hasAuthCookie := func(w *httptest.ResponseRecorder) bool {
+		for _, cookie := range w.Result().Cookies() {
+			if cookie.Name == "auth_token" && cookie.Value != "" {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Setenv("WEBAUTHN_ORIGINS", origin)
+
+	t.Run("Sign up and login with a passkey", func(t *testing.T) {
+		setup(t)
+		authenticator := webauthntest.NewAuthenticator()
+
+		options := begin(t, "/auth/webauthn/signup/begin", "", map[string]any{"email": "passkey@example.com", "nickname": "passkey"})
+		assert.Equal(t, "example.com", options["rp"].(map[string]any)["id"])
+		assert.Equal(t, "none", options["attestation"])
+
+		w := request(http.MethodPost, "/auth/webauthn/signup/finish", "", registration(authenticator, options["challenge"].(string)))
+		require.Equal(t, 201, w.Code, w.Body.String())
+		assert.True(t, hasAuthCookie(w))
+
+		var user models.User
+		require.NoError(t, db.Where("email = ?", "passkey@example.com").First(&user).Error)
+		assert.Equal(t, models.AuthProviderPasskey, user.Provider)
+		assert.Equal(t, models.RoleUser, user.Role)
+
+		// The challenge was used up
+		w = request(http.MethodPost, "/auth/webauthn/signup/finish", "", registration(authenticator, options["challenge"].(string)))
+		assert.Equal(t, 400, w.Code, w.Body.String())
+
+		w = request(http.MethodPost, "/auth/webauthn/signup/begin", "", map[string]any{"email": "passkey@example.com", "nickname": "again"})
+		assert.Equal(t, 409, w.Code, w.Body.String())
+
+		// Discoverable login without an email
+		options = begin(t, "/auth/webauthn/login/begin", "", nil)
+		assert.Empty(t, options["allowCredentials"])
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(authenticator, options["challenge"].(string)))
+		require.Equal(t, 200, w.Code, w.Body.String())
+		assert.True(t, hasAuthCookie(w))
+		assert.Contains(t, w.Body.String(), "Login successful")
+
+		var cred models.WebAuthnCredential
+		require.NoError(t, db.Where("user_id = ?", user.ID).First(&cred).Error)
+		assert.Equal(t, uint32(1), cred.SignCount)
+		assert.NotNil(t, cred.LastUsedAt)
+
+		// Email login lists the user's credentials
+		options = begin(t, "/auth/webauthn/login/begin", "", map[string]any{"email": "passkey@example.com"})
+		assert.Equal(t, []any{map[string]any{"type": "public-key", "id": cred.CredentialID}}, options["allowCredentials"])
+	})
+
+	t.Run("Rejected assertions", func(t *testing.T) {
+		setup(t)
+		authenticator := webauthntest.NewAuthenticator()
+		options := begin(t, "/auth/webauthn/signup/begin", "", map[string]any{"email": "replay@example.com", "nickname": "replay"})
+		w := request(http.MethodPost, "/auth/webauthn/signup/finish", "", registration(authenticator, options["challenge"].(string)))
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		options = begin(t, "/auth/webauthn/login/begin", "", nil)
+		body := assertion(authenticator, options["challenge"].(string))
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", body)
+		require.Equal(t, 200, w.Code, w.Body.String())
+
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", body)
+		assert.Equal(t, 401, w.Code, "A challenge can only be answered once")
+
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(authenticator, "never-issued"))
+		assert.Equal(t, 401, w.Code, "Unknown challenges are rejected")
+
+		// An authenticator whose counter goes back looks like a clone
+		options = begin(t, "/auth/webauthn/login/begin", "", nil)
+		authenticator.SignCount = 0
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(authenticator, options["challenge"].(string)))
+		assert.Equal(t, 401, w.Code, w.Body.String())
+
+		// Another key claiming the same credential id
+		options = begin(t, "/auth/webauthn/login/begin", "", nil)
+		impostor := webauthntest.NewAuthenticator()
+		impostor.CredentialID = authenticator.CredentialID
+		impostor.SignCount = 10
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(impostor, options["challenge"].(string)))
+		assert.Equal(t, 401, w.Code, w.Body.String())
+
+		// Disabled accounts can not log in
+		require.NoError(t, db.Model(&models.User{}).Where("email = ?", "replay@example.com").UpdateColumn("disabled", true).Error)
+		options = begin(t, "/auth/webauthn/login/begin", "", nil)
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(authenticator, options["challenge"].(string)))
+		assert.Equal(t, 403, w.Code, w.Body.String())
+	})
+
+	t.Run("Passkeys without user verification do not replace the second factor", func(t *testing.T) {
+		setup(t)
+		user := models.User{Nickname: "tess", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "tess@example.com", Identifier: "not-a-password", TOTPEnabled: true}
+		require.NoError(t, db.Create(&user).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+
+		options := begin(t, "/auth/webauthn/register/begin", token, nil)
+		assert.Equal(t, "required", options["authenticatorSelection"].(map[string]any)["userVerification"])
+		noPIN := webauthntest.NewAuthenticator()
+		noPIN.NoUserVerification = true
+		w := request(http.MethodPost, "/auth/webauthn/register/finish", token, registration(noPIN, options["challenge"].(string)))
+		assert.Equal(t, 400, w.Code, "A key that does not verify the user can not be added")
+
+		// A key registered with a PIN whose assertion comes without one
+		key := webauthntest.NewAuthenticator()
+		options = begin(t, "/auth/webauthn/register/begin", token, nil)
+		w = request(http.MethodPost, "/auth/webauthn/register/finish", token, registration(key, options["challenge"].(string)))
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		key.NoUserVerification = true
+		options = begin(t, "/auth/webauthn/login/begin", "", map[string]any{"email": "tess@example.com"})
+		assert.Equal(t, "required", options["userVerification"])
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(key, options["challenge"].(string)))
+		assert.Equal(t, 401, w.Code, w.Body.String())
+		assert.False(t, hasAuthCookie(w), "No session is started")
+	})
+
+	t.Run("Add, list and delete passkeys of an existing account", func(t *testing.T) {
+		setup(t)
+		user := models.User{Nickname: "alice", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "alice@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&user).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+
+		w := request(http.MethodPost, "/auth/webauthn/register/begin", "", nil)
+		assert.Equal(t, 401, w.Code)
+
+		first := webauthntest.NewAuthenticator()
+		options := begin(t, "/auth/webauthn/register/begin", token, nil)
+		w = request(http.MethodPost, "/auth/webauthn/register/finish", token, registration(first, options["challenge"].(string)))
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		// The second ceremony excludes the first key and reuses the user handle
+		userHandle := options["user"].(map[string]any)["id"]
+		options = begin(t, "/auth/webauthn/register/begin", token, nil)
+		assert.Equal(t, userHandle, options["user"].(map[string]any)["id"])
+		assert.Len(t, options["excludeCredentials"], 1)
+
+		w = request(http.MethodPost, "/auth/webauthn/register/finish", token, registration(first, options["challenge"].(string)))
+		assert.Equal(t, 409, w.Code, "The same credential can not be added twice")
+
+		// A registration challenge of another user can not be used
+		bob := models.User{Nickname: "bob", Role: models.RoleUser, Provider: models.AuthProviderPassword, Email: "bob@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&bob).Error)
+		bobToken, _, err := authController.StartSession(db, bob, "", "")
+		require.NoError(t, err)
+		options = begin(t, "/auth/webauthn/register/begin", token, nil)
+		w = request(http.MethodPost, "/auth/webauthn/register/finish", bobToken, registration(webauthntest.NewAuthenticator(), options["challenge"].(string)))
+		assert.Equal(t, 400, w.Code, w.Body.String())
+
+		w = request(http.MethodGet, "/auth/webauthn/credentials", token, nil)
+		require.Equal(t, 200, w.Code)
+		var list struct {
+			Data []struct {
+				ID   uint   `json:"id"`
+				Name string `json:"name"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		require.Len(t, list.Data, 1)
+		assert.Equal(t, "My key", list.Data[0].Name)
+		assert.NotContains(t, w.Body.String(), "public_key")
+
+		// The passkey logs into the existing account
+		options = begin(t, "/auth/webauthn/login/begin", "", map[string]any{"email": "alice@example.com"})
+		w = request(http.MethodPost, "/auth/webauthn/login/finish", "", assertion(first, options["challenge"].(string)))
+		require.Equal(t, 200, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"user_id":%d`, user.ID))
+
+		w = request(http.MethodDelete, fmt.Sprintf("/auth/webauthn/credentials/%d", list.Data[0].ID), bobToken, nil)
+		assert.Equal(t, 404, w.Code, "Passkeys of other users can not be deleted")
+		w = request(http.MethodDelete, fmt.Sprintf("/auth/webauthn/credentials/%d", list.Data[0].ID), token, nil)
+		assert.Equal(t, 200, w.Code, w.Body.String())
+	})
+
+	t.Run("Passkey-only accounts keep their last passkey", func(t *testing.T) {
+		setup(t)
+		authenticator := webauthntest.NewAuthenticator()
+		options := begin(t, "/auth/webauthn/signup/begin", "", map[string]any{"email": "only@example.com", "nickname": "only"})
+		w := request(http.MethodPost, "/auth/webauthn/signup/finish", "", registration(authenticator, options["challenge"].(string)))
+		require.Equal(t, 201, w.Code, w.Body.String())
+
+		var user models.User
+		require.NoError(t, db.Where("email = ?", "only@example.com").First(&user).Error)
+		token, _, err := authController.StartSession(db, user, "", "")
+		require.NoError(t, err)
+
+		var cred models.WebAuthnCredential
+		require.NoError(t, db.Where("user_id = ?", user.ID).First(&cred).Error)
+		w = request(http.MethodDelete, fmt.Sprintf("/auth/webauthn/credentials/%d", cred.ID), token, nil)
+		assert.Equal(t, 409, w.Code, w.Body.String())
+
+		// The user handle of the account is kept for new passkeys
+		options = begin(t, "/auth/webauthn/register/begin", token, nil)
+		assert.Equal(t, user.Identifier, options["user"].(map[string]any)["id"])
+	})
+
+	t.Run("Sign up with an invite", func(t *testing.T) {
+		setup(t)
+		admin := models.User{Nickname: "admin", Role: models.RoleAdmin, Provider: models.AuthProviderPassword, Email: "admin@example.com", Identifier: "not-a-password"}
+		require.NoError(t, db.Create(&admin).Error)
+		adminToken, _, err := authController.StartSession(db, admin, "", "")
+		require.NoError(t, err)
+		w := request(http.MethodPost, "/admin/invites", adminToken, map[string]any{"role": "guest"})
+		require.Equal(t, 201, w.Code, w.Body.String())
+		var invite struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &invite))
+
+		w = request(http.MethodPost, "/auth/webauthn/signup/begin", "", map[string]any{"email": "invited@example.com", "nickname": "invited", "invite_code": "wrong"})
+		assert.Equal(t, 403, w.Code, w.Body.String())
+
+		authenticator := webauthntest.NewAuthenticator()
+		options := begin(t, "/auth/webauthn/signup/begin", "", map[string]any{"email": "invited@example.com", "nickname": "invited", "invite_code": invite.Code})
+		w = request(http.MethodPost, "/auth/webauthn/signup/finish", "", registration(authenticator, options["challenge"].(string)))
+		require.Equal(t, 201, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"role":"guest"`)
+
+		// The invite is used up
+		w = request(http.MethodPost, "/auth/webauthn/signup/begin", "", map[string]any{"email": "second@example.com", "nickname": "second", "invite_code": invite.Code})
+		assert.Equal(t, 403, w.Code, w.Body.String())
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"net/url"
 	authController "personal_site/controllers/auth"
 	"personal_site/models"
-	"personal_site/schemas"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -98,11 +97,7 @@ func TestGetYTDataAPIToken(t *testing.T) {
 		db.Create(&testUser)
 
 		// Generate token for the test user
-		fakeToken, _ := authController.GenerateToken(schemas.TokenPayload{
-			UserID:   testUser.ID,
-			Role:     string(testUser.Role),
-			Nickname: testUser.Nickname,
-		}, testUser.ID)
+		fakeToken, _, _ := authController.StartSession(db, testUser, "", "")
 
 		// Build URL with query parameters
 		params := url.Values{}
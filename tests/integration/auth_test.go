@@ -17,7 +17,8 @@ var router *gin.Engine
 func setup(t *testing.T) {
 	t.Setenv("DATABASE_DSN", ":memory:")
 	t.Setenv("JWT_SECRET_KEY", "testsecretkey")
-	t.Setenv("DEFAULT_TOKEN_EXPIRATION", "12h")
+	t.Setenv("ACCESS_TOKEN_EXPIRATION", "15m")
+	t.Setenv("REFRESH_TOKEN_EXPIRATION", "720h")
 
 	db, err := database.InitDB()
 	if err != nil {
@@ -37,7 +38,7 @@ func TestAuth(t *testing.T) {
 		req_reg, _ := http.NewRequest(http.MethodPost, "/auth/register",
 			strings.NewReader(`{
 				"email":"test@example.com", 
-				"password":"password123",
+				"password":"blue-kettle-42",
 				"nickname":"testuser"
 			}`))
 
@@ -50,7 +51,7 @@ func TestAuth(t *testing.T) {
 		req_login, _ := http.NewRequest(http.MethodPost, "/auth/login",
 			strings.NewReader(`{
 				"email":"test@example.com",
-				"password":"password123"
+				"password":"blue-kettle-42"
 			}`))
 
 		router.ServeHTTP(w_login, req_login)
@@ -73,7 +74,7 @@ func TestAuth(t *testing.T) {
 		w_change := httptest.NewRecorder()
 		req_change, _ := http.NewRequest(http.MethodPost, "/auth/change-password",
 			strings.NewReader(`{
-				"old_password":"password123",
+				"old_password":"blue-kettle-42",
 				"new_password":"newpassword123"
 			}`))
 		// Set the auth_token cookie instead of Authorization header
@@ -88,7 +89,7 @@ func TestAuth(t *testing.T) {
 		req_oldPassword, _ := http.NewRequest(http.MethodPost, "/auth/login",
 			strings.NewReader(`{
 				"email":"test@example.com",
-				"password":"password123"
+				"password":"blue-kettle-42"
 			}`))
 		router.ServeHTTP(w_oldPassword, req_oldPassword)
 
@@ -0,0 +1,116 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the supported credential keys
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// SupportedAlgorithms lists the algorithms offered in pubKeyCredParams, most preferred first
+var SupportedAlgorithms = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key parameters (RFC 9053)
+const (
+	coseKeyKty = 1
+	coseKeyAlg = 3
+	coseKeyCrv = -1 // EC2 / OKP curve
+	coseKeyN   = -1 // RSA modulus
+	coseKeyX   = -2 // EC2 / OKP x
+	coseKeyE   = -2 // RSA exponent
+	coseKeyY   = -3 // EC2 y
+
+	coseKtyOKP = 1
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+// ParsePublicKey decodes a COSE_Key and returns its algorithm and public key
+func ParsePublicKey(coseKey []byte) (int64, crypto.PublicKey, error) {
+	item, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(rest) != 0 {
+		return 0, nil, errors.New("cose: trailing data after key")
+	}
+	m, ok := item.(map[any]any)
+	if !ok {
+		return 0, nil, errors.New("cose: key is not a map")
+	}
+
+	kty, _ := m[int64(coseKeyKty)].(int64)
+	alg, _ := m[int64(coseKeyAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseKeyCrv)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		y, _ := m[int64(coseKeyY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, errors.New("cose: invalid P-256 key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return 0, nil, errors.New("cose: point is not on the curve")
+		}
+		return alg, pub, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseKeyCrv)].(int64)
+		x, _ := m[int64(coseKeyX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("cose: invalid Ed25519 key")
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseKeyN)].([]byte)
+		e, _ := m[int64(coseKeyE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, errors.New("cose: invalid RSA key")
+		}
+		return alg, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	default:
+		return 0, nil, fmt.Errorf("cose: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// verifySignature checks sig over data with a key returned by ParsePublicKey
+func verifySignature(alg int64, pub crypto.PublicKey, data, sig []byte) error {
+	switch alg {
+	case AlgES256:
+		key, ok := pub.(*ecdsa.PublicKey)
+		digest := sha256.Sum256(data)
+		if !ok || !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case AlgEdDSA:
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(key, data, sig) {
+			return errors.New("invalid signature")
+		}
+	case AlgRS256:
+		key, ok := pub.(*rsa.PublicKey)
+		digest := sha256.Sum256(data)
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) != nil {
+			return errors.New("invalid signature")
+		}
+	default:
+		return fmt.Errorf("unsupported algorithm %d", alg)
+	}
+	return nil
+}
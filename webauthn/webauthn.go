@@ -0,0 +1,229 @@
+// Package webauthn verifies passkey (WebAuthn Level 2) registration and authentication ceremonies
+// for a relying party. Attestation statements are not verified: the relying party asks for
+// "none" attestation and trusts any authenticator, like most websites do.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Ceremony types found in the client data
+const (
+	TypeCreate = "webauthn.create"
+	TypeGet    = "webauthn.get"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagAttestedData  = 0x40
+	flagExtensionData = 0x80
+)
+
+// RelyingParty is this site as seen by authenticators
+type RelyingParty struct {
+	ID      string   // effective domain, e.g. "example.com"
+	Origins []string // exact origins the browser may report, e.g. "https://example.com"
+}
+
+// ClientData is the part of clientDataJSON the relying party checks
+type ClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"` // base64url, as sent in the options
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Credential is a registered public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte // COSE_Key
+	Algorithm int64
+	SignCount uint32
+}
+
+// authenticatorData is the parsed authData structure
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// ParseClientData decodes clientDataJSON, e.g. to find the challenge it answers
+func ParseClientData(raw []byte) (ClientData, error) {
+	var cd ClientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ClientData{}, fmt.Errorf("invalid client data: %v", err)
+	}
+	return cd, nil
+}
+
+// verifyClientData checks type, challenge and origin of clientDataJSON
+func (rp RelyingParty) verifyClientData(raw []byte, wantType, challenge string) error {
+	cd, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if cd.Type != wantType {
+		return fmt.Errorf("unexpected client data type %q", cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return errors.New("challenge mismatch")
+	}
+	if cd.CrossOrigin {
+		return errors.New("cross-origin requests are not allowed")
+	}
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", cd.Origin)
+}
+
+// verifyAuthenticatorData parses authData and checks the RP ID hash, user presence and user verification.
+// Verification (PIN or biometrics) is required because a passkey login replaces both the password and the second factor.
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, errors.New("authenticator data too short")
+	}
+	ad := &authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	want := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(ad.rpIDHash, want[:]) {
+		return nil, errors.New("RP ID hash mismatch")
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return nil, errors.New("user was not present")
+	}
+	if ad.flags&flagUserVerified == 0 {
+		return nil, errors.New("user was not verified")
+	}
+
+	rest := raw[37:]
+	if ad.flags&flagAttestedData != 0 {
+		// aaguid (16) | credentialIdLength (2) | credentialId | credentialPublicKey (COSE_Key)
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data too short")
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > 1023 || len(rest) < idLen {
+			return nil, errors.New("invalid credential id length")
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %v", err)
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+	if ad.flags&flagExtensionData != 0 {
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extension data: %v", err)
+		}
+		rest = after
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("trailing data after authenticator data")
+	}
+	return ad, nil
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create() for challenge
+// and returns the new credential
+func (rp RelyingParty) VerifyRegistration(clientDataJSON, attestationObject []byte, challenge string) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, TypeCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	item, rest, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok || len(rest) != 0 {
+		return nil, errors.New("invalid attestation object")
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authData")
+	}
+
+	ad, err := rp.verifyAuthenticatorData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, errors.New("no attested credential data")
+	}
+
+	alg, _, err := ParsePublicKey(ad.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		Algorithm: alg,
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() made with cred for challenge
+// and returns the new signature counter to store
+func (rp RelyingParty) VerifyAssertion(cred Credential, clientDataJSON, authenticatorData, signature []byte, challenge string) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, TypeGet, challenge); err != nil {
+		return 0, err
+	}
+
+	ad, err := rp.verifyAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	alg, pub, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorData...), clientDataHash[:]...)
+	if err := verifySignature(alg, pub, signed, signature); err != nil {
+		return 0, err
+	}
+
+	// A counter that does not grow hints at a cloned authenticator; counters of 0 are not used
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, errors.New("signature counter did not increase, the authenticator may be cloned")
+	}
+	return ad.signCount, nil
+}
+
+// NewChallenge returns 32 random bytes, base64url encoded as it appears in the client data
+func NewChallenge() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"personal_site/webauthn/webauthntest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRP = RelyingParty{ID: "example.com", Origins: []string{"https://example.com"}}
+
+func TestDecodeCBOR(t *testing.T) {
+	// {1: 2, "a": [true, null, h'0102', -10, 1000]}
+	data := []byte{0xa2, 0x01, 0x02, 0x61, 'a', 0x85, 0xf5, 0xf6, 0x42, 0x01, 0x02, 0x29, 0x19, 0x03, 0xe8}
+	item, rest, err := decodeCBOR(data)
+	require.NoError(t, err)
+	assert.Empty(t, rest)
+	assert.Equal(t, map[any]any{
+		int64(1): int64(2),
+		"a":      []any{true, nil, []byte{1, 2}, int64(-10), int64(1000)},
+	}, item)
+
+	for name, bad := range map[string][]byte{
+		"truncated string":  {0x45, 0x01},
+		"truncated map":     {0xa2, 0x01},
+		"indefinite length": {0x5f},
+		"float":             {0xfa, 0, 0, 0, 0},
+		"array key":         {0xa1, 0x80, 0x01},
+		"huge array":        {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	} {
+		_, _, err := decodeCBOR(bad)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestCeremonies(t *testing.T) {
+	challenge, err := NewChallenge()
+	require.NoError(t, err)
+	authenticator := webauthntest.NewAuthenticator()
+
+	clientData, attestation := authenticator.Register("example.com", "https://example.com", challenge)
+	cred, err := testRP.VerifyRegistration(clientData, attestation, challenge)
+	require.NoError(t, err)
+	assert.Equal(t, authenticator.CredentialID, cred.ID)
+	assert.Equal(t, AlgES256, cred.Algorithm)
+
+	t.Run("Registration checks", func(t *testing.T) {
+		_, err := testRP.VerifyRegistration(clientData, attestation, "other-challenge")
+		assert.Error(t, err, "Challenge must match")
+
+		clientData, attestation := authenticator.Register("example.com", "https://evil.example", challenge)
+		_, err = testRP.VerifyRegistration(clientData, attestation, challenge)
+		assert.Error(t, err, "Origin must be allowed")
+
+		clientData, attestation = authenticator.Register("evil.example", "https://example.com", challenge)
+		_, err = testRP.VerifyRegistration(clientData, attestation, challenge)
+		assert.Error(t, err, "RP ID must match")
+
+		noPIN := webauthntest.NewAuthenticator()
+		noPIN.NoUserVerification = true
+		clientData, attestation = noPIN.Register("example.com", "https://example.com", challenge)
+		_, err = testRP.VerifyRegistration(clientData, attestation, challenge)
+		assert.Error(t, err, "The user must be verified")
+	})
+
+	t.Run("Assertion", func(t *testing.T) {
+		loginChallenge, err := NewChallenge()
+		require.NoError(t, err)
+
+		clientData, authData, sig := authenticator.Assert("example.com", "https://example.com", loginChallenge)
+		count, err := testRP.VerifyAssertion(*cred, clientData, authData, sig, loginChallenge)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1), count)
+		cred.SignCount = count
+
+		_, err = testRP.VerifyAssertion(*cred, clientData, authData, sig, loginChallenge)
+		assert.Error(t, err, "A replayed counter must be rejected")
+
+		clientData, authData, sig = authenticator.Assert("example.com", "https://example.com", loginChallenge)
+		sig[len(sig)-1] ^= 0xff
+		_, err = testRP.VerifyAssertion(*cred, clientData, authData, sig, loginChallenge)
+		assert.Error(t, err, "A bad signature must be rejected")
+
+		other := webauthntest.NewAuthenticator()
+		clientData, authData, sig = other.Assert("example.com", "https://example.com", loginChallenge)
+		_, err = testRP.VerifyAssertion(*cred, clientData, authData, sig, loginChallenge)
+		assert.Error(t, err, "Signatures of other keys must be rejected")
+
+		clientData, authData, sig = authenticator.Assert("example.com", "https://example.com", loginChallenge)
+		_, err = testRP.VerifyRegistration(clientData, authData, loginChallenge)
+		assert.Error(t, err, "An assertion is not a registration")
+
+		authenticator.NoUserVerification = true
+		clientData, authData, sig = authenticator.Assert("example.com", "https://example.com", loginChallenge)
+		_, err = testRP.VerifyAssertion(*cred, clientData, authData, sig, loginChallenge)
+		assert.Error(t, err, "Presence alone is not enough, the user must be verified")
+	})
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	// {1: 1, 3: -8, -1: 6, -2: x}
+	key := append([]byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}, pub...)
+
+	alg, parsed, err := ParsePublicKey(key)
+	require.NoError(t, err)
+	assert.Equal(t, AlgEdDSA, alg)
+	assert.Equal(t, ed25519.PublicKey(pub), parsed)
+
+	_, _, err = ParsePublicKey([]byte{0xa2, 0x01, 0x02, 0x03, 0x26})
+	assert.Error(t, err, "EC2 key without coordinates")
+
+	_, _, err = ParsePublicKey([]byte(base64.RawURLEncoding.EncodeToString(pub)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+// Package webauthntest provides a software authenticator for testing passkey ceremonies.
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+)
+
+// Authenticator holds one ES256 credential and signs like a security key or platform authenticator
+type Authenticator struct {
+	CredentialID []byte
+	Key          *ecdsa.PrivateKey
+	SignCount    uint32
+	// NoUserVerification makes it act like a security key without PIN, which only proves user presence
+	NoUserVerification bool
+}
+
+// NewAuthenticator creates an authenticator with a fresh credential
+func NewAuthenticator() *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return &Authenticator{CredentialID: id, Key: key}
+}
+
+// ClientData builds clientDataJSON as a browser would
+func ClientData(ceremonyType, challenge, origin string) []byte {
+	b, _ := json.Marshal(map[string]any{"type": ceremonyType, "challenge": challenge, "origin": origin, "crossOrigin": false})
+	return b
+}
+
+// Register answers navigator.credentials.create() and returns clientDataJSON and attestationObject
+func (a *Authenticator) Register(rpID, origin, challenge string) ([]byte, []byte) {
+	coseKey := encodeCBOR(map[int64]any{
+		1:  int64(2),  // kty: EC2
+		3:  int64(-7), // alg: ES256
+		-1: int64(1),  // crv: P-256
+		-2: pad32(a.Key.X.Bytes()),
+		-3: pad32(a.Key.Y.Bytes()),
+	})
+
+	authData := a.authData(rpID, 0x40)               // attested credential data
+	authData = append(authData, make([]byte, 16)...) // aaguid
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.CredentialID)))
+	authData = append(authData, a.CredentialID...)
+	authData = append(authData, coseKey...)
+
+	attestation := encodeCBOR(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	return ClientData("webauthn.create", challenge, origin), attestation
+}
+
+// Assert answers navigator.credentials.get() and returns clientDataJSON, authenticatorData and signature
+func (a *Authenticator) Assert(rpID, origin, challenge string) ([]byte, []byte, []byte) {
+	a.SignCount++
+	clientData := ClientData("webauthn.get", challenge, origin)
+	authData := a.authData(rpID, 0)
+
+	hash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.Key, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return clientData, authData, sig
+}
+
+// authData builds authenticator data with flags plus user present and, unless NoUserVerification, user verified
+func (a *Authenticator) authData(rpID string, flags byte) []byte {
+	flags |= 0x01
+	if !a.NoUserVerification {
+		flags |= 0x04
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	b := append([]byte(nil), rpIDHash[:]...)
+	b = append(b, flags)
+	return binary.BigEndian.AppendUint32(b, a.SignCount)
+}
+
+func pad32(b []byte) []byte {
+	return append(make([]byte, 32-len(b)), b...)
+}
+
+// encodeCBOR encodes the few types the authenticator needs, with map keys in canonical order
+func encodeCBOR(v any) []byte {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+		b := cborHead(5, uint64(len(v)))
+		for _, k := range keys {
+			b = append(b, encodeCBOR(k)...)
+			b = append(b, encodeCBOR(v[k])...)
+		}
+		return b
+	case map[int64]any:
+		keys := make([][]byte, 0, len(v))
+		values := map[string][]byte{}
+		for k, value := range v {
+			key := encodeCBOR(k)
+			keys = append(keys, key)
+			values[string(key)] = encodeCBOR(value)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return string(keys[i]) < string(keys[j])
+		})
+		b := cborHead(5, uint64(len(v)))
+		for _, key := range keys {
+			b = append(b, key...)
+			b = append(b, values[string(key)]...)
+		}
+		return b
+	default:
+		panic("webauthntest: unsupported CBOR type")
+	}
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+	}
+}